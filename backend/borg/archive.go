@@ -0,0 +1,296 @@
+package borg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// item is an entry of an archive as listed by "borg list --json-lines"
+type item struct {
+	Type       string `json:"type"`
+	Mode       string `json:"mode"`
+	Path       string `json:"path"`
+	LinkTarget string `json:"linktarget"`
+	MTime      string `json:"mtime"`
+	Size       int64  `json:"size"`
+}
+
+// node is a file or directory in an archive
+type node struct {
+	name       string
+	typ        string // borg's type, "d", "-", "l" etc
+	isDir      bool
+	size       int64
+	modTime    time.Time
+	linkTarget string
+	children   []*node // sorted by name, if isDir
+}
+
+// archiveTree holds the files of an archive indexed by path
+type archiveTree struct {
+	nodes map[string]*node // path to node, "" is the root
+}
+
+// dir returns the directory node at p, creating it and its parents
+// if they weren't listed
+func (t *archiveTree) dir(p string) *node {
+	if n := t.nodes[p]; n != nil {
+		return n
+	}
+	n := &node{name: path.Base(p), typ: "d", isDir: true}
+	t.nodes[p] = n
+	parent := t.dir(parentPath(p))
+	parent.children = append(parent.children, n)
+	return n
+}
+
+// add puts it into the tree
+func (t *archiveTree) add(it *item) {
+	p := cleanPath(it.Path)
+	if p == "" {
+		return
+	}
+	modTime := parseTime(it.MTime)
+	if it.Type == "d" {
+		n := t.dir(p)
+		n.modTime = modTime
+		return
+	}
+	n := &node{
+		name:       path.Base(p),
+		typ:        it.Type,
+		size:       it.Size,
+		modTime:    modTime,
+		linkTarget: it.LinkTarget,
+	}
+	if t.nodes[p] != nil {
+		// keep the first entry if a path is archived twice
+		fs.Debugf(nil, "borg: duplicate entry %q in archive", p)
+		return
+	}
+	t.nodes[p] = n
+	parent := t.dir(parentPath(p))
+	parent.children = append(parent.children, n)
+}
+
+// parentPath returns the directory p is in, "" for the root
+func parentPath(p string) string {
+	dir := path.Dir(p)
+	if dir == "." {
+		return ""
+	}
+	return dir
+}
+
+// tree returns the files of the archive, reading them the first time
+func (f *Fs) tree(ctx context.Context, archive string) (*archiveTree, error) {
+	f.mu.Lock()
+	t := f.trees[archive]
+	f.mu.Unlock()
+	if t != nil {
+		return t, nil
+	}
+	t, err := f.readTree(ctx, archive)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if old := f.trees[archive]; old != nil {
+		return old, nil
+	}
+	f.trees[archive] = t
+	return t, nil
+}
+
+// readTree lists all the files in archive
+func (f *Fs) readTree(ctx context.Context, archive string) (*archiveTree, error) {
+	fs.Debugf(f, "Reading archive %q", archive)
+	args := []string{"list", "--json-lines", f.archiveRef(archive)}
+	var stderr bytes.Buffer
+	cmd := f.command(ctx, args...)
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, commandError(args, err, &stderr)
+	}
+	t := &archiveTree{nodes: map[string]*node{
+		"": {typ: "d", isDir: true},
+	}}
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(nil, 1024*1024)
+	var parseErr error
+	for scanner.Scan() {
+		var it item
+		if err := json.Unmarshal(scanner.Bytes(), &it); err != nil {
+			parseErr = fmt.Errorf("borg list: bad output: %w", err)
+			break
+		}
+		t.add(&it)
+	}
+	if parseErr == nil {
+		parseErr = scanner.Err()
+	}
+	// drain the output so the command can finish
+	_, _ = io.Copy(io.Discard, stdout)
+	if err := cmd.Wait(); err != nil {
+		return nil, commandError(args, err, &stderr)
+	}
+	if parseErr != nil {
+		return nil, parseErr
+	}
+	for _, n := range t.nodes {
+		sort.Slice(n.children, func(i, j int) bool {
+			return n.children[i].name < n.children[j].name
+		})
+	}
+	return t, nil
+}
+
+// extractReader reads a file streamed by "borg extract --stdout"
+type extractReader struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	args   []string
+	stderr *bytes.Buffer
+	cancel context.CancelFunc
+	eof    bool // set if the file was read to the end
+	closed bool
+}
+
+// Read the file, noting when it has been read to the end
+func (r *extractReader) Read(p []byte) (n int, err error) {
+	n, err = r.ReadCloser.Read(p)
+	if err == io.EOF {
+		r.eof = true
+	}
+	return n, err
+}
+
+// Close the output and wait for borg to finish
+//
+// borg is stopped if the file wasn't read to the end, otherwise any
+// error it exited with is returned.
+func (r *extractReader) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	if !r.eof {
+		r.cancel()
+	}
+	_ = r.ReadCloser.Close()
+	err := r.cmd.Wait()
+	r.cancel()
+	if err != nil && r.eof {
+		return commandError(r.args, err, r.stderr)
+	}
+	return nil
+}
+
+// extract streams the file at p in archive
+func (f *Fs) extract(ctx context.Context, archive, p string) (io.ReadCloser, error) {
+	args := []string{"extract", "--stdout", f.archiveRef(archive), "pf:" + p}
+	stderr := new(bytes.Buffer)
+	ctx, cancel := context.WithCancel(ctx)
+	cmd := f.command(ctx, args...)
+	cmd.Stderr = stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return nil, commandError(args, err, stderr)
+	}
+	return &extractReader{
+		ReadCloser: stdout,
+		cmd:        cmd,
+		args:       args,
+		stderr:     stderr,
+		cancel:     cancel,
+	}, nil
+}
+
+var commandHelp = []fs.CommandHelp{{
+	Name:  "archives",
+	Short: "List the archives in the repository.",
+	Long: `This command lists the archives in the repository, oldest first,
+with their names, IDs and times. The archive the remote is showing is
+marked as active.
+
+Usage Example:
+
+    rclone backend archives borg:
+
+The names or IDs can be used with --borg-archive.
+`,
+}}
+
+// archiveListing is an archive as returned by the archives command
+type archiveListing struct {
+	Name   string    `json:"name"`
+	ID     string    `json:"id"`
+	Time   time.Time `json:"time"`
+	Active bool      `json:"active,omitempty"`
+}
+
+// archiveList returns the archives for the archives command
+func (f *Fs) archiveList(ctx context.Context) ([]archiveListing, error) {
+	archives, err := f.listArchives(ctx)
+	if err != nil {
+		return nil, err
+	}
+	active := ""
+	if !f.opt.ArchiveDirs {
+		if a, err := f.findArchive(ctx, f.opt.Archive); err == nil {
+			active = a.ID
+		}
+	}
+	out := make([]archiveListing, 0, len(archives))
+	for _, a := range archives {
+		out = append(out, archiveListing{
+			Name:   a.Name,
+			ID:     a.ID,
+			Time:   parseTime(a.Start),
+			Active: a.ID == active,
+		})
+	}
+	return out, nil
+}
+
+// Command the backend to run a named command
+//
+// The command run is name
+// args may be used to read arguments from
+// opts may be used to read optional arguments from
+//
+// The result should be capable of being JSON encoded
+// If it is a string or a []string it will be shown to the user
+// otherwise it will be JSON encoded and shown to the user like that
+func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[string]string) (out interface{}, err error) {
+	switch name {
+	case "archives":
+		if len(arg) > 0 {
+			return nil, errors.New("archives takes no arguments")
+		}
+		return f.archiveList(ctx)
+	default:
+		return nil, fs.ErrorCommandNotFound
+	}
+}
@@ -0,0 +1,559 @@
+// Package borg provides read only access to the archives in a Borg
+// backup repository
+package borg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/configstruct"
+	"github.com/rclone/rclone/fs/config/obscure"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/lib/readers"
+)
+
+const linkSuffix = ".rclonelink" // The suffix added to a translated symbolic link
+
+// borgTimeFormat is the format of the times in borg's JSON output,
+// which are in local time
+const borgTimeFormat = "2006-01-02T15:04:05.999999"
+
+// Register with Fs
+func init() {
+	fs.Register(&fs.RegInfo{
+		Name:        "borg",
+		Description: "Borg backup archives",
+		NewFs:       NewFs,
+		CommandHelp: commandHelp,
+		Options: []fs.Option{{
+			Name: "repo",
+			Help: `Borg repository to read.
+
+This is anything the borg command accepts as a repository, eg a local
+path or ssh://user@host/path/to/repo.`,
+			Required:  true,
+			Sensitive: true,
+		}, {
+			Name:       "passphrase",
+			Help:       "Passphrase of the repository, if it is encrypted.",
+			IsPassword: true,
+		}, {
+			Name:    "archive",
+			Help:    "Archive to show, by name or ID, or latest for the most recent one.",
+			Default: "latest",
+			Examples: []fs.OptionExample{{
+				Value: "latest",
+			}, {
+				Value: "host-2024-08-29T12:00:00",
+			}},
+			Sensitive: true,
+		}, {
+			Name: "archive_dirs",
+			Help: `Show every archive in the repository as a directory in the root.
+
+If this is set the root of the remote has a directory for each
+archive, named after the archive, and the archive option is ignored.
+An archive ID can be used in place of the directory name.`,
+			Default:  false,
+			Advanced: true,
+		}, {
+			Name: "borg_command",
+			Help: `Path to the borg command.
+
+The borg command is run to list the archives and read the files from
+them, so it must be installed where rclone runs.`,
+			Default:  "borg",
+			Advanced: true,
+		}, {
+			Name:     "links",
+			Help:     "Translate symlinks to regular files with a '" + linkSuffix + "' extension.",
+			Default:  false,
+			NoPrefix: true,
+			ShortOpt: "l",
+			Advanced: true,
+		}},
+	})
+}
+
+// Options defines the configuration for this backend
+type Options struct {
+	Repo           string `config:"repo"`
+	Passphrase     string `config:"passphrase"`
+	Archive        string `config:"archive"`
+	ArchiveDirs    bool   `config:"archive_dirs"`
+	Command        string `config:"borg_command"`
+	TranslateLinks bool   `config:"links"`
+}
+
+// Fs represents the archives of a borg repository
+type Fs struct {
+	name     string
+	root     string
+	opt      Options
+	features *fs.Features
+
+	mu       sync.Mutex              // protects the following
+	archives []archiveInfo           // archives in the repository, once read
+	trees    map[string]*archiveTree // archive name to its files, once read
+}
+
+// Object describes a file in an archive
+type Object struct {
+	fs      *Fs
+	remote  string
+	archive string // name of the archive it is in
+	path    string // path in the archive
+	size    int64
+	modTime time.Time
+	link    string // target if this is a translated symlink
+}
+
+// errReadOnly is returned by anything which would change the
+// repository
+var errReadOnly = fmt.Errorf("borg remotes are read only: %w", fs.ErrorPermissionDenied)
+
+// NewFs creates a new Fs object from the name and root
+func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, error) {
+	opt := new(Options)
+	err := configstruct.Set(m, opt)
+	if err != nil {
+		return nil, err
+	}
+	if opt.Passphrase != "" {
+		opt.Passphrase, err = obscure.Reveal(opt.Passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("borg: couldn't decrypt passphrase: %w", err)
+		}
+	}
+	f := &Fs{
+		name:  name,
+		root:  cleanPath(root),
+		opt:   *opt,
+		trees: map[string]*archiveTree{},
+	}
+	f.features = (&fs.Features{
+		CanHaveEmptyDirectories: true,
+		ReadOnly:                true,
+	}).Fill(ctx, f)
+	if f.root != "" {
+		archive, inner, err := f.resolve(ctx, f.root)
+		if err != nil {
+			return nil, err
+		}
+		if archive != "" && inner != "" {
+			tree, err := f.tree(ctx, archive)
+			if err != nil {
+				return nil, err
+			}
+			if n := tree.nodes[inner]; n != nil && !n.isDir {
+				f.root = path.Dir(f.root)
+				if f.root == "." {
+					f.root = ""
+				}
+				return f, fs.ErrorIsFile
+			}
+		}
+	}
+	return f, nil
+}
+
+// cleanPath makes p into the form used for remotes, with no leading
+// or trailing slashes
+func cleanPath(p string) string {
+	if p != "" {
+		p = strings.Trim(path.Clean(p), "/")
+	}
+	if p == "." {
+		p = ""
+	}
+	return p
+}
+
+// command makes a borg command with the arguments given
+func (f *Fs) command(ctx context.Context, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, f.opt.Command, args...)
+	cmd.Env = os.Environ()
+	if f.opt.Passphrase != "" {
+		cmd.Env = append(cmd.Env, "BORG_PASSPHRASE="+f.opt.Passphrase)
+	}
+	return cmd
+}
+
+// commandError makes an error from a failed borg command including
+// what it wrote to stderr
+func commandError(args []string, err error, stderr *bytes.Buffer) error {
+	msg := strings.TrimSpace(stderr.String())
+	if msg == "" {
+		return fmt.Errorf("borg %s failed: %w", args[0], err)
+	}
+	return fmt.Errorf("borg %s failed: %w: %s", args[0], err, msg)
+}
+
+// archiveRef returns the borg reference to archive in the repository
+func (f *Fs) archiveRef(archive string) string {
+	return f.opt.Repo + "::" + archive
+}
+
+// archiveInfo is an archive as listed by "borg list --json"
+type archiveInfo struct {
+	Name  string `json:"name"`
+	ID    string `json:"id"`
+	Start string `json:"start"`
+	Time  string `json:"time"`
+}
+
+// listResponse is the output of "borg list --json"
+type listResponse struct {
+	Archives []archiveInfo `json:"archives"`
+}
+
+// listArchives returns the archives in the repository, oldest first,
+// reading them the first time
+func (f *Fs) listArchives(ctx context.Context) ([]archiveInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.archives != nil {
+		return f.archives, nil
+	}
+	args := []string{"list", "--json", f.opt.Repo}
+	var stdout, stderr bytes.Buffer
+	cmd := f.command(ctx, args...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, commandError(args, err, &stderr)
+	}
+	var result listResponse
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+		return nil, fmt.Errorf("borg list: bad output: %w", err)
+	}
+	f.archives = result.Archives
+	if f.archives == nil {
+		f.archives = []archiveInfo{}
+	}
+	return f.archives, nil
+}
+
+// findArchive returns the archive selected by spec, which can be a
+// name, an ID or "latest"
+func (f *Fs) findArchive(ctx context.Context, spec string) (*archiveInfo, error) {
+	archives, err := f.listArchives(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(archives) - 1; i >= 0; i-- {
+		a := &archives[i]
+		if spec == "latest" || spec == "" || spec == a.Name || spec == a.ID {
+			return a, nil
+		}
+	}
+	return nil, fmt.Errorf("borg archive %q not found", spec)
+}
+
+// resolve splits the remote p into the name of the archive it is in
+// and the path inside it. With archive_dirs the archive is "" for the
+// root.
+func (f *Fs) resolve(ctx context.Context, p string) (archive, inner string, err error) {
+	if !f.opt.ArchiveDirs {
+		a, err := f.findArchive(ctx, f.opt.Archive)
+		if err != nil {
+			return "", "", err
+		}
+		return a.Name, p, nil
+	}
+	if p == "" {
+		return "", "", nil
+	}
+	first, rest, _ := strings.Cut(p, "/")
+	a, err := f.findArchive(ctx, first)
+	if err != nil || first == "latest" {
+		// only names and IDs select directories
+		return "", "", fs.ErrorDirNotFound
+	}
+	return a.Name, rest, nil
+}
+
+// Name of the remote (as passed into NewFs)
+func (f *Fs) Name() string {
+	return f.name
+}
+
+// Root of the remote (as passed into NewFs)
+func (f *Fs) Root() string {
+	return f.root
+}
+
+// String converts this Fs to a string
+func (f *Fs) String() string {
+	return fmt.Sprintf("borg repository %s", f.opt.Repo)
+}
+
+// Precision of the ModTimes in this Fs
+func (f *Fs) Precision() time.Duration {
+	return time.Microsecond
+}
+
+// Hashes returns the supported hash sets
+func (f *Fs) Hashes() hash.Set {
+	return hash.Set(hash.None)
+}
+
+// Features returns the optional features of this Fs
+func (f *Fs) Features() *fs.Features {
+	return f.features
+}
+
+// List the objects and directories in dir into entries.  The
+// entries can be returned in any order but should be for a
+// complete directory.
+//
+// dir should be "" to list the root, and should not have
+// trailing slashes.
+//
+// This should return ErrDirNotFound if the directory isn't
+// found.
+func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+	archive, inner, err := f.resolve(ctx, path.Join(f.root, dir))
+	if err != nil {
+		return nil, err
+	}
+	if archive == "" {
+		archives, err := f.listArchives(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range archives {
+			entries = append(entries, fs.NewDir(path.Join(dir, a.Name), parseTime(a.Start)).SetID(a.ID))
+		}
+		return entries, nil
+	}
+	tree, err := f.tree(ctx, archive)
+	if err != nil {
+		return nil, err
+	}
+	n := tree.nodes[inner]
+	if n == nil || !n.isDir {
+		return nil, fs.ErrorDirNotFound
+	}
+	for _, child := range n.children {
+		remote := path.Join(dir, child.name)
+		if child.isDir {
+			entries = append(entries, fs.NewDir(remote, child.modTime))
+			continue
+		}
+		if o := f.newObject(remote, archive, path.Join(inner, child.name), child); o != nil {
+			entries = append(entries, o)
+		}
+	}
+	return entries, nil
+}
+
+// newObject makes an Object for the file node n, returning nil if it
+// should be skipped
+func (f *Fs) newObject(remote, archive, inner string, n *node) *Object {
+	o := &Object{
+		fs:      f,
+		remote:  remote,
+		archive: archive,
+		path:    inner,
+		size:    n.size,
+		modTime: n.modTime,
+	}
+	switch n.typ {
+	case "l":
+		if !f.opt.TranslateLinks {
+			fs.Logf(f, "Skipping symlink %q: use -l/--links to translate it", remote)
+			return nil
+		}
+		o.remote += linkSuffix
+		o.link = n.linkTarget
+		o.size = int64(len(n.linkTarget))
+	case "-", "h":
+	default:
+		fs.Debugf(f, "Skipping special file %q of type %q", remote, n.typ)
+		return nil
+	}
+	return o
+}
+
+// NewObject finds the Object at remote.  If it can't be found
+// it returns the error fs.ErrorObjectNotFound.
+func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	full := path.Join(f.root, remote)
+	archive, inner, err := f.resolve(ctx, full)
+	if errors.Is(err, fs.ErrorDirNotFound) {
+		return nil, fs.ErrorObjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if archive == "" || inner == "" {
+		return nil, fs.ErrorIsDir
+	}
+	tree, err := f.tree(ctx, archive)
+	if err != nil {
+		return nil, err
+	}
+	n := tree.nodes[inner]
+	if n == nil && f.opt.TranslateLinks && strings.HasSuffix(inner, linkSuffix) {
+		inner = strings.TrimSuffix(inner, linkSuffix)
+		remote = strings.TrimSuffix(remote, linkSuffix)
+		if n = tree.nodes[inner]; n != nil && n.typ != "l" {
+			n = nil
+		}
+	}
+	if n == nil {
+		return nil, fs.ErrorObjectNotFound
+	}
+	if n.isDir {
+		return nil, fs.ErrorIsDir
+	}
+	o := f.newObject(remote, archive, inner, n)
+	if o == nil {
+		return nil, fs.ErrorObjectNotFound
+	}
+	return o, nil
+}
+
+// Put is not supported as borg remotes are read only
+func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	return nil, fserrors.FatalError(errReadOnly)
+}
+
+// Mkdir is not supported as borg remotes are read only
+func (f *Fs) Mkdir(ctx context.Context, dir string) error {
+	return fserrors.FatalError(errReadOnly)
+}
+
+// Rmdir is not supported as borg remotes are read only
+func (f *Fs) Rmdir(ctx context.Context, dir string) error {
+	return fserrors.FatalError(errReadOnly)
+}
+
+// parseTime parses a time from borg's JSON output
+func parseTime(s string) time.Time {
+	t, err := time.ParseInLocation(borgTimeFormat, s, time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// ------------------------------------------------------------
+
+// Fs returns the parent Fs
+func (o *Object) Fs() fs.Info {
+	return o.fs
+}
+
+// Return a string version
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.remote
+}
+
+// Remote returns the remote path
+func (o *Object) Remote() string {
+	return o.remote
+}
+
+// Hash returns the selected checksum of the file
+func (o *Object) Hash(ctx context.Context, ty hash.Type) (string, error) {
+	return "", hash.ErrUnsupported
+}
+
+// Size returns the size of an object in bytes
+func (o *Object) Size() int64 {
+	return o.size
+}
+
+// ModTime returns the modification time of the object
+func (o *Object) ModTime(ctx context.Context) time.Time {
+	return o.modTime
+}
+
+// SetModTime is not supported as borg remotes are read only
+func (o *Object) SetModTime(ctx context.Context, modTime time.Time) error {
+	return fs.ErrorCantSetModTime
+}
+
+// Storable returns whether this object is storable
+func (o *Object) Storable() bool {
+	return true
+}
+
+// ID returns the path of the object in the archive
+func (o *Object) ID() string {
+	return o.archive + "::" + o.path
+}
+
+// Open an object for read
+func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error) {
+	var offset, limit int64 = 0, -1
+	fs.FixRangeOption(options, o.size)
+	for _, option := range options {
+		switch x := option.(type) {
+		case *fs.RangeOption:
+			offset, limit = x.Decode(o.size)
+		case *fs.SeekOption:
+			offset = x.Offset
+		default:
+			if option.Mandatory() {
+				fs.Logf(o, "Unsupported mandatory option: %v", option)
+			}
+		}
+	}
+	var in io.ReadCloser
+	if o.link != "" {
+		in = io.NopCloser(strings.NewReader(o.link))
+	} else {
+		var err error
+		in, err = o.fs.extract(ctx, o.archive, o.path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// borg can only stream the file from the start
+	if offset > 0 {
+		if _, err := io.CopyN(io.Discard, in, offset); err != nil {
+			_ = in.Close()
+			return nil, fmt.Errorf("failed to seek to %d: %w", offset, err)
+		}
+	}
+	if limit >= 0 {
+		in = readers.NewLimitedReadCloser(in, limit)
+	}
+	return in, nil
+}
+
+// Update is not supported as borg remotes are read only
+func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	return fserrors.FatalError(errReadOnly)
+}
+
+// Remove is not supported as borg remotes are read only
+func (o *Object) Remove(ctx context.Context) error {
+	return fserrors.FatalError(errReadOnly)
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs        = &Fs{}
+	_ fs.Commander = &Fs{}
+	_ fs.Object    = &Object{}
+	_ fs.IDer      = &Object{}
+)
@@ -0,0 +1,253 @@
+package borg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/obscure"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeEnv is set to run the test binary as a fake borg command
+const fakeEnv = "RCLONE_TEST_FAKE_BORG"
+
+var fakeArchives = []archiveInfo{
+	{Name: "host-1", ID: "aaaa", Start: "2024-08-28T12:00:00.000000"},
+	{Name: "host-2", ID: "bbbb", Start: "2024-08-29T12:00:00.000000"},
+}
+
+var fakeItems = map[string][]item{
+	"host-1": {
+		{Type: "d", Path: "home", MTime: "2024-08-28T10:00:00.000000"},
+		{Type: "-", Path: "home/file.txt", MTime: "2024-08-28T11:00:00.500000", Size: 3},
+	},
+	"host-2": {
+		{Type: "d", Path: "home", MTime: "2024-08-29T10:00:00.000000"},
+		{Type: "-", Path: "home/file.txt", MTime: "2024-08-29T11:00:00.500000", Size: 11},
+		{Type: "l", Path: "home/link", LinkTarget: "file.txt", MTime: "2024-08-29T11:00:00.000000"},
+		{Type: "-", Path: "home/sub/deep.txt", MTime: "2024-08-29T11:00:00.000000", Size: 4},
+		{Type: "d", Path: "empty", MTime: "2024-08-29T10:00:00.000000"},
+	},
+}
+
+var fakeData = map[string]string{
+	"host-1::home/file.txt":     "old",
+	"host-2::home/file.txt":     "hello world",
+	"host-2::home/sub/deep.txt": "deep",
+}
+
+// fakeBorg acts as the borg command for the arguments given
+func fakeBorg(args []string) int {
+	if os.Getenv("BORG_PASSPHRASE") != "secret" {
+		fmt.Fprintln(os.Stderr, "passphrase supplied in BORG_PASSPHRASE is incorrect")
+		return 2
+	}
+	if len(args) < 3 {
+		return 2
+	}
+	repo, archive, _ := strings.Cut(args[2], "::")
+	if repo != "/repo" {
+		fmt.Fprintf(os.Stderr, "Repository %s does not exist.\n", repo)
+		return 2
+	}
+	switch {
+	case args[0] == "list" && args[1] == "--json":
+		_ = json.NewEncoder(os.Stdout).Encode(listResponse{Archives: fakeArchives})
+	case args[0] == "list" && args[1] == "--json-lines":
+		items, ok := fakeItems[archive]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Archive %s does not exist\n", archive)
+			return 2
+		}
+		for _, it := range items {
+			_ = json.NewEncoder(os.Stdout).Encode(it)
+		}
+	case args[0] == "extract" && args[1] == "--stdout" && len(args) == 4:
+		data, ok := fakeData[archive+"::"+strings.TrimPrefix(args[3], "pf:")]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Include pattern %s never matched.\n", args[3])
+			return 1
+		}
+		_, _ = io.WriteString(os.Stdout, data)
+	default:
+		fmt.Fprintf(os.Stderr, "unexpected arguments %q\n", args)
+		return 2
+	}
+	return 0
+}
+
+func TestMain(m *testing.M) {
+	if os.Getenv(fakeEnv) != "" {
+		os.Exit(fakeBorg(os.Args[1:]))
+	}
+	os.Exit(m.Run())
+}
+
+func newTestFs(t *testing.T, root string, extra configmap.Simple) (*Fs, error) {
+	t.Setenv(fakeEnv, "1")
+	m := configmap.Simple{
+		"type":         "borg",
+		"repo":         "/repo",
+		"passphrase":   obscure.MustObscure("secret"),
+		"borg_command": os.Args[0],
+	}
+	for k, v := range extra {
+		m[k] = v
+	}
+	regInfo, err := fs.Find("borg")
+	require.NoError(t, err)
+	f, err := NewFs(context.Background(), "TestBorg", root, fs.ConfigMap(regInfo.Prefix, regInfo.Options, "", m))
+	if f == nil {
+		return nil, err
+	}
+	return f.(*Fs), err
+}
+
+// listNames returns the remotes in dir
+func listNames(t *testing.T, f *Fs, dir string) (names []string) {
+	entries, err := f.List(context.Background(), dir)
+	require.NoError(t, err)
+	for _, entry := range entries {
+		names = append(names, entry.Remote())
+	}
+	return names
+}
+
+func readObject(t *testing.T, f *Fs, remote string, options ...fs.OpenOption) string {
+	ctx := context.Background()
+	o, err := f.NewObject(ctx, remote)
+	require.NoError(t, err)
+	in, err := o.Open(ctx, options...)
+	require.NoError(t, err)
+	data, err := io.ReadAll(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	return string(data)
+}
+
+func TestList(t *testing.T) {
+	ctx := context.Background()
+	f, err := newTestFs(t, "", nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"empty", "home"}, listNames(t, f, ""))
+	assert.Equal(t, []string{"home/file.txt", "home/sub"}, listNames(t, f, "home"))
+	assert.Equal(t, []string{"home/sub/deep.txt"}, listNames(t, f, "home/sub"))
+	assert.Empty(t, listNames(t, f, "empty"))
+
+	_, err = f.List(ctx, "missing")
+	assert.ErrorIs(t, err, fs.ErrorDirNotFound)
+	_, err = f.List(ctx, "home/file.txt")
+	assert.ErrorIs(t, err, fs.ErrorDirNotFound)
+
+	o, err := f.NewObject(ctx, "home/file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), o.Size())
+	assert.Equal(t, parseTime("2024-08-29T11:00:00.500000"), o.ModTime(ctx))
+
+	_, err = f.NewObject(ctx, "home/missing")
+	assert.ErrorIs(t, err, fs.ErrorObjectNotFound)
+	_, err = f.NewObject(ctx, "home")
+	assert.ErrorIs(t, err, fs.ErrorIsDir)
+}
+
+func TestRead(t *testing.T) {
+	f, err := newTestFs(t, "home", nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, "hello world", readObject(t, f, "file.txt"))
+	assert.Equal(t, "world", readObject(t, f, "file.txt", &fs.SeekOption{Offset: 6}))
+	assert.Equal(t, "lo w", readObject(t, f, "file.txt", &fs.RangeOption{Start: 3, End: 6}))
+
+	// closing before the end stops borg without an error
+	o, err := f.NewObject(context.Background(), "file.txt")
+	require.NoError(t, err)
+	in, err := o.Open(context.Background())
+	require.NoError(t, err)
+	assert.NoError(t, in.Close())
+}
+
+func TestArchive(t *testing.T) {
+	f, err := newTestFs(t, "home", configmap.Simple{"archive": "host-1"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"file.txt"}, listNames(t, f, ""))
+	assert.Equal(t, "old", readObject(t, f, "file.txt"))
+
+	f, err = newTestFs(t, "home", configmap.Simple{"archive": "aaaa"})
+	require.NoError(t, err)
+	assert.Equal(t, "old", readObject(t, f, "file.txt"))
+
+	_, err = newTestFs(t, "home", configmap.Simple{"archive": "missing"})
+	assert.ErrorContains(t, err, "not found")
+}
+
+func TestRootIsFile(t *testing.T) {
+	f, err := newTestFs(t, "home/file.txt", nil)
+	assert.ErrorIs(t, err, fs.ErrorIsFile)
+	require.NotNil(t, f)
+	assert.Equal(t, "home", f.Root())
+	assert.Equal(t, "hello world", readObject(t, f, "file.txt"))
+}
+
+func TestLinks(t *testing.T) {
+	f, err := newTestFs(t, "home", nil)
+	require.NoError(t, err)
+	assert.NotContains(t, listNames(t, f, ""), "link")
+
+	f, err = newTestFs(t, "home", configmap.Simple{"links": "true"})
+	require.NoError(t, err)
+	assert.Contains(t, listNames(t, f, ""), "link"+linkSuffix)
+	assert.Equal(t, "file.txt", readObject(t, f, "link"+linkSuffix))
+}
+
+func TestArchiveDirs(t *testing.T) {
+	f, err := newTestFs(t, "", configmap.Simple{"archive_dirs": "true"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"host-1", "host-2"}, listNames(t, f, ""))
+	assert.Equal(t, []string{"host-1/home/file.txt"}, listNames(t, f, "host-1/home"))
+	assert.Equal(t, "old", readObject(t, f, "host-1/home/file.txt"))
+	assert.Equal(t, "hello world", readObject(t, f, "bbbb/home/file.txt"))
+
+	_, err = f.List(context.Background(), "missing")
+	assert.ErrorIs(t, err, fs.ErrorDirNotFound)
+	_, err = f.NewObject(context.Background(), "missing/file.txt")
+	assert.ErrorIs(t, err, fs.ErrorObjectNotFound)
+}
+
+func TestReadOnly(t *testing.T) {
+	ctx := context.Background()
+	f, err := newTestFs(t, "", nil)
+	require.NoError(t, err)
+	assert.ErrorIs(t, f.Mkdir(ctx, "new"), fs.ErrorPermissionDenied)
+	assert.ErrorIs(t, f.Rmdir(ctx, "empty"), fs.ErrorPermissionDenied)
+	o, err := f.NewObject(ctx, "home/file.txt")
+	require.NoError(t, err)
+	assert.ErrorIs(t, o.Remove(ctx), fs.ErrorPermissionDenied)
+}
+
+func TestErrors(t *testing.T) {
+	f, err := newTestFs(t, "", configmap.Simple{"passphrase": obscure.MustObscure("wrong")})
+	require.NoError(t, err)
+	_, err = f.List(context.Background(), "")
+	assert.ErrorContains(t, err, "passphrase supplied")
+}
+
+func TestArchivesCommand(t *testing.T) {
+	f, err := newTestFs(t, "", configmap.Simple{"archive": "host-1"})
+	require.NoError(t, err)
+	out, err := f.Command(context.Background(), "archives", nil, nil)
+	require.NoError(t, err)
+	archives := out.([]archiveListing)
+	require.Len(t, archives, 2)
+	assert.True(t, archives[0].Active)
+	assert.False(t, archives[1].Active)
+	assert.Equal(t, "bbbb", archives[1].ID)
+}
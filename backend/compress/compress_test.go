@@ -26,6 +26,7 @@ var defaultOpt = fstests.Opt{
 		"PutStream",
 		"UserInfo",
 		"Disconnect",
+		"DirSize",
 	},
 	TiersToTest:                  []string{"STANDARD", "STANDARD_IA"},
 	UnimplementableObjectMethods: []string{},
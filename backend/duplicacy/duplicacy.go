@@ -0,0 +1,482 @@
+// Package duplicacy provides read only access to the revisions in a
+// Duplicacy storage
+package duplicacy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/configstruct"
+	"github.com/rclone/rclone/fs/config/obscure"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/lib/readers"
+)
+
+// Register with Fs
+func init() {
+	fs.Register(&fs.RegInfo{
+		Name:        "duplicacy",
+		Description: "Duplicacy backup revisions",
+		NewFs:       NewFs,
+		CommandHelp: commandHelp,
+		Options: []fs.Option{{
+			Name: "repository",
+			Help: `Local directory initialised for the storage with "duplicacy init".
+
+The duplicacy command is run in this directory so it finds the
+storage and snapshot ID in its .duplicacy/preferences file.`,
+			Required: true,
+		}, {
+			Name: "storage",
+			Help: `Name of the storage to read, if not the default one.`,
+		}, {
+			Name:       "password",
+			Help:       "Password of the storage, if it is encrypted.",
+			IsPassword: true,
+		}, {
+			Name: "snapshot_id",
+			Help: `Snapshot ID to show, if not the one of the repository.`,
+		}, {
+			Name:    "revision",
+			Help:    "Revision to show, by number, or latest for the most recent one.",
+			Default: "latest",
+			Examples: []fs.OptionExample{{
+				Value: "latest",
+			}, {
+				Value: "1",
+			}},
+		}, {
+			Name: "revision_dirs",
+			Help: `Show every revision as a directory in the root.
+
+If this is set the root of the remote has a directory for each
+revision, named after its number, and the revision option is ignored.`,
+			Default:  false,
+			Advanced: true,
+		}, {
+			Name: "duplicacy_command",
+			Help: `Path to the duplicacy command.
+
+The duplicacy command is run to list the revisions and read the files
+from them, so it must be installed where rclone runs.`,
+			Default:  "duplicacy",
+			Advanced: true,
+		}},
+	})
+}
+
+// Options defines the configuration for this backend
+type Options struct {
+	Repository   string `config:"repository"`
+	Storage      string `config:"storage"`
+	Password     string `config:"password"`
+	SnapshotID   string `config:"snapshot_id"`
+	Revision     string `config:"revision"`
+	RevisionDirs bool   `config:"revision_dirs"`
+	Command      string `config:"duplicacy_command"`
+}
+
+// Fs represents the revisions of a duplicacy snapshot ID
+type Fs struct {
+	name     string
+	root     string
+	opt      Options
+	features *fs.Features
+
+	mu        sync.Mutex            // protects the following
+	revisions []revisionInfo        // revisions in the storage, once read
+	trees     map[int]*revisionTree // revision to its files, once read
+}
+
+// Object describes a file in a revision
+type Object struct {
+	fs       *Fs
+	remote   string
+	revision int    // revision the file is in
+	path     string // path in the revision
+	size     int64
+	modTime  time.Time
+}
+
+// errReadOnly is returned by anything which would change the storage
+var errReadOnly = fmt.Errorf("duplicacy remotes are read only: %w", fs.ErrorPermissionDenied)
+
+// NewFs creates a new Fs object from the name and root
+func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, error) {
+	opt := new(Options)
+	err := configstruct.Set(m, opt)
+	if err != nil {
+		return nil, err
+	}
+	if opt.Password != "" {
+		opt.Password, err = obscure.Reveal(opt.Password)
+		if err != nil {
+			return nil, fmt.Errorf("duplicacy: couldn't decrypt password: %w", err)
+		}
+	}
+	if opt.Revision != "latest" && opt.Revision != "" {
+		if _, err := strconv.Atoi(opt.Revision); err != nil {
+			return nil, fmt.Errorf("duplicacy: revision must be a number or latest: %q", opt.Revision)
+		}
+	}
+	f := &Fs{
+		name:  name,
+		root:  cleanPath(root),
+		opt:   *opt,
+		trees: map[int]*revisionTree{},
+	}
+	f.features = (&fs.Features{
+		ReadOnly: true,
+	}).Fill(ctx, f)
+	if f.root != "" {
+		revision, inner, err := f.resolve(ctx, f.root)
+		if err != nil {
+			return nil, err
+		}
+		if revision != 0 && inner != "" {
+			tree, err := f.tree(ctx, revision)
+			if err != nil {
+				return nil, err
+			}
+			if n := tree.nodes[inner]; n != nil && !n.isDir {
+				f.root = parentPath(f.root)
+				return f, fs.ErrorIsFile
+			}
+		}
+	}
+	return f, nil
+}
+
+// cleanPath makes p into the form used for remotes, with no leading
+// or trailing slashes
+func cleanPath(p string) string {
+	if p != "" {
+		p = strings.Trim(path.Clean(p), "/")
+	}
+	if p == "." {
+		p = ""
+	}
+	return p
+}
+
+// parentPath returns the directory p is in, "" for the root
+func parentPath(p string) string {
+	dir := path.Dir(p)
+	if dir == "." {
+		return ""
+	}
+	return dir
+}
+
+// passwordEnv returns the environment variable duplicacy reads the
+// password of the storage from
+func (f *Fs) passwordEnv() string {
+	if f.opt.Storage == "" || f.opt.Storage == "default" {
+		return "DUPLICACY_PASSWORD"
+	}
+	return "DUPLICACY_" + strings.ToUpper(f.opt.Storage) + "_PASSWORD"
+}
+
+// command makes a duplicacy command for the sub command and arguments
+// given, adding the storage and snapshot ID options
+func (f *Fs) command(ctx context.Context, global []string, sub string, args ...string) *exec.Cmd {
+	cmdArgs := append(append([]string{}, global...), sub)
+	if f.opt.Storage != "" {
+		cmdArgs = append(cmdArgs, "-storage", f.opt.Storage)
+	}
+	if f.opt.SnapshotID != "" {
+		cmdArgs = append(cmdArgs, "-id", f.opt.SnapshotID)
+	}
+	cmdArgs = append(cmdArgs, args...)
+	cmd := exec.CommandContext(ctx, f.opt.Command, cmdArgs...)
+	cmd.Dir = f.opt.Repository
+	cmd.Env = os.Environ()
+	if f.opt.Password != "" {
+		cmd.Env = append(cmd.Env, f.passwordEnv()+"="+f.opt.Password)
+	}
+	return cmd
+}
+
+// findRevision returns the revision selected by spec, which can be a
+// number or "latest"
+func (f *Fs) findRevision(ctx context.Context, spec string) (*revisionInfo, error) {
+	revisions, err := f.listRevisions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if spec == "latest" || spec == "" {
+		if len(revisions) == 0 {
+			return nil, errors.New("duplicacy: no revisions found")
+		}
+		return &revisions[len(revisions)-1], nil
+	}
+	for i := range revisions {
+		if strconv.Itoa(revisions[i].Revision) == spec {
+			return &revisions[i], nil
+		}
+	}
+	return nil, fmt.Errorf("duplicacy revision %q not found", spec)
+}
+
+// resolve splits the remote p into the revision it is in and the path
+// inside it. With revision_dirs the revision is 0 for the root.
+func (f *Fs) resolve(ctx context.Context, p string) (revision int, inner string, err error) {
+	if !f.opt.RevisionDirs {
+		r, err := f.findRevision(ctx, f.opt.Revision)
+		if err != nil {
+			return 0, "", err
+		}
+		return r.Revision, p, nil
+	}
+	if p == "" {
+		return 0, "", nil
+	}
+	first, rest, _ := strings.Cut(p, "/")
+	r, err := f.findRevision(ctx, first)
+	if err != nil || first == "latest" {
+		// only revision numbers select directories
+		return 0, "", fs.ErrorDirNotFound
+	}
+	return r.Revision, rest, nil
+}
+
+// Name of the remote (as passed into NewFs)
+func (f *Fs) Name() string {
+	return f.name
+}
+
+// Root of the remote (as passed into NewFs)
+func (f *Fs) Root() string {
+	return f.root
+}
+
+// String converts this Fs to a string
+func (f *Fs) String() string {
+	return fmt.Sprintf("duplicacy repository %s", f.opt.Repository)
+}
+
+// Precision of the ModTimes in this Fs
+func (f *Fs) Precision() time.Duration {
+	return time.Second
+}
+
+// Hashes returns the supported hash sets
+func (f *Fs) Hashes() hash.Set {
+	return hash.Set(hash.None)
+}
+
+// Features returns the optional features of this Fs
+func (f *Fs) Features() *fs.Features {
+	return f.features
+}
+
+// List the objects and directories in dir into entries.  The
+// entries can be returned in any order but should be for a
+// complete directory.
+//
+// dir should be "" to list the root, and should not have
+// trailing slashes.
+//
+// This should return ErrDirNotFound if the directory isn't
+// found.
+func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+	revision, inner, err := f.resolve(ctx, path.Join(f.root, dir))
+	if err != nil {
+		return nil, err
+	}
+	if revision == 0 {
+		revisions, err := f.listRevisions(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range revisions {
+			name := strconv.Itoa(r.Revision)
+			entries = append(entries, fs.NewDir(path.Join(dir, name), r.Created))
+		}
+		return entries, nil
+	}
+	tree, err := f.tree(ctx, revision)
+	if err != nil {
+		return nil, err
+	}
+	n := tree.nodes[inner]
+	if n == nil || !n.isDir {
+		return nil, fs.ErrorDirNotFound
+	}
+	for _, child := range n.children {
+		remote := path.Join(dir, child.name)
+		if child.isDir {
+			entries = append(entries, fs.NewDir(remote, child.modTime))
+			continue
+		}
+		entries = append(entries, f.newObject(remote, revision, path.Join(inner, child.name), child))
+	}
+	return entries, nil
+}
+
+// newObject makes an Object for the file node n
+func (f *Fs) newObject(remote string, revision int, inner string, n *node) *Object {
+	return &Object{
+		fs:       f,
+		remote:   remote,
+		revision: revision,
+		path:     inner,
+		size:     n.size,
+		modTime:  n.modTime,
+	}
+}
+
+// NewObject finds the Object at remote.  If it can't be found
+// it returns the error fs.ErrorObjectNotFound.
+func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	revision, inner, err := f.resolve(ctx, path.Join(f.root, remote))
+	if errors.Is(err, fs.ErrorDirNotFound) {
+		return nil, fs.ErrorObjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if revision == 0 || inner == "" {
+		return nil, fs.ErrorIsDir
+	}
+	tree, err := f.tree(ctx, revision)
+	if err != nil {
+		return nil, err
+	}
+	n := tree.nodes[inner]
+	if n == nil {
+		return nil, fs.ErrorObjectNotFound
+	}
+	if n.isDir {
+		return nil, fs.ErrorIsDir
+	}
+	return f.newObject(remote, revision, inner, n), nil
+}
+
+// Put is not supported as duplicacy remotes are read only
+func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	return nil, fserrors.FatalError(errReadOnly)
+}
+
+// Mkdir is not supported as duplicacy remotes are read only
+func (f *Fs) Mkdir(ctx context.Context, dir string) error {
+	return fserrors.FatalError(errReadOnly)
+}
+
+// Rmdir is not supported as duplicacy remotes are read only
+func (f *Fs) Rmdir(ctx context.Context, dir string) error {
+	return fserrors.FatalError(errReadOnly)
+}
+
+// ------------------------------------------------------------
+
+// Fs returns the parent Fs
+func (o *Object) Fs() fs.Info {
+	return o.fs
+}
+
+// Return a string version
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.remote
+}
+
+// Remote returns the remote path
+func (o *Object) Remote() string {
+	return o.remote
+}
+
+// Hash returns the selected checksum of the file
+func (o *Object) Hash(ctx context.Context, ty hash.Type) (string, error) {
+	return "", hash.ErrUnsupported
+}
+
+// Size returns the size of an object in bytes
+func (o *Object) Size() int64 {
+	return o.size
+}
+
+// ModTime returns the modification time of the object
+func (o *Object) ModTime(ctx context.Context) time.Time {
+	return o.modTime
+}
+
+// SetModTime is not supported as duplicacy remotes are read only
+func (o *Object) SetModTime(ctx context.Context, modTime time.Time) error {
+	return fs.ErrorCantSetModTime
+}
+
+// Storable returns whether this object is storable
+func (o *Object) Storable() bool {
+	return true
+}
+
+// ID returns the revision and path of the object
+func (o *Object) ID() string {
+	return strconv.Itoa(o.revision) + ":" + o.path
+}
+
+// Open an object for read
+func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error) {
+	var offset, limit int64 = 0, -1
+	fs.FixRangeOption(options, o.size)
+	for _, option := range options {
+		switch x := option.(type) {
+		case *fs.RangeOption:
+			offset, limit = x.Decode(o.size)
+		case *fs.SeekOption:
+			offset = x.Offset
+		default:
+			if option.Mandatory() {
+				fs.Logf(o, "Unsupported mandatory option: %v", option)
+			}
+		}
+	}
+	in, err := o.fs.cat(ctx, o.revision, o.path)
+	if err != nil {
+		return nil, err
+	}
+	// duplicacy can only stream the file from the start
+	if offset > 0 {
+		if _, err := io.CopyN(io.Discard, in, offset); err != nil {
+			_ = in.Close()
+			return nil, fmt.Errorf("failed to seek to %d: %w", offset, err)
+		}
+	}
+	if limit >= 0 {
+		in = readers.NewLimitedReadCloser(in, limit)
+	}
+	return in, nil
+}
+
+// Update is not supported as duplicacy remotes are read only
+func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	return fserrors.FatalError(errReadOnly)
+}
+
+// Remove is not supported as duplicacy remotes are read only
+func (o *Object) Remove(ctx context.Context) error {
+	return fserrors.FatalError(errReadOnly)
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs        = &Fs{}
+	_ fs.Commander = &Fs{}
+	_ fs.Object    = &Object{}
+	_ fs.IDer      = &Object{}
+)
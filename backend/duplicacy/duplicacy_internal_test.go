@@ -0,0 +1,277 @@
+package duplicacy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/obscure"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeEnv is set to run the test binary as a fake duplicacy command
+const fakeEnv = "RCLONE_TEST_FAKE_DUPLICACY"
+
+type fakeFile struct {
+	path    string
+	size    int
+	modTime string
+	data    string
+}
+
+var fakeRevisions = map[int][]fakeFile{
+	1: {
+		{path: "home/file.txt", modTime: "2024-08-28 11:00:00", data: "old"},
+	},
+	2: {
+		{path: "home/file.txt", modTime: "2024-08-29 11:00:00", data: "hello world"},
+		{path: "home/sub/deep file.txt", modTime: "2024-08-29 11:30:00", data: "deep"},
+		{path: "top.txt", modTime: "2024-08-29 10:00:00", data: "top"},
+	},
+}
+
+// fakeLog prints a line like duplicacy with -log
+func fakeLog(level, id, format string, a ...interface{}) {
+	fmt.Printf("2024-08-29 12:00:00.000 %s %s %s\n", level, id, fmt.Sprintf(format, a...))
+}
+
+// fakeDuplicacy acts as the duplicacy command for the arguments given
+func fakeDuplicacy(args []string) int {
+	log := false
+	if len(args) > 0 && args[0] == "-log" {
+		log = true
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return 3
+	}
+	sub, args := args[0], args[1:]
+	revision, files := 0, false
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-r":
+			i++
+			revision, _ = strconv.Atoi(args[i])
+		case "-files":
+			files = true
+		case "-id":
+			i++
+			if args[i] != "mywork" {
+				fakeLog("ERROR", "SNAPSHOT_LIST", "No snapshot %s", args[i])
+				return 100
+			}
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+	if os.Getenv("DUPLICACY_PASSWORD") != "secret" {
+		fakeLog("ERROR", "STORAGE_PASSWORD", "Failed to decrypt the config file")
+		return 100
+	}
+	switch {
+	case sub == "list" && log && !files:
+		fakeLog("INFO", "STORAGE_SET", "Storage set to /storage")
+		for r := 1; r <= len(fakeRevisions); r++ {
+			fakeLog("INFO", "SNAPSHOT_INFO", "Snapshot mywork revision %d created at 2024-08-%02d 12:00 -hash", r, 27+r)
+		}
+	case sub == "list" && log && files:
+		list, ok := fakeRevisions[revision]
+		if !ok {
+			fakeLog("ERROR", "SNAPSHOT_LIST", "Revision %d not found", revision)
+			return 100
+		}
+		fakeLog("INFO", "SNAPSHOT_INFO", "Snapshot mywork revision %d created at 2024-08-29 12:00", revision)
+		for _, f := range list {
+			fakeLog("INFO", "SNAPSHOT_FILE", "%4d %s %064d %s", len(f.data), f.modTime, 0, f.path)
+		}
+		fakeLog("INFO", "SNAPSHOT_INFO", "Files: %d, total size: 42", len(list))
+	case sub == "cat" && !log && len(rest) == 1:
+		for _, f := range fakeRevisions[revision] {
+			if f.path == rest[0] {
+				_, _ = io.WriteString(os.Stdout, f.data)
+				return 0
+			}
+		}
+		fmt.Fprintf(os.Stderr, "File %s not found in snapshot\n", rest[0])
+		return 100
+	default:
+		fmt.Fprintf(os.Stderr, "unexpected arguments %q\n", os.Args[1:])
+		return 3
+	}
+	return 0
+}
+
+func TestMain(m *testing.M) {
+	if os.Getenv(fakeEnv) != "" {
+		os.Exit(fakeDuplicacy(os.Args[1:]))
+	}
+	os.Exit(m.Run())
+}
+
+func newTestFs(t *testing.T, root string, extra configmap.Simple) (*Fs, error) {
+	t.Setenv(fakeEnv, "1")
+	m := configmap.Simple{
+		"type":              "duplicacy",
+		"repository":        t.TempDir(),
+		"password":          obscure.MustObscure("secret"),
+		"duplicacy_command": os.Args[0],
+	}
+	for k, v := range extra {
+		m[k] = v
+	}
+	regInfo, err := fs.Find("duplicacy")
+	require.NoError(t, err)
+	f, err := NewFs(context.Background(), "TestDuplicacy", root, fs.ConfigMap(regInfo.Prefix, regInfo.Options, "", m))
+	if f == nil {
+		return nil, err
+	}
+	return f.(*Fs), err
+}
+
+// listNames returns the remotes in dir
+func listNames(t *testing.T, f *Fs, dir string) (names []string) {
+	entries, err := f.List(context.Background(), dir)
+	require.NoError(t, err)
+	for _, entry := range entries {
+		names = append(names, entry.Remote())
+	}
+	return names
+}
+
+func readObject(t *testing.T, f *Fs, remote string, options ...fs.OpenOption) string {
+	ctx := context.Background()
+	o, err := f.NewObject(ctx, remote)
+	require.NoError(t, err)
+	in, err := o.Open(ctx, options...)
+	require.NoError(t, err)
+	data, err := io.ReadAll(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	return string(data)
+}
+
+func TestParseFile(t *testing.T) {
+	p, size, modTime, err := parseFile(fmt.Sprintf("  123 2024-08-29 11:00:00 %064d a dir/with spaces.txt", 0))
+	require.NoError(t, err)
+	assert.Equal(t, "a dir/with spaces.txt", p)
+	assert.Equal(t, int64(123), size)
+	assert.Equal(t, time.Date(2024, 8, 29, 11, 0, 0, 0, time.Local), modTime)
+
+	_, _, _, err = parseFile("Files: 3, total size: 42")
+	assert.Error(t, err)
+}
+
+func TestList(t *testing.T) {
+	ctx := context.Background()
+	f, err := newTestFs(t, "", nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"home", "top.txt"}, listNames(t, f, ""))
+	assert.Equal(t, []string{"home/file.txt", "home/sub"}, listNames(t, f, "home"))
+	assert.Equal(t, []string{"home/sub/deep file.txt"}, listNames(t, f, "home/sub"))
+
+	_, err = f.List(ctx, "missing")
+	assert.ErrorIs(t, err, fs.ErrorDirNotFound)
+
+	o, err := f.NewObject(ctx, "home/file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), o.Size())
+	assert.Equal(t, time.Date(2024, 8, 29, 11, 0, 0, 0, time.Local), o.ModTime(ctx))
+
+	// directories have the time of the newest file in them
+	entries, err := f.List(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 8, 29, 11, 30, 0, 0, time.Local), entries[0].ModTime(ctx))
+
+	_, err = f.NewObject(ctx, "home/missing")
+	assert.ErrorIs(t, err, fs.ErrorObjectNotFound)
+	_, err = f.NewObject(ctx, "home")
+	assert.ErrorIs(t, err, fs.ErrorIsDir)
+}
+
+func TestRead(t *testing.T) {
+	f, err := newTestFs(t, "home", nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, "hello world", readObject(t, f, "file.txt"))
+	assert.Equal(t, "world", readObject(t, f, "file.txt", &fs.SeekOption{Offset: 6}))
+	assert.Equal(t, "lo w", readObject(t, f, "file.txt", &fs.RangeOption{Start: 3, End: 6}))
+	assert.Equal(t, "deep", readObject(t, f, "sub/deep file.txt"))
+}
+
+func TestRevision(t *testing.T) {
+	f, err := newTestFs(t, "", configmap.Simple{"revision": "1", "snapshot_id": "mywork"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"home"}, listNames(t, f, ""))
+	assert.Equal(t, "old", readObject(t, f, "home/file.txt"))
+
+	_, err = newTestFs(t, "home", configmap.Simple{"revision": "9"})
+	assert.ErrorContains(t, err, "not found")
+	_, err = newTestFs(t, "", configmap.Simple{"revision": "first"})
+	assert.ErrorContains(t, err, "must be a number")
+}
+
+func TestRootIsFile(t *testing.T) {
+	f, err := newTestFs(t, "home/file.txt", nil)
+	assert.ErrorIs(t, err, fs.ErrorIsFile)
+	require.NotNil(t, f)
+	assert.Equal(t, "home", f.Root())
+	assert.Equal(t, "hello world", readObject(t, f, "file.txt"))
+}
+
+func TestRevisionDirs(t *testing.T) {
+	f, err := newTestFs(t, "", configmap.Simple{"revision_dirs": "true"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1", "2"}, listNames(t, f, ""))
+	assert.Equal(t, []string{"1/home/file.txt"}, listNames(t, f, "1/home"))
+	assert.Equal(t, "old", readObject(t, f, "1/home/file.txt"))
+	assert.Equal(t, "hello world", readObject(t, f, "2/home/file.txt"))
+
+	_, err = f.List(context.Background(), "3")
+	assert.ErrorIs(t, err, fs.ErrorDirNotFound)
+	_, err = f.NewObject(context.Background(), "latest/top.txt")
+	assert.ErrorIs(t, err, fs.ErrorObjectNotFound)
+}
+
+func TestReadOnly(t *testing.T) {
+	ctx := context.Background()
+	f, err := newTestFs(t, "", nil)
+	require.NoError(t, err)
+	assert.ErrorIs(t, f.Mkdir(ctx, "new"), fs.ErrorPermissionDenied)
+	o, err := f.NewObject(ctx, "top.txt")
+	require.NoError(t, err)
+	assert.ErrorIs(t, o.Remove(ctx), fs.ErrorPermissionDenied)
+}
+
+func TestErrors(t *testing.T) {
+	f, err := newTestFs(t, "", configmap.Simple{"password": obscure.MustObscure("wrong")})
+	require.NoError(t, err)
+	_, err = f.List(context.Background(), "")
+	assert.ErrorContains(t, err, "Failed to decrypt the config file")
+
+	f, err = newTestFs(t, "", configmap.Simple{"snapshot_id": "other"})
+	require.NoError(t, err)
+	_, err = f.List(context.Background(), "")
+	assert.ErrorContains(t, err, "No snapshot other")
+}
+
+func TestRevisionsCommand(t *testing.T) {
+	f, err := newTestFs(t, "", configmap.Simple{"revision": "1"})
+	require.NoError(t, err)
+	out, err := f.Command(context.Background(), "revisions", nil, nil)
+	require.NoError(t, err)
+	revisions := out.([]revisionListing)
+	require.Len(t, revisions, 2)
+	assert.True(t, revisions[0].Active)
+	assert.False(t, revisions[1].Active)
+	assert.Equal(t, "mywork", revisions[1].SnapshotID)
+	assert.Equal(t, time.Date(2024, 8, 29, 12, 0, 0, 0, time.Local), revisions[1].Created)
+}
@@ -0,0 +1,397 @@
+package duplicacy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// logArgs makes duplicacy prefix each line it prints with the time,
+// level and ID of the message so the output can be parsed
+var logArgs = []string{"-log"}
+
+// logLine is a line printed by duplicacy with -log
+type logLine struct {
+	level string // eg INFO or ERROR
+	id    string // eg SNAPSHOT_INFO
+	msg   string
+}
+
+// parseLogLine splits a line printed by duplicacy with -log, which
+// looks like "2024-08-29 12:00:00.000 INFO SNAPSHOT_INFO message"
+func parseLogLine(line string) (l logLine, ok bool) {
+	parts := strings.SplitN(line, " ", 5)
+	if len(parts) < 4 {
+		return l, false
+	}
+	l.level, l.id = parts[2], parts[3]
+	if len(parts) == 5 {
+		l.msg = parts[4]
+	}
+	return l, true
+}
+
+// runLog runs a duplicacy command with -log calling fn for each line
+// it prints. If the command fails the error includes the messages it
+// logged at ERROR level.
+func (f *Fs) runLog(ctx context.Context, sub string, args []string, fn func(logLine) error) error {
+	var stderr bytes.Buffer
+	cmd := f.command(ctx, logArgs, sub, args...)
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("duplicacy %s failed: %w", sub, err)
+	}
+	var errorMsgs []string
+	var fnErr error
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		l, ok := parseLogLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		if l.level == "ERROR" || l.level == "FATAL" {
+			errorMsgs = append(errorMsgs, l.msg)
+		}
+		if fnErr == nil {
+			fnErr = fn(l)
+		}
+	}
+	if fnErr == nil {
+		fnErr = scanner.Err()
+	}
+	// drain the output so the command can finish
+	_, _ = io.Copy(io.Discard, stdout)
+	if err := cmd.Wait(); err != nil {
+		msg := strings.TrimSpace(strings.Join(errorMsgs, "; ") + " " + stderr.String())
+		if msg == "" {
+			return fmt.Errorf("duplicacy %s failed: %w", sub, err)
+		}
+		return fmt.Errorf("duplicacy %s failed: %w: %s", sub, err, msg)
+	}
+	return fnErr
+}
+
+// revisionInfo is a revision as listed by "duplicacy list"
+type revisionInfo struct {
+	SnapshotID string    `json:"snapshotID"`
+	Revision   int       `json:"revision"`
+	Created    time.Time `json:"created"`
+}
+
+// revisionTimeFormat is the format of the creation times printed by
+// "duplicacy list", which are in local time
+const revisionTimeFormat = "2006-01-02 15:04"
+
+// revisionLine matches the line printed for each revision
+var revisionLine = regexp.MustCompile(`^Snapshot (\S+) revision (\d+) created at (\d{4}-\d\d-\d\d \d\d:\d\d)`)
+
+// parseRevision parses the SNAPSHOT_INFO line of a revision
+func parseRevision(msg string) (r revisionInfo, ok bool) {
+	m := revisionLine.FindStringSubmatch(msg)
+	if m == nil {
+		return r, false
+	}
+	r.SnapshotID = m[1]
+	r.Revision, _ = strconv.Atoi(m[2])
+	r.Created, _ = time.ParseInLocation(revisionTimeFormat, m[3], time.Local)
+	return r, true
+}
+
+// listRevisions returns the revisions of the snapshot ID, oldest
+// first, reading them the first time
+func (f *Fs) listRevisions(ctx context.Context) ([]revisionInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.revisions != nil {
+		return f.revisions, nil
+	}
+	revisions := []revisionInfo{}
+	err := f.runLog(ctx, "list", nil, func(l logLine) error {
+		if l.id != "SNAPSHOT_INFO" {
+			return nil
+		}
+		if r, ok := parseRevision(l.msg); ok {
+			revisions = append(revisions, r)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].Revision < revisions[j].Revision
+	})
+	f.revisions = revisions
+	return f.revisions, nil
+}
+
+// fileTimeFormat is the format of the modification times of the files
+// printed by "duplicacy list -files", which are in local time
+const fileTimeFormat = "2006-01-02 15:04:05"
+
+// parseFile parses the SNAPSHOT_FILE line of a file which has the
+// size, modification time, hash and path
+func parseFile(msg string) (p string, size int64, modTime time.Time, err error) {
+	parts := strings.SplitN(strings.TrimLeft(msg, " "), " ", 5)
+	if len(parts) != 5 {
+		return "", 0, time.Time{}, fmt.Errorf("duplicacy list: bad file line %q", msg)
+	}
+	size, err = strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return "", 0, time.Time{}, fmt.Errorf("duplicacy list: bad size in %q: %w", msg, err)
+	}
+	modTime, err = time.ParseInLocation(fileTimeFormat, parts[1]+" "+parts[2], time.Local)
+	if err != nil {
+		return "", 0, time.Time{}, fmt.Errorf("duplicacy list: bad time in %q: %w", msg, err)
+	}
+	return parts[4], size, modTime, nil
+}
+
+// node is a file or directory in a revision
+type node struct {
+	name     string
+	isDir    bool
+	size     int64
+	modTime  time.Time
+	children []*node // sorted by name, if isDir
+}
+
+// revisionTree holds the files of a revision indexed by path
+//
+// duplicacy only lists the files, so the directories are made from
+// their paths and have the time of the newest file in them.
+type revisionTree struct {
+	nodes map[string]*node // path to node, "" is the root
+}
+
+// dir returns the directory node at p, creating it and its parents
+// if needed
+func (t *revisionTree) dir(p string) *node {
+	if n := t.nodes[p]; n != nil {
+		return n
+	}
+	n := &node{name: path.Base(p), isDir: true}
+	t.nodes[p] = n
+	parent := t.dir(parentPath(p))
+	parent.children = append(parent.children, n)
+	return n
+}
+
+// add puts the file at p into the tree
+func (t *revisionTree) add(p string, size int64, modTime time.Time) {
+	p = cleanPath(p)
+	if p == "" || t.nodes[p] != nil {
+		return
+	}
+	n := &node{
+		name:    path.Base(p),
+		size:    size,
+		modTime: modTime,
+	}
+	t.nodes[p] = n
+	for dir := parentPath(p); ; dir = parentPath(dir) {
+		d := t.dir(dir)
+		if modTime.After(d.modTime) {
+			d.modTime = modTime
+		}
+		if dir == "" {
+			break
+		}
+	}
+	parent := t.nodes[parentPath(p)]
+	parent.children = append(parent.children, n)
+}
+
+// tree returns the files of the revision, reading them the first time
+func (f *Fs) tree(ctx context.Context, revision int) (*revisionTree, error) {
+	f.mu.Lock()
+	t := f.trees[revision]
+	f.mu.Unlock()
+	if t != nil {
+		return t, nil
+	}
+	t, err := f.readTree(ctx, revision)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if old := f.trees[revision]; old != nil {
+		return old, nil
+	}
+	f.trees[revision] = t
+	return t, nil
+}
+
+// readTree lists all the files in revision
+func (f *Fs) readTree(ctx context.Context, revision int) (*revisionTree, error) {
+	fs.Debugf(f, "Reading revision %d", revision)
+	t := &revisionTree{nodes: map[string]*node{
+		"": {isDir: true},
+	}}
+	err := f.runLog(ctx, "list", []string{"-files", "-r", strconv.Itoa(revision)}, func(l logLine) error {
+		if l.id != "SNAPSHOT_FILE" {
+			return nil
+		}
+		p, size, modTime, err := parseFile(l.msg)
+		if err != nil {
+			return err
+		}
+		t.add(p, size, modTime)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range t.nodes {
+		sort.Slice(n.children, func(i, j int) bool {
+			return n.children[i].name < n.children[j].name
+		})
+	}
+	return t, nil
+}
+
+// catReader reads a file streamed by "duplicacy cat"
+type catReader struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+	cancel context.CancelFunc
+	eof    bool // set if the file was read to the end
+	closed bool
+}
+
+// Read the file, noting when it has been read to the end
+func (r *catReader) Read(p []byte) (n int, err error) {
+	n, err = r.ReadCloser.Read(p)
+	if err == io.EOF {
+		r.eof = true
+	}
+	return n, err
+}
+
+// Close the output and wait for duplicacy to finish
+//
+// duplicacy is stopped if the file wasn't read to the end, otherwise
+// any error it exited with is returned.
+func (r *catReader) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	if !r.eof {
+		r.cancel()
+	}
+	_ = r.ReadCloser.Close()
+	err := r.cmd.Wait()
+	r.cancel()
+	if err != nil && r.eof {
+		msg := strings.TrimSpace(r.stderr.String())
+		return fmt.Errorf("duplicacy cat failed: %w: %s", err, msg)
+	}
+	return nil
+}
+
+// cat streams the file at p in revision
+func (f *Fs) cat(ctx context.Context, revision int, p string) (io.ReadCloser, error) {
+	stderr := new(bytes.Buffer)
+	ctx, cancel := context.WithCancel(ctx)
+	cmd := f.command(ctx, nil, "cat", "-r", strconv.Itoa(revision), p)
+	cmd.Stderr = stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("duplicacy cat failed: %w", err)
+	}
+	return &catReader{
+		ReadCloser: stdout,
+		cmd:        cmd,
+		stderr:     stderr,
+		cancel:     cancel,
+	}, nil
+}
+
+var commandHelp = []fs.CommandHelp{{
+	Name:  "revisions",
+	Short: "List the revisions of the snapshot ID.",
+	Long: `This command lists the revisions of the snapshot ID, oldest first,
+with their numbers and creation times. The revision the remote is
+showing is marked as active.
+
+Usage Example:
+
+    rclone backend revisions duplicacy:
+
+The numbers can be used with --duplicacy-revision.
+`,
+}}
+
+// revisionListing is a revision as returned by the revisions command
+type revisionListing struct {
+	revisionInfo
+	Active bool `json:"active,omitempty"`
+}
+
+// revisionList returns the revisions for the revisions command
+func (f *Fs) revisionList(ctx context.Context) ([]revisionListing, error) {
+	revisions, err := f.listRevisions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	active := 0
+	if !f.opt.RevisionDirs {
+		if r, err := f.findRevision(ctx, f.opt.Revision); err == nil {
+			active = r.Revision
+		}
+	}
+	out := make([]revisionListing, 0, len(revisions))
+	for _, r := range revisions {
+		out = append(out, revisionListing{
+			revisionInfo: r,
+			Active:       r.Revision == active,
+		})
+	}
+	return out, nil
+}
+
+// Command the backend to run a named command
+//
+// The command run is name
+// args may be used to read arguments from
+// opts may be used to read optional arguments from
+//
+// The result should be capable of being JSON encoded
+// If it is a string or a []string it will be shown to the user
+// otherwise it will be JSON encoded and shown to the user like that
+func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[string]string) (out interface{}, err error) {
+	switch name {
+	case "revisions":
+		if len(arg) > 0 {
+			return nil, errors.New("revisions takes no arguments")
+		}
+		return f.revisionList(ctx)
+	default:
+		return nil, fs.ErrorCommandNotFound
+	}
+}
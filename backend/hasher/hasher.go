@@ -74,6 +74,7 @@ type Fs struct {
 	// fingerprinting
 	fpTime bool      // true if using time in fingerprints
 	fpHash hash.Type // hash type to use in fingerprints or None
+	fpID   bool      // true if using object IDs in fingerprints
 	// hash types triaged by groups
 	suppHashes hash.Set // all supported checksum types
 	passHashes hash.Set // passed directly to the base without caching
@@ -126,6 +127,8 @@ func NewFs(ctx context.Context, fsname, rpath string, cmap configmap.Mapper) (fs
 
 	if baseFeatures.SlowHash {
 		f.slowHashes = f.Fs.Hashes()
+		// with no fast hash fall back to object IDs if the base has them
+		f.fpID = true
 	} else {
 		f.passHashes = f.Fs.Hashes()
 		f.fpHash = f.passHashes.GetOne()
@@ -11,6 +11,7 @@ import (
 	"github.com/rclone/rclone/fs/operations"
 	"github.com/rclone/rclone/fstest"
 	"github.com/rclone/rclone/fstest/fstests"
+	"github.com/rclone/rclone/fstest/mockobject"
 	"github.com/rclone/rclone/lib/kv"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -69,6 +70,41 @@ func (f *Fs) testUploadFromCrypt(t *testing.T) {
 	_ = operations.Purge(ctx, f, dirName)
 }
 
+// idObject is an object with an ID
+type idObject struct {
+	mockobject.Object
+	id string
+}
+
+// ID returns the ID of the object
+func (o idObject) ID() string {
+	return o.id
+}
+
+func TestFingerprintID(t *testing.T) {
+	ctx := context.Background()
+	f := &Fs{fpID: true}
+	fingerprint := func(o fs.Object) string {
+		return (&Object{Object: o, f: f}).fingerprint(ctx)
+	}
+	a := idObject{Object: "file.txt", id: "k1"}
+	b := idObject{Object: "file.txt", id: "k2"}
+	moved := idObject{Object: "moved.txt", id: "k1"}
+
+	// the same path with different contents doesn't match
+	assert.Equal(t, "0,-,-,k1", fingerprint(a))
+	assert.NotEqual(t, fingerprint(a), fingerprint(b))
+	// the same contents at another path does
+	assert.Equal(t, fingerprint(a), fingerprint(moved))
+	// objects without IDs are fingerprinted as before
+	assert.Equal(t, "0,-,-", fingerprint(idObject{Object: "file.txt"}))
+	assert.Equal(t, "0,-,-", fingerprint(mockobject.Object("file.txt")))
+
+	// IDs are only used if the base has no fast hash
+	f.fpID = false
+	assert.Equal(t, fingerprint(a), fingerprint(b))
+}
+
 // InternalTest dispatches all internal tests
 func (f *Fs) InternalTest(t *testing.T) {
 	if !kv.Supported() {
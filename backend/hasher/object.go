@@ -307,5 +307,13 @@ func (o *Object) fingerprint(ctx context.Context) string {
 			return ""
 		}
 	}
-	return fmt.Sprintf("%d,%s,%s", size, timeStr, hashStr)
+	fp := fmt.Sprintf("%d,%s,%s", size, timeStr, hashStr)
+	if o.f.fpID {
+		if do, ok := o.Object.(fs.IDer); ok {
+			if id := do.ID(); id != "" {
+				fp += "," + id
+			}
+		}
+	}
+	return fp
 }
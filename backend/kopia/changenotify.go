@@ -0,0 +1,47 @@
+package kopia
+
+import (
+	"context"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// ChangeNotify calls notifyFunc with the root when the remote switches
+// to a different snapshot, so a mount reads its directories again.
+//
+// Switching is done by the kopia/set-snapshot and kopia/refresh rc
+// calls, or when a newer snapshot is found once --kopia-dir-cache-time
+// has expired, so the poll interval isn't used. notifyFunc is removed
+// when pollIntervalChan is closed.
+func (f *Fs) ChangeNotify(ctx context.Context, notifyFunc func(string, fs.EntryType), pollIntervalChan <-chan time.Duration) {
+	f.notifyMu.Lock()
+	f.notifyID++
+	id := f.notifyID
+	if f.notifyFuncs == nil {
+		f.notifyFuncs = map[int]func(string, fs.EntryType){}
+	}
+	f.notifyFuncs[id] = notifyFunc
+	f.notifyMu.Unlock()
+	go func() {
+		for range pollIntervalChan {
+		}
+		f.notifyMu.Lock()
+		delete(f.notifyFuncs, id)
+		f.notifyMu.Unlock()
+	}()
+}
+
+// rootChanged forgets the listing of the root and tells anything
+// registered with ChangeNotify that everything has changed.
+//
+// The notifications are sent in the background as they may be
+// triggered while the VFS is reading a directory.
+func (f *Fs) rootChanged() {
+	f.forgetRootListing()
+	f.notifyMu.Lock()
+	defer f.notifyMu.Unlock()
+	for _, notifyFunc := range f.notifyFuncs {
+		go notifyFunc("", fs.EntryDirectory)
+	}
+}
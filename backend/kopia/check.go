@@ -0,0 +1,232 @@
+package kopia
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	gohash "hash"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/walk"
+	"golang.org/x/sync/errgroup"
+)
+
+// checkReport is the output of the check command
+type checkReport struct {
+	HashType   string   `json:"hashType,omitempty"`
+	Checked    int      `json:"checked"`
+	OK         int      `json:"ok"`
+	Missing    []string `json:"missing,omitempty"`
+	SizeDiffer []string `json:"sizeDiffer,omitempty"`
+	HashDiffer []string `json:"hashDiffer,omitempty"`
+	Errors     []string `json:"errors,omitempty"`
+}
+
+// checkResult is the outcome of checking a single object
+type checkResult int
+
+const (
+	checkOK checkResult = iota
+	checkMissing
+	checkSizeDiffer
+	checkHashDiffer
+	checkError
+)
+
+// add the result for remote to the report
+func (r *checkReport) add(remote string, result checkResult, err error) {
+	r.Checked++
+	switch result {
+	case checkOK:
+		r.OK++
+	case checkMissing:
+		r.Missing = append(r.Missing, remote)
+	case checkSizeDiffer:
+		r.SizeDiffer = append(r.SizeDiffer, remote)
+	case checkHashDiffer:
+		r.HashDiffer = append(r.HashDiffer, remote)
+	case checkError:
+		r.Errors = append(r.Errors, fmt.Sprintf("%s: %v", remote, err))
+	}
+}
+
+// sort the report so it is deterministic
+func (r *checkReport) sort() {
+	sort.Strings(r.Missing)
+	sort.Strings(r.SizeDiffer)
+	sort.Strings(r.HashDiffer)
+	sort.Strings(r.Errors)
+}
+
+// checkHashType chooses the hash type to compare with dst
+//
+// It returns hash.None if there isn't a suitable one.
+func (f *Fs) checkHashType(dst fs.Fs, name string) (ht hash.Type, err error) {
+	common := f.hashes.Overlap(dst.Hashes())
+	if name != "" {
+		if err := ht.Set(name); err != nil {
+			return ht, err
+		}
+		if !common.Contains(ht) {
+			return ht, fmt.Errorf("hash %v not supported by both %v and %v", ht, f, dst)
+		}
+		return ht, nil
+	}
+	return common.GetOne(), nil
+}
+
+// repoHashName selects the repository's own content hash for the check
+// command
+const repoHashName = "kopia"
+
+// checker compares the objects in the snapshot with the destination
+type checker struct {
+	ht      hash.Type          // hash to compare, hash.None for sizes only
+	newHash func() gohash.Hash // compare the repository hash instead, if set
+}
+
+// checkCommand verifies the files in dst against the snapshot
+func (f *Fs) checkCommand(ctx context.Context, dst string, opt map[string]string) (*checkReport, error) {
+	dstFs, err := cache.Get(ctx, dst)
+	if err != nil && err != fs.ErrorIsFile {
+		return nil, fmt.Errorf("couldn't open destination: %w", err)
+	}
+	report := &checkReport{}
+	c := &checker{}
+	if opt["hash"] == repoHashName {
+		c.newHash, err = f.contentHasher(ctx)
+		if err != nil {
+			return nil, err
+		}
+		report.HashType = repoHashName
+	} else {
+		c.ht, err = f.checkHashType(dstFs, opt["hash"])
+		if err != nil {
+			return nil, err
+		}
+		if c.ht != hash.None {
+			report.HashType = c.ht.String()
+		} else {
+			fs.Logf(f, "No common hash with %v - only checking sizes", dstFs)
+		}
+	}
+	var mu sync.Mutex
+	g, gCtx := errgroup.WithContext(ctx)
+	g.SetLimit(fs.GetConfig(ctx).Checkers)
+	err = walk.ListR(ctx, f, "", true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+		entries.ForObject(func(o fs.Object) {
+			g.Go(func() error {
+				result, err := c.check(gCtx, o, dstFs)
+				mu.Lock()
+				report.add(o.Remote(), result, err)
+				mu.Unlock()
+				return nil
+			})
+		})
+		return nil
+	})
+	if waitErr := g.Wait(); err == nil {
+		err = waitErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	report.sort()
+	fs.Infof(f, "check: %d checked, %d ok, %d missing, %d size differences, %d hash differences, %d errors",
+		report.Checked, report.OK, len(report.Missing), len(report.SizeDiffer), len(report.HashDiffer), len(report.Errors))
+	return report, nil
+}
+
+// check compares o with the object at the same path in dst
+func (c *checker) check(ctx context.Context, o fs.Object, dst fs.Fs) (checkResult, error) {
+	dstObj, err := dst.NewObject(ctx, o.Remote())
+	if errors.Is(err, fs.ErrorObjectNotFound) {
+		return checkMissing, nil
+	} else if err != nil {
+		return checkError, err
+	}
+	if o.Size() != dstObj.Size() {
+		return checkSizeDiffer, nil
+	}
+	if sameObjectID(o, dstObj) {
+		return checkOK, nil
+	}
+	if c.ht == hash.None && c.newHash == nil {
+		return checkOK, nil
+	}
+	srcSum, dstSum, err := c.sums(ctx, o, dstObj)
+	if err != nil {
+		return checkError, err
+	}
+	if srcSum != dstSum {
+		return checkHashDiffer, nil
+	}
+	return checkOK, nil
+}
+
+// sameObjectID returns true if o and dstObj are kopia objects on the
+// same server with the same object ID, so their data is identical.
+//
+// Different object IDs don't mean the data differs as the splitter or
+// compression may have changed between snapshots.
+func sameObjectID(o, dstObj fs.Object) bool {
+	ko, ok := o.(*Object)
+	if !ok {
+		return false
+	}
+	kdst, ok := dstObj.(*Object)
+	if !ok {
+		return false
+	}
+	return ko.id == kdst.id && ko.fs.sameServer(kdst.fs)
+}
+
+// sameServer returns true if f and g read from the same kopia server
+func (f *Fs) sameServer(g *Fs) bool {
+	return strings.TrimRight(f.opt.URL, "/") == strings.TrimRight(g.opt.URL, "/")
+}
+
+// sums returns the checksums of o and dstObj to compare
+func (c *checker) sums(ctx context.Context, o, dstObj fs.Object) (srcSum, dstSum string, err error) {
+	if c.newHash != nil {
+		ko, ok := o.(*Object)
+		if ok {
+			srcSum, ok = contentIDHash(ko.id)
+		}
+		if !ok {
+			srcSum, err = readRepoSum(ctx, o, c.newHash)
+			if err != nil {
+				return "", "", err
+			}
+		}
+		dstSum, err = readRepoSum(ctx, dstObj, c.newHash)
+		return srcSum, dstSum, err
+	}
+	srcSum, err = o.Hash(ctx, c.ht)
+	if err != nil {
+		return "", "", err
+	}
+	dstSum, err = dstObj.Hash(ctx, c.ht)
+	if err != nil {
+		return "", "", err
+	}
+	if srcSum == "" || dstSum == "" {
+		return "", "", fmt.Errorf("%v hash not available", c.ht)
+	}
+	return srcSum, dstSum, nil
+}
+
+// readRepoSum reads o to compute its repository hash
+func readRepoSum(ctx context.Context, o fs.Object, newHash func() gohash.Hash) (sum string, err error) {
+	in, err := o.Open(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer fs.CheckClose(in, &err)
+	return repoSum(newHash, in)
+}
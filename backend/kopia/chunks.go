@@ -0,0 +1,69 @@
+package kopia
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/rclone/rclone/lib/rest"
+)
+
+// chunk is a single content making up part of an object
+type chunk struct {
+	ID     string `json:"id"`
+	Offset int64  `json:"offset"`
+	Length int64  `json:"length"`
+}
+
+// chunksReport is the output of the chunks command
+type chunksReport struct {
+	Path     string  `json:"path"`
+	ObjectID string  `json:"objectID"`
+	Size     int64   `json:"size"`
+	Chunks   []chunk `json:"chunks"`
+}
+
+// chunks returns the chunk map of the object at remote
+func (f *Fs) chunks(ctx context.Context, remote string) (*chunksReport, error) {
+	o, err := f.NewObject(ctx, remote)
+	if err != nil {
+		return nil, err
+	}
+	obj := o.(*Object)
+	report := &chunksReport{
+		Path:     path.Join(f.root, remote),
+		ObjectID: obj.id,
+		Size:     obj.size,
+	}
+	report.Chunks, err = f.objectChunks(ctx, obj.id, 0, obj.size)
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// objectChunks returns the chunks making up object id which starts at
+// offset and has the given length, following indirect objects.
+func (f *Fs) objectChunks(ctx context.Context, id string, offset, length int64) ([]chunk, error) {
+	if !strings.HasPrefix(id, "I") {
+		return []chunk{{ID: id, Offset: offset, Length: length}}, nil
+	}
+	index := IndirectObject{}
+	err := f.callJSON(ctx, &rest.Opts{
+		Method: "GET",
+		Path:   fmt.Sprintf("/api/v1/objects/%s", id[1:]),
+	}, nil, &index)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index object %s: %w", id, err)
+	}
+	var chunks []chunk
+	for _, entry := range index.Entries {
+		sub, err := f.objectChunks(ctx, entry.Object, offset+entry.Start, entry.Length)
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, sub...)
+	}
+	return chunks, nil
+}
@@ -0,0 +1,570 @@
+package kopia
+
+import (
+	"context"
+	"errors"
+
+	"github.com/rclone/rclone/fs"
+)
+
+var commandHelp = []fs.CommandHelp{{
+	Name:  "snapshots",
+	Short: "List the snapshots of the source.",
+	Long: `This command lists the snapshots of the configured source, oldest
+first, with their IDs, times and sizes. The snapshot the remote is
+showing is marked as active.
+
+Usage Example:
+
+    rclone backend snapshots kopia:
+
+The IDs, or root object IDs, can be used with --kopia-snapshot and
+with the diff command.
+`,
+}, {
+	Name:  "diff",
+	Short: "Show what changed between two snapshots.",
+	Long: `This command compares the root of the remote, or the path given, in
+two snapshots and lists the files and directories added, removed and
+changed. Directories are shown with a trailing /.
+
+Usage Examples:
+
+    rclone backend diff kopia:
+    rclone backend diff kopia: path/to/dir -o from=k1234 -o to=latest
+
+By default the snapshot the remote is showing is compared with the one
+before it. -o from and -o to take a snapshot ID, a root object ID or
+"latest". Unchanged directories are skipped without being read so
+comparing large snapshots is quick.
+`,
+	Opts: map[string]string{
+		"from": "Snapshot to compare from (default the one before to)",
+		"to":   "Snapshot to compare to (default the active one)",
+	},
+}, {
+	Name:  "du",
+	Short: "Show the sizes of the directories in the snapshot.",
+	Long: `This command prints the total size of the root of the remote, or the
+path given, and of each directory in it, like "du -h --max-depth 1".
+
+Usage Examples:
+
+    rclone backend du kopia:
+    rclone backend du kopia: path/to/dir -o max-depth=2 -o bytes
+
+The sizes come from the summaries kopia records for each directory so
+only the directories shown need to be read, however big the tree
+below them is. Directories are listed deepest first with the total
+last. -o max-depth sets how many levels of directories are shown and
+-o bytes shows the sizes in bytes.
+`,
+	Opts: map[string]string{
+		"max-depth": "Levels of directories to show (default 1)",
+		"bytes":     "Show sizes in bytes",
+	},
+}, {
+	Name:  "find-object",
+	Short: "Find the paths which reference an object.",
+	Long: `This command searches the snapshot the remote is showing for the files
+and directories whose object ID is the one given, as reported by kopia
+when verifying or repairing a repository. Directories are shown with a
+trailing /.
+
+Usage Examples:
+
+    rclone backend find-object kopia: k1234abcd
+    rclone backend find-object kopia:path/to/dir 0123abcd -o all -o chunks
+
+-o all searches every snapshot instead. Directories which are the same
+in several snapshots are only read once. Large files are stored as
+indirect objects listing their contents - -o chunks also finds the
+files one of whose contents has the ID given, at the cost of reading
+the index of every indirect object.
+`,
+	Opts: map[string]string{
+		"all":    "Search all snapshots",
+		"chunks": "Search the contents of indirect objects too",
+	},
+}, {
+	Name:  "manifest",
+	Short: "Show the manifest of a snapshot.",
+	Long: `This command returns the snapshot manifest exactly as it is stored in
+the repository, along with its manifest ID, labels and modification
+time, so it can be archived or audited outside kopia.
+
+Usage Examples:
+
+    rclone backend manifest kopia:
+    rclone backend manifest kopia: -o snapshot=k1234 -o root
+
+By default the manifest of the snapshot the remote is showing is
+returned. -o snapshot takes a snapshot ID, a root object ID or
+"latest". With -o root the serialized root directory object of the
+snapshot is included too.
+`,
+	Opts: map[string]string{
+		"snapshot": "Snapshot to show (default the active one)",
+		"root":     "Include the root directory object",
+	},
+}, {
+	Name:  "check",
+	Short: "Verify restored files against the snapshot.",
+	Long: `This command re-reads the files restored to a destination and
+compares them against the snapshot, reporting any which are missing or
+differ in size or checksum.
+
+Usage Examples:
+
+    rclone backend check kopia:path /local/restore
+    rclone backend check kopia:path remote:restore -o hash=md5
+
+The sizes are always compared. Checksums are compared using the hash
+given with -o hash=TYPE, or if not set the first one configured with
+--kopia-hashes which the destination supports. With -o hash=kopia the
+destination files are hashed with the repository's own content hash,
+which for most objects is their object ID so the snapshot side needs
+no downloads.
+
+If the destination is a kopia remote on the same server then files
+with the same object ID are known to be identical without downloading
+anything. Files whose object IDs differ are compared as above.
+
+It returns a JSON report with the number of files checked and the
+paths of any failures.
+`,
+	Opts: map[string]string{
+		"hash": "Hash type to compare with",
+	},
+}, {
+	Name:  "fsck",
+	Short: "Verify every object in the snapshot can be read.",
+	Long: `This command walks every directory of the resolved snapshot and
+checks each object is retrievable and has the size declared in its
+directory entry.
+
+Usage Examples:
+
+    rclone backend fsck kopia:
+    rclone backend fsck kopia:path -o download
+    rclone backend fsck kopia: -o content
+
+By default only the object sizes are fetched from the server. With
+-o download every object is read in full. With -o hash=TYPE the
+objects are read and hashed as well - the hash must be one of those
+in --kopia-hashes - and the hash is compared with any previously
+cached value. With -o content objects stored as a single content are
+read and verified against their object ID using the repository's own
+content hash, as verify_content does.
+
+It returns a JSON summary with the paths which failed.
+`,
+	Opts: map[string]string{
+		"download": "Read every object in full",
+		"hash":     "Hash type to verify while reading",
+		"content":  "Verify objects against their content IDs",
+	},
+}, {
+	Name:  "chunks",
+	Short: "Show the content chunks making up a file.",
+	Long: `This command prints the list of content IDs making up a file with
+the offset and length of each, following indirect objects. The path of
+the file is given relative to the remote.
+
+Usage Examples:
+
+    rclone backend chunks kopia: path/to/file
+    rclone backend chunks kopia:path to/file
+
+This is useful for dedupe analysis and for debugging partial reads.
+`,
+}, {
+	Name:  "sums",
+	Short: "Make a checksum file for the files in the snapshot.",
+	Long: `This command prints a checksum file for the root of the remote, or
+the path given, in the same format as "rclone hashsum", so a restore
+can be checked with "rclone checksum" or sha256sum -c and the file kept
+as evidence of what was restored.
+
+Usage Examples:
+
+    rclone backend sums kopia:path -o format=sha256
+    rclone backend sums kopia: path/to/dir -o format=md5 > restore.md5
+
+The hash must be one of those set with the hashes option and may be
+left out if only one is. Checksums already known are used, others are
+computed by downloading the file. Files larger than hash_max_size have
+no checksum so are left out with an error.
+`,
+	Opts: map[string]string{
+		"format": "Hash to use (default the only one configured)",
+	},
+}, {
+	Name:  "stat",
+	Short: "Show the details of a file or directory in the snapshot.",
+	Long: `This command returns the object ID, type, size, mode, owner and
+modification time of a file or directory as recorded in the snapshot,
+along with the IDs of the snapshots which have the identical object at
+the same path.
+
+Usage Examples:
+
+    rclone backend stat kopia: path/to/file
+    rclone backend stat kopia:path to/dir
+
+This shows whether a file has changed across snapshots without reading
+its contents. The size of a directory is the total size of the files
+in it.
+`,
+}, {
+	Name:  "hardlinks",
+	Short: "Recreate hardlinks in a restore to local disk.",
+	Long: `This command finds files in the snapshot sharing an object ID and
+replaces the copies of them in a restore on local disk with hardlinks
+to the first one, so the data is only stored once.
+
+Usage Examples:
+
+    rclone copy kopia:path /local/restore
+    rclone backend hardlinks kopia:path /local/restore
+
+Kopia deduplicates content so files with identical content are linked
+as well as files which were hardlinks when the snapshot was taken.
+Files whose contents no longer match the rest of their group, such as
+those edited since the restore, are left alone and reported as errors.
+
+It returns a JSON report with the number of groups, the number of
+files linked and any errors.
+`,
+}, {
+	Name:  "sparse",
+	Short: "Restore a sparse file to local disk leaving its holes unallocated.",
+	Long: `This command restores a file which the snapshot records as sparse
+to a local file, only downloading and writing the data between the
+holes, so restoring VM images and the like doesn't fill the disk.
+
+Usage Examples:
+
+    rclone backend sparse kopia: vm/disk.img /restore/disk.img
+    rclone backend sparse kopia:vm disk.img /restore/disk.img
+
+If the snapshot doesn't record any holes for the file it is restored
+in full. It returns a JSON report with the number of bytes written and
+skipped.
+`,
+}, {
+	Name:  "export",
+	Short: "Export a path from the snapshot as a tar or zip archive.",
+	Long: `This command writes the root of the remote, or the path given, as a
+single archive so a whole directory tree can be archived without
+recreating every file.
+
+Usage Examples:
+
+    rclone backend export kopia:path -o output=/tmp/path.tar.gz
+    rclone backend export kopia: path/to/dir > dir.tar
+    rclone backend export kopia:path -o output=/srv/path.zip -o server
+
+The format is taken from the output file name (.tar, .tar.gz, .tgz or
+.zip) or can be given with -o format. If no output is given the
+archive is written to standard output as a tar file.
+
+The kopia server can only make archives with its restore task, which
+writes them to a file on the server host - it has no API call which
+streams an archive back. So rclone builds the archive itself from the
+snapshot, reading each file through the API, which lets it be written
+locally or to standard output.
+
+With -o server the archive is written by kopia's restore task to the
+output path on the server host instead of being streamed through
+rclone. This only supports the tar and zip formats.
+`,
+	Opts: map[string]string{
+		"output": "File to write the archive to, or - for standard output",
+		"format": "Archive format: tar, tgz or zip",
+		"server": "Write the archive on the server host",
+	},
+}, {
+	Name:  "commit",
+	Short: "Commit the staged changes as a new snapshot now.",
+	Long: `In read_write mode changes are staged and committed as a single new
+snapshot when rclone finishes. This command commits them straight away
+so long running sessions, such as the rc server or a mount, control
+exactly when a snapshot is made.
+
+Usage Examples:
+
+    rclone backend commit kopia:
+    rclone rc backend/command command=commit fs=kopia:
+
+It returns a JSON report with the number of changes and the ID and
+root of the new snapshot, if one was made.
+`,
+}, {
+	Name:  "rollback",
+	Short: "Discard the staged changes.",
+	Long: `In read_write mode this discards the changes which have been staged
+but not yet committed, so the next snapshot is made from the latest
+one again.
+
+Usage Example:
+
+    rclone rc backend/command command=rollback fs=kopia:
+
+It returns a JSON report with the number of changes discarded.
+`,
+}, {
+	Name:  "delete-source",
+	Short: "Delete the source and all its snapshots.",
+	Long: `This command deletes every snapshot of the configured source along
+with the source and its policy, so it no longer shows in kopia's list
+of sources. The data is removed by kopia's next maintenance run.
+
+As this can't be undone the source must be given as user@host:path
+with -o confirm, and the remote must be in read_write mode.
+
+Usage Example:
+
+    rclone backend delete-source kopia: -o confirm=user@host:/path
+
+With --dry-run the snapshots which would be deleted are listed but
+nothing is changed. It returns a JSON report with the source and the
+IDs of the snapshots deleted.
+`,
+	Opts: map[string]string{
+		"confirm": "The source to delete as user@host:path (required)",
+	},
+}, {
+	Name:  "maintenance",
+	Short: "Run maintenance of the repository on the server.",
+	Long: `This command starts kopia's repository maintenance on the server,
+so it can be scheduled from the same tooling as the rest of rclone.
+Quick maintenance compacts indexes and the like, while full maintenance
+also deletes the contents no snapshot refers to any more, such as
+those of deleted snapshots.
+
+As maintenance works on the whole repository, not just this source,
+the mode must be repeated with -o confirm, and the remote must be in
+read_write mode.
+
+Usage Examples:
+
+    rclone backend maintenance kopia: -o confirm=quick
+    rclone backend maintenance kopia: -o full -o confirm=full -o async
+
+By default rclone waits for maintenance to finish and returns the final
+state of the task as JSON. With -o async it returns as soon as the
+task has started. With --dry-run nothing is started.
+`,
+	Opts: map[string]string{
+		"full":    "Run full maintenance rather than quick",
+		"confirm": "The maintenance to run, quick or full (required)",
+		"async":   "Don't wait for maintenance to finish",
+	},
+}, {
+	Name:  "restore",
+	Short: "Restore a path on the kopia server host.",
+	Long: `This command starts kopia's own restore task on the server, so the
+data is written to disk on the server host rather than streamed
+through rclone. The path restored is the root of the remote or the
+argument given.
+
+Usage Examples:
+
+    rclone backend restore kopia:path -o dest=/srv/restore
+    rclone backend restore kopia: path/to/dir -o dest=/srv/restore -o overwrite
+
+By default rclone waits for the restore to finish and returns the
+final state of the task as JSON. With -o async it returns as soon as
+the task has started.
+`,
+	Opts: map[string]string{
+		"dest":             "Path on the server to restore to (required)",
+		"overwrite":        "Overwrite existing files and symlinks",
+		"incremental":      "Skip files which already exist with the same size and time",
+		"ignore-errors":    "Carry on past errors",
+		"skip-owners":      "Don't restore file owners",
+		"skip-permissions": "Don't restore file permissions",
+		"skip-times":       "Don't restore file times",
+		"async":            "Don't wait for the restore to finish",
+	},
+}, {
+	Name:  "estimate",
+	Short: "Estimate the size of a snapshot of the source.",
+	Long: `This command asks the kopia server to scan the source path on the
+server host, applying the source's policy, and reports how many files
+and directories a snapshot would contain, their total size and how
+much the policy excludes.
+
+Usage Examples:
+
+    rclone backend estimate kopia:
+    rclone backend estimate kopia: -o async
+
+By default rclone waits for the estimate to finish. With -o async it
+returns the task as soon as it has started - use the tasks command to
+follow it.
+`,
+	Opts: map[string]string{
+		"async": "Don't wait for the estimate to finish",
+	},
+}, {
+	Name:  "repo-status",
+	Short: "Show the repository details from the server.",
+	Long: `This command returns what the kopia server reports about the
+repository - the storage type, format version, hash, encryption,
+splitter and error correction algorithms and the client it is
+connected as - along with the user@host of every client which has
+snapshot sources in the repository.
+
+Usage Example:
+
+    rclone backend repo-status kopia:
+`,
+}, {
+	Name:  "tasks",
+	Short: "Show the tasks running on the kopia server.",
+	Long: `This command lists the tasks the kopia server has run or is running,
+such as snapshots, restores and maintenance, with their progress
+counters. Given a task ID it shows just that task.
+
+Usage Examples:
+
+    rclone backend tasks kopia:
+    rclone backend tasks kopia: -o running
+    rclone backend tasks kopia: 42 -o logs
+
+-o logs adds the log messages of the task, so the progress of a
+restore started with -o async can be followed without the kopia UI.
+`,
+	Opts: map[string]string{
+		"running": "Only list tasks which haven't finished",
+		"logs":    "Show the log of the task given",
+	},
+}}
+
+// Command the backend to run a named command
+//
+// The command run is name
+// args may be used to read arguments from
+// opts may be used to read optional arguments from
+//
+// The result should be capable of being JSON encoded
+// If it is a string or a []string it will be shown to the user
+// otherwise it will be JSON encoded and shown to the user like that
+func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[string]string) (out interface{}, err error) {
+	switch name {
+	case "snapshots":
+		return f.snapshots(ctx)
+	case "diff":
+		if len(arg) > 1 {
+			return nil, errors.New("need 0 or 1 arguments: [path]")
+		}
+		remote := ""
+		if len(arg) > 0 {
+			remote = arg[0]
+		}
+		return f.diff(ctx, remote, opt)
+	case "du":
+		if len(arg) > 1 {
+			return nil, errors.New("need 0 or 1 arguments: [path]")
+		}
+		remote := ""
+		if len(arg) > 0 {
+			remote = arg[0]
+		}
+		return f.du(ctx, remote, opt)
+	case "find-object":
+		if len(arg) != 1 {
+			return nil, errors.New("need exactly 1 argument: the object ID")
+		}
+		return f.findObject(ctx, arg[0], opt)
+	case "manifest":
+		if len(arg) > 0 {
+			return nil, errors.New("manifest takes no arguments")
+		}
+		return f.manifest(ctx, opt)
+	case "check":
+		if len(arg) != 1 {
+			return nil, errors.New("need exactly 1 argument: the destination to check")
+		}
+		return f.checkCommand(ctx, arg[0], opt)
+	case "fsck":
+		return f.fsck(ctx, opt)
+	case "chunks":
+		if len(arg) != 1 {
+			return nil, errors.New("need exactly 1 argument: the path of the file")
+		}
+		return f.chunks(ctx, arg[0])
+	case "estimate":
+		if len(arg) > 0 {
+			return nil, errors.New("estimate takes no arguments")
+		}
+		return f.estimate(ctx, opt)
+	case "repo-status":
+		if len(arg) > 0 {
+			return nil, errors.New("repo-status takes no arguments")
+		}
+		return f.repoStatus(ctx)
+	case "tasks":
+		if len(arg) > 1 {
+			return nil, errors.New("need 0 or 1 arguments: [task ID]")
+		}
+		if len(arg) > 0 {
+			return f.task(ctx, arg[0], opt)
+		}
+		return f.tasks(ctx, opt)
+	case "sums":
+		if len(arg) > 1 {
+			return nil, errors.New("need 0 or 1 arguments: [path]")
+		}
+		dir := ""
+		if len(arg) > 0 {
+			dir = arg[0]
+		}
+		return f.sums(ctx, dir, opt)
+	case "stat":
+		if len(arg) != 1 {
+			return nil, errors.New("need exactly 1 argument: the path to show")
+		}
+		return f.stat(ctx, arg[0])
+	case "sparse":
+		if len(arg) != 2 {
+			return nil, errors.New("need 2 arguments: file local-path")
+		}
+		return f.sparse(ctx, arg[0], arg[1])
+	case "hardlinks":
+		if len(arg) != 1 {
+			return nil, errors.New("need exactly 1 argument: the local directory to relink")
+		}
+		return f.hardlinks(ctx, arg[0])
+	case "restore":
+		if len(arg) > 1 {
+			return nil, errors.New("need 0 or 1 arguments: [path]")
+		}
+		remote := ""
+		if len(arg) > 0 {
+			remote = arg[0]
+		}
+		return f.restore(ctx, remote, opt)
+	case "export":
+		if len(arg) > 1 {
+			return nil, errors.New("need 0 or 1 arguments: [path]")
+		}
+		remote := ""
+		if len(arg) > 0 {
+			remote = arg[0]
+		}
+		return f.export(ctx, remote, opt)
+	case "commit":
+		return f.commitCommand(ctx)
+	case "rollback":
+		return f.rollback()
+	case "maintenance":
+		return f.maintenance(ctx, opt)
+	case "delete-source":
+		return f.deleteSource(ctx, opt)
+	default:
+		return nil, fs.ErrorCommandNotFound
+	}
+}
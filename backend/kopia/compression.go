@@ -0,0 +1,139 @@
+package kopia
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/rest"
+)
+
+// compressionHeaders maps kopia compressor names to the header IDs
+// the server's content API takes
+var compressionHeaders = map[string]uint32{
+	"gzip":                     0x1000,
+	"gzip-best-speed":          0x1001,
+	"gzip-best-compression":    0x1002,
+	"zstd":                     0x1100,
+	"zstd-fastest":             0x1101,
+	"zstd-better-compression":  0x1102,
+	"zstd-best-compression":    0x1103,
+	"s2-default":               0x1200,
+	"s2-better":                0x1201,
+	"s2-parallel-4":            0x1202,
+	"s2-parallel-8":            0x1203,
+	"pgzip":                    0x1300,
+	"pgzip-best-speed":         0x1301,
+	"pgzip-best-compression":   0x1302,
+	"lz4":                      0x1400,
+	"deflate-default":          0x1500,
+	"deflate-best-speed":       0x1501,
+	"deflate-best-compression": 0x1502,
+}
+
+// checkCompressor checks the compression option is valid
+func checkCompressor(name string) error {
+	if name == "" || name == "none" {
+		return nil
+	}
+	if _, ok := compressionHeaders[name]; !ok {
+		return fmt.Errorf("kopia: unknown compression %q", name)
+	}
+	return nil
+}
+
+// compressorFor returns the compressor the policy uses for a file
+// called name of the given size, or "" for none.
+//
+// A size < 0 means the size isn't known so the size limits are
+// ignored.
+func (p *CompressionPolicy) compressorFor(name string, size int64) string {
+	if p.CompressorName == "" || p.CompressorName == "none" {
+		return ""
+	}
+	if size >= 0 && p.MinSize > 0 && size < p.MinSize {
+		return ""
+	}
+	if size >= 0 && p.MaxSize > 0 && size > p.MaxSize {
+		return ""
+	}
+	ext := path.Ext(name)
+	hasExt := func(exts []string) bool {
+		for _, e := range exts {
+			if strings.EqualFold(e, ext) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(p.OnlyCompress) > 0 && !hasExt(p.OnlyCompress) {
+		return ""
+	}
+	if hasExt(p.NeverCompress) {
+		return ""
+	}
+	return p.CompressorName
+}
+
+// compressionPolicy returns the effective compression policy of the
+// source, reading it from the server the first time.
+//
+// If it can't be read then nothing is compressed.
+func (f *Fs) compressionPolicy(ctx context.Context) *CompressionPolicy {
+	f.policyMu.Lock()
+	defer f.policyMu.Unlock()
+	if f.policy != nil {
+		return f.policy
+	}
+	var result ResolvePolicyResponse
+	err := f.callJSON(ctx, &rest.Opts{
+		Method: "POST",
+		Path:   "/api/v1/policy/resolve",
+		Parameters: url.Values{
+			"userName": []string{f.opt.User},
+			"host":     []string{f.opt.Host},
+			"path":     []string{f.opt.Path},
+		},
+	}, &ResolvePolicyRequest{Updates: &Policy{}}, &result)
+	if err != nil {
+		fs.Logf(f, "Uploading without compression: failed to read compression policy: %v", err)
+		f.policy = &CompressionPolicy{}
+		return f.policy
+	}
+	f.policy = &result.Effective.Compression
+	fs.Debugf(f, "Using compression policy %+v", *f.policy)
+	return f.policy
+}
+
+// uploadCompressor returns the compressor to upload remote with, or ""
+// for none
+func (f *Fs) uploadCompressor(ctx context.Context, remote string, size int64) string {
+	if !f.canCompress || f.opt.Compression == "none" || fs.GetConfig(ctx).DryRun {
+		// nothing is uploaded with --dry-run so don't read the policy
+		return ""
+	}
+	if f.opt.Compression != "" {
+		return f.opt.Compression
+	}
+	compressor := f.compressionPolicy(ctx).compressorFor(path.Base(remote), size)
+	if _, ok := compressionHeaders[compressor]; compressor != "" && !ok {
+		fs.Debugf(f, "Uploading without compression: unknown compressor %q in policy", compressor)
+		return ""
+	}
+	return compressor
+}
+
+// compressionParameter returns the query parameter telling the server
+// to compress a content with compressor
+func compressionParameter(compressor string) url.Values {
+	if compressor == "" {
+		return nil
+	}
+	return url.Values{
+		"compression": []string{strconv.FormatUint(uint64(compressionHeaders[compressor]), 16)},
+	}
+}
@@ -0,0 +1,112 @@
+package kopia
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// duOptions are the options of the du command
+type duOptions struct {
+	maxDepth int  // depth of directories to show
+	bytes    bool // show sizes in bytes
+}
+
+// du returns the size of the directory at remote and of the
+// directories in it down to opt["max-depth"], deepest first like du.
+//
+// The sizes come from the summaries kopia records so only the
+// directories shown need to be read.
+func (f *Fs) du(ctx context.Context, remote string, opt map[string]string) ([]string, error) {
+	dopt := duOptions{maxDepth: 1}
+	if s := opt["max-depth"]; s != "" {
+		depth, err := strconv.Atoi(s)
+		if err != nil || depth < 0 {
+			return nil, fmt.Errorf("bad max-depth %q", s)
+		}
+		dopt.maxDepth = depth
+	}
+	if s, ok := opt["bytes"]; ok {
+		var err error
+		dopt.bytes, err = strconv.ParseBool(s)
+		if err != nil && s != "" {
+			return nil, fmt.Errorf("bad bytes option: %w", err)
+		}
+		dopt.bytes = dopt.bytes || s == ""
+	}
+	rootID, err := f.getRootId(ctx)
+	if err != nil {
+		return nil, err
+	}
+	id, err := f.dirObjectID(ctx, rootID, cleanPath(path.Join(f.root, remote)))
+	if err != nil {
+		return nil, err
+	}
+	if id == "" {
+		return nil, fs.ErrorDirNotFound
+	}
+	if remote == "" {
+		remote = "."
+	}
+	out := []string{}
+	if _, err := f.duDir(ctx, &dopt, &out, id, remote, 0); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// duDir adds the sizes of the directory object id at remote and of
+// the directories in it to out, returning the size of the directory.
+//
+// Directories below the maximum depth are only read if they don't
+// have a summary.
+func (f *Fs) duDir(ctx context.Context, opt *duOptions, out *[]string, id, remote string, depth int) (int64, error) {
+	d, err := f.readDirObject(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	names := make([]string, 0, len(d.entries))
+	for name := range d.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var total int64
+	for _, name := range names {
+		e := d.entries[name]
+		switch e.entry.Type {
+		case "d":
+			dirRemote := path.Join(remote, f.opt.Enc.ToStandardName(f.decodeName(name)))
+			size := e.entry.Summary.Size
+			if depth+1 < opt.maxDepth || e.entry.Summary.empty() {
+				size, err = f.duDir(ctx, opt, out, e.entry.Obj, dirRemote, depth+1)
+				if err != nil {
+					return 0, err
+				}
+			} else if depth+1 == opt.maxDepth {
+				*out = append(*out, opt.line(size, dirRemote))
+			}
+			total += size
+		case "f", "":
+			total += e.entry.Size
+		}
+	}
+	if !d.summary.empty() {
+		total = d.summary.Size
+	}
+	if depth <= opt.maxDepth {
+		*out = append(*out, opt.line(total, remote))
+	}
+	return total, nil
+}
+
+// line formats the size of the directory at remote
+func (opt *duOptions) line(size int64, remote string) string {
+	if opt.bytes {
+		return strconv.FormatInt(size, 10) + "\t" + remote
+	}
+	return fs.SizeSuffix(size).String() + "\t" + remote
+}
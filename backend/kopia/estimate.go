@@ -0,0 +1,59 @@
+package kopia
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/rest"
+)
+
+// estimateReport is the output of the estimate command
+type estimateReport struct {
+	Source              string `json:"source"`
+	TaskID              string `json:"taskID"`
+	Files               int64  `json:"files"`
+	Directories         int64  `json:"directories"`
+	Size                int64  `json:"size"`
+	ExcludedFiles       int64  `json:"excludedFiles"`
+	ExcludedDirectories int64  `json:"excludedDirectories"`
+	ExcludedSize        int64  `json:"excludedSize"`
+	Errors              int64  `json:"errors"`
+}
+
+// estimate asks the server how much a snapshot of the source would
+// contain, waiting for the estimate unless opt["async"] is set.
+func (f *Fs) estimate(ctx context.Context, opt map[string]string) (interface{}, error) {
+	async, err := boolOption(opt, "async")
+	if err != nil {
+		return nil, err
+	}
+	source := f.source()
+	var task TaskInfo
+	err = f.callJSON(ctx, &rest.Opts{
+		Method: "POST",
+		Path:   "/api/v1/estimate",
+	}, &EstimateRequest{Root: source.Path}, &task)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start estimate: %w", err)
+	}
+	fs.Infof(f, "Started estimate task %s of %v on the server", task.ID, source)
+	if async {
+		return &task, nil
+	}
+	done, err := f.waitTask(ctx, &task)
+	if err != nil {
+		return nil, err
+	}
+	return &estimateReport{
+		Source:              source.String(),
+		TaskID:              done.ID,
+		Files:               done.Counters["Files"].Value,
+		Directories:         done.Counters["Directories"].Value,
+		Size:                done.Counters["Bytes"].Value,
+		ExcludedFiles:       done.Counters["Excluded Files"].Value,
+		ExcludedDirectories: done.Counters["Excluded Directories"].Value,
+		ExcludedSize:        done.Counters["Excluded Bytes"].Value,
+		Errors:              done.Counters["Errors"].Value,
+	}, nil
+}
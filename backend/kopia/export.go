@@ -0,0 +1,304 @@
+package kopia
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// exportReport is the output of the export command
+type exportReport struct {
+	Path   string `json:"path"`
+	Format string `json:"format"`
+	Output string `json:"output"`
+	Files  int    `json:"files"`
+	Dirs   int    `json:"dirs"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// archiveWriter writes entries to an archive
+type archiveWriter interface {
+	// addDir adds the directory name
+	addDir(name string, info *ObjectInfo) error
+	// addFile adds the file name with the contents read from in
+	addFile(name string, info *ObjectInfo, in io.Reader) error
+	// Close finishes the archive
+	Close() error
+}
+
+// exportFormat works out the archive format from the format option
+// or the output file name
+func exportFormat(format, output string) (string, error) {
+	if format == "" {
+		switch {
+		case strings.HasSuffix(output, ".zip"):
+			format = "zip"
+		case strings.HasSuffix(output, ".tar.gz"), strings.HasSuffix(output, ".tgz"):
+			format = "tgz"
+		default:
+			format = "tar"
+		}
+	}
+	switch format {
+	case "tar", "tgz", "zip":
+		return format, nil
+	}
+	return "", fmt.Errorf("unknown export format %q - use tar, tgz or zip", format)
+}
+
+// perm returns the permissions of info, or def if not known
+func (o *ObjectInfo) perm(def int64) int64 {
+	if o.entry == nil || o.entry.Mode == "" {
+		return def
+	}
+	perm, err := strconv.ParseUint(o.entry.Mode, 8, 32)
+	if err != nil {
+		return def
+	}
+	return int64(perm & 0o7777)
+}
+
+// tarArchive writes a tar file, compressed if gz is set
+type tarArchive struct {
+	tw *tar.Writer
+	gz *gzip.Writer
+}
+
+// newTarArchive makes an archiveWriter writing a tar file to out
+func newTarArchive(out io.Writer, compress bool) *tarArchive {
+	a := &tarArchive{}
+	if compress {
+		a.gz = gzip.NewWriter(out)
+		out = a.gz
+	}
+	a.tw = tar.NewWriter(out)
+	return a
+}
+
+// header makes a tar header for info
+func (a *tarArchive) header(name string, info *ObjectInfo, typeflag byte, def int64) *tar.Header {
+	h := &tar.Header{
+		Typeflag: typeflag,
+		Name:     name,
+		Mode:     info.perm(def),
+		ModTime:  info.modTime,
+		Format:   tar.FormatPAX,
+	}
+	if e := info.entry; e != nil {
+		h.Uid, h.Gid = int(e.UserID), int(e.GroupID)
+		h.Uname, h.Gname = e.User, e.Group
+	}
+	return h
+}
+
+func (a *tarArchive) addDir(name string, info *ObjectInfo) error {
+	return a.tw.WriteHeader(a.header(name+"/", info, tar.TypeDir, 0o755))
+}
+
+func (a *tarArchive) addFile(name string, info *ObjectInfo, in io.Reader) error {
+	h := a.header(name, info, tar.TypeReg, 0o644)
+	h.Size = info.size
+	if err := a.tw.WriteHeader(h); err != nil {
+		return err
+	}
+	_, err := io.Copy(a.tw, in)
+	return err
+}
+
+func (a *tarArchive) Close() error {
+	err := a.tw.Close()
+	if a.gz != nil {
+		if gzErr := a.gz.Close(); err == nil {
+			err = gzErr
+		}
+	}
+	return err
+}
+
+// zipArchive writes a zip file
+type zipArchive struct {
+	zw *zip.Writer
+}
+
+// header makes a zip header for info
+func (a *zipArchive) header(name string, info *ObjectInfo, def int64, dir bool) *zip.FileHeader {
+	h := &zip.FileHeader{
+		Name:     name,
+		Modified: info.modTime,
+		Method:   zip.Deflate,
+	}
+	mode := os.FileMode(info.perm(def))
+	if dir {
+		mode |= os.ModeDir
+		h.Method = zip.Store
+	}
+	h.SetMode(mode)
+	return h
+}
+
+func (a *zipArchive) addDir(name string, info *ObjectInfo) error {
+	_, err := a.zw.CreateHeader(a.header(name+"/", info, 0o755, true))
+	return err
+}
+
+func (a *zipArchive) addFile(name string, info *ObjectInfo, in io.Reader) error {
+	w, err := a.zw.CreateHeader(a.header(name, info, 0o644, false))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, in)
+	return err
+}
+
+func (a *zipArchive) Close() error {
+	return a.zw.Close()
+}
+
+// export writes the file or directory at remote to an archive.
+//
+// The archive is built here rather than by the server as the server's
+// restore task can only write archives to its own disk.
+func (f *Fs) export(ctx context.Context, remote string, opt map[string]string) (out interface{}, err error) {
+	output := opt["output"]
+	format, err := exportFormat(opt["format"], output)
+	if err != nil {
+		return nil, err
+	}
+	onServer, err := boolOption(opt, "server")
+	if err != nil {
+		return nil, err
+	}
+	if onServer {
+		return f.exportOnServer(ctx, remote, format, output)
+	}
+	if remote == "" {
+		remote = f.rootFile
+	}
+	full := cleanPath(path.Join(f.root, remote))
+	report := &exportReport{Path: full, Format: format, Output: output}
+
+	// check the path exists before creating the output
+	var obj DirEntry
+	if full != "" {
+		if obj, err = f.newObject(ctx, full); err != nil {
+			return nil, err
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if output != "" && output != "-" {
+		var file *os.File
+		file, err = os.Create(output)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create export: %w", err)
+		}
+		defer fs.CheckClose(file, &err)
+		w = file
+	}
+	var a archiveWriter
+	if format == "zip" {
+		a = &zipArchive{zw: zip.NewWriter(w)}
+	} else {
+		a = newTarArchive(w, format == "tgz")
+	}
+	if o, ok := obj.(*Object); ok {
+		err = f.exportObject(ctx, a, o.name, o, report)
+	} else {
+		err = f.exportDir(ctx, a, full, "", report)
+	}
+	if closeErr := a.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("export failed: %w", err)
+	}
+	fs.Infof(f, "Exported %d files in %d directories (%d bytes) from %q", report.Files, report.Dirs, report.Bytes, full)
+	if output == "" || output == "-" {
+		// don't write the report after the archive
+		return nil, nil
+	}
+	return report, nil
+}
+
+// exportDir writes the contents of the directory at remote to the
+// archive under prefix
+func (f *Fs) exportDir(ctx context.Context, a archiveWriter, remote, prefix string, report *exportReport) error {
+	entries, err := f.list(ctx, remote)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		switch x := entry.(type) {
+		case *Object:
+			if x.special {
+				continue
+			}
+			if err := f.exportObject(ctx, a, path.Join(prefix, x.name), x, report); err != nil {
+				return err
+			}
+		case *Directory:
+			name := path.Join(prefix, x.name)
+			if err := a.addDir(name, &x.ObjectInfo); err != nil {
+				return err
+			}
+			report.Dirs++
+			if err := f.exportDir(ctx, a, x.remote, name, report); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// exportObject writes o to the archive as name
+func (f *Fs) exportObject(ctx context.Context, a archiveWriter, name string, o *Object, report *exportReport) (err error) {
+	in, err := o.Open(ctx)
+	if err != nil {
+		return err
+	}
+	defer fs.CheckClose(in, &err)
+	if err = a.addFile(name, &o.ObjectInfo, in); err != nil {
+		return fmt.Errorf("%s: %w", o.remote, err)
+	}
+	report.Files++
+	report.Bytes += o.size
+	return nil
+}
+
+// exportOnServer runs a restore task writing the archive to output on
+// the server host
+func (f *Fs) exportOnServer(ctx context.Context, remote, format, output string) (*TaskInfo, error) {
+	if output == "" || output == "-" {
+		return nil, errors.New("need -o output=path on the server to export to")
+	}
+	req := &RestoreRequest{}
+	switch format {
+	case "zip":
+		req.ZipFile = output
+	case "tar":
+		req.TarFile = output
+	default:
+		return nil, fmt.Errorf("format %q can't be written on the server - use tar or zip", format)
+	}
+	var err error
+	req.Root, err = f.pathObjectID(ctx, remote)
+	if err != nil {
+		return nil, err
+	}
+	task, err := f.startRestore(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	fs.Infof(f, "Started export task %s of %s to %q on the server", task.ID, req.Root, output)
+	return f.waitTask(ctx, task)
+}
@@ -0,0 +1,156 @@
+package kopia
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// foundObject is a path referencing the object in the output of the
+// find-object command
+type foundObject struct {
+	Snapshot string `json:"snapshot"`
+	Path     string `json:"path"`
+}
+
+// findObjectReport is the output of the find-object command
+type findObjectReport struct {
+	ObjectID string        `json:"objectID"`
+	Found    []foundObject `json:"found"`
+}
+
+// objectFinder searches directory trees for an object ID
+type objectFinder struct {
+	f      *Fs
+	id     string              // object ID to look for
+	chunks bool                // look in the chunks of indirect objects
+	found  map[string][]string // directory object ID to paths found in it
+}
+
+// findObject returns the paths in the snapshot which reference the
+// object id, or in every snapshot if opt["all"] is set.
+//
+// With opt["chunks"] files made of several contents are listed if
+// any of them is id, which means reading the index of every such file.
+func (f *Fs) findObject(ctx context.Context, id string, opt map[string]string) (*findObjectReport, error) {
+	finder := &objectFinder{
+		f:     f,
+		id:    id,
+		found: map[string][]string{},
+	}
+	all, err := boolOption(opt, "all")
+	if err != nil {
+		return nil, err
+	}
+	if finder.chunks, err = boolOption(opt, "chunks"); err != nil {
+		return nil, err
+	}
+	result, _, _, err := f.fetchSnapshots(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	snapshots := result.Snapshots
+	if !all {
+		rootID, err := f.getRootId(ctx)
+		if err != nil {
+			return nil, err
+		}
+		i, err := findSnapshot(snapshots, rootID)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = snapshots[i : i+1]
+	}
+	report := &findObjectReport{
+		ObjectID: id,
+		Found:    []foundObject{},
+	}
+	root := cleanPath(f.root)
+	for _, s := range snapshots {
+		dirID, err := f.dirObjectID(ctx, s.RootID, root)
+		if errors.Is(err, fs.ErrorIsFile) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if dirID == "" {
+			continue
+		}
+		paths, err := finder.find(ctx, dirID)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range paths {
+			if root != "" {
+				p = root + "/" + p
+			}
+			report.Found = append(report.Found, foundObject{
+				Snapshot: s.ID,
+				Path:     p,
+			})
+		}
+	}
+	return report, nil
+}
+
+// find returns the paths relative to the directory object dirID which
+// reference the object, with a trailing / for directories.
+//
+// Directories are only searched once however many snapshots they
+// are in.
+func (finder *objectFinder) find(ctx context.Context, dirID string) ([]string, error) {
+	if paths, ok := finder.found[dirID]; ok {
+		return paths, nil
+	}
+	f := finder.f
+	d, err := f.readDirObject(ctx, dirID)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(d.entries))
+	for name := range d.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	paths := []string{}
+	for _, name := range names {
+		e := d.entries[name]
+		remote, err := f.entryName(name)
+		if err != nil {
+			fs.Errorf(f, "Skipping entry in directory object %s: %v", dirID, err)
+			continue
+		}
+		switch {
+		case e.entry.Type == "d":
+			if e.entry.Obj == finder.id {
+				paths = append(paths, remote+"/")
+			}
+			sub, err := finder.find(ctx, e.entry.Obj)
+			if err != nil {
+				return nil, err
+			}
+			for _, p := range sub {
+				paths = append(paths, remote+"/"+p)
+			}
+		case e.entry.Obj == finder.id:
+			paths = append(paths, remote)
+		case finder.chunks && strings.HasPrefix(e.entry.Obj, "I"):
+			chunks, err := f.objectChunks(ctx, e.entry.Obj, 0, e.entry.Size)
+			if err != nil {
+				return nil, err
+			}
+			for _, c := range chunks {
+				if c.ID == finder.id {
+					paths = append(paths, remote)
+					break
+				}
+			}
+		}
+	}
+	finder.found[dirID] = paths
+	return paths, nil
+}
@@ -0,0 +1,174 @@
+package kopia
+
+import (
+	"context"
+	"fmt"
+	gohash "hash"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/hash"
+	"golang.org/x/sync/errgroup"
+)
+
+// fsckReport is the output of the fsck command
+type fsckReport struct {
+	SnapshotRoot string   `json:"snapshotRoot"`
+	Dirs         int      `json:"dirs"`
+	Objects      int      `json:"objects"`
+	Bytes        int64    `json:"bytes"`
+	OK           int      `json:"ok"`
+	Failed       []string `json:"failed,omitempty"`
+}
+
+// fsckOptions control how thoroughly objects are checked
+type fsckOptions struct {
+	download bool               // read all the data
+	hashType hash.Type          // compute this hash while reading
+	newHash  func() gohash.Hash // repository hash to check content IDs with, if set
+}
+
+// fsck walks the snapshot checking every directory can be listed and
+// every object can be retrieved and has the declared size.
+func (f *Fs) fsck(ctx context.Context, opt map[string]string) (*fsckReport, error) {
+	var fopt fsckOptions
+	if s, ok := opt["download"]; ok {
+		var err error
+		fopt.download, err = strconv.ParseBool(s)
+		if err != nil && s != "" {
+			return nil, fmt.Errorf("bad download option: %w", err)
+		}
+		fopt.download = fopt.download || s == ""
+	}
+	if name := opt["hash"]; name != "" {
+		if err := fopt.hashType.Set(name); err != nil {
+			return nil, err
+		}
+		if !f.hashes.Contains(fopt.hashType) {
+			return nil, fmt.Errorf("hash %v not in --kopia-hashes", fopt.hashType)
+		}
+		fopt.download = true
+	}
+	if s, ok := opt["content"]; ok {
+		content, err := strconv.ParseBool(s)
+		if err != nil && s != "" {
+			return nil, fmt.Errorf("bad content option: %w", err)
+		}
+		if content || s == "" {
+			fopt.newHash, err = f.contentHasher(ctx)
+			if err != nil {
+				return nil, err
+			}
+			fopt.download = true
+		}
+	}
+	rootID, err := f.getRootId(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report := &fsckReport{SnapshotRoot: rootID}
+	var mu sync.Mutex
+	fail := func(remote string, err error) {
+		mu.Lock()
+		report.Failed = append(report.Failed, fmt.Sprintf("%s: %v", remote, err))
+		mu.Unlock()
+	}
+	g, gCtx := errgroup.WithContext(ctx)
+	g.SetLimit(fs.GetConfig(ctx).Checkers)
+	var walkDir func(dir string)
+	walkDir = func(dir string) {
+		entries, err := f.List(gCtx, dir)
+		if err != nil {
+			fail(dir, err)
+			return
+		}
+		mu.Lock()
+		report.Dirs++
+		mu.Unlock()
+		for _, entry := range entries {
+			switch x := entry.(type) {
+			case *Directory:
+				walkDir(x.Remote())
+			case *Object:
+				g.Go(func() error {
+					n, err := x.fsck(gCtx, fopt)
+					mu.Lock()
+					report.Objects++
+					report.Bytes += n
+					if err == nil {
+						report.OK++
+					}
+					mu.Unlock()
+					if err != nil {
+						if gCtx.Err() != nil {
+							return gCtx.Err()
+						}
+						fail(x.Remote(), err)
+					}
+					return nil
+				})
+			}
+		}
+	}
+	walkDir("")
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	sort.Strings(report.Failed)
+	fs.Infof(f, "fsck: %d dirs, %d objects, %d ok, %d failed", report.Dirs, report.Objects, report.OK, len(report.Failed))
+	return report, nil
+}
+
+// fsck checks a single object returning the number of bytes it has
+func (o *Object) fsck(ctx context.Context, fopt fsckOptions) (n int64, err error) {
+	if o.special {
+		return 0, nil
+	}
+	if !fopt.download {
+		n, err = o.remoteSize(ctx)
+		if err != nil {
+			return 0, err
+		}
+		if n != o.size {
+			return n, fmt.Errorf("size %d doesn't match declared size %d", n, o.size)
+		}
+		return n, nil
+	}
+	in, err := o.open(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer fs.CheckClose(in, &err)
+	if fopt.newHash != nil && !o.fs.opt.VerifyContent {
+		in = newContentCheckingReader(o, in, fopt.newHash)
+	}
+	var hasher *hash.MultiHasher
+	var w io.Writer = io.Discard
+	if fopt.hashType != hash.None {
+		hasher, err = hash.NewMultiHasherTypes(hash.NewHashSet(fopt.hashType))
+		if err != nil {
+			return 0, err
+		}
+		w = hasher
+	}
+	n, err = io.Copy(w, in)
+	if err != nil {
+		return n, err
+	}
+	if n != o.size {
+		return n, fmt.Errorf("read %d bytes expecting declared size %d", n, o.size)
+	}
+	if hasher == nil {
+		return n, nil
+	}
+	sum, _ := hasher.SumString(fopt.hashType, false)
+	want, ok := o.fs.hashCache.get(o.id, fopt.hashType)
+	if ok && sum != want {
+		return n, fmt.Errorf("%v hash %s doesn't match expected %s", fopt.hashType, sum, want)
+	}
+	o.fs.hashCache.put(o.id, map[hash.Type]string{fopt.hashType: sum})
+	return n, nil
+}
@@ -0,0 +1,163 @@
+package kopia
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/walk"
+)
+
+// hardlinksReport is the output of the hardlinks command
+type hardlinksReport struct {
+	Groups int      `json:"groups"`
+	Linked int      `json:"linked"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// linkable returns true if o could be part of a hardlink group
+func (o *Object) linkable() bool {
+	return o.entry != nil && o.entry.Type == "f" && o.size > 0
+}
+
+// hardlinkGroups returns the remotes of the files in the snapshot
+// keyed by object ID, listing the whole snapshot the first time it is
+// called for each snapshot root.
+func (f *Fs) hardlinkGroups(ctx context.Context) (map[string][]string, error) {
+	rootID, err := f.getRootId(ctx)
+	if err != nil {
+		return nil, err
+	}
+	f.linkMu.Lock()
+	defer f.linkMu.Unlock()
+	if f.linkGroups != nil && f.linkRootID == rootID {
+		return f.linkGroups, nil
+	}
+	fs.Debugf(f, "Listing snapshot to find hardlinks")
+	groups := map[string][]string{}
+	var mu sync.Mutex
+	err = walk.ListR(ctx, f, "", true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+		mu.Lock()
+		defer mu.Unlock()
+		entries.ForObject(func(obj fs.Object) {
+			if o, ok := obj.(*Object); ok && o.linkable() {
+				groups[o.id] = append(groups[o.id], o.Remote())
+			}
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshot for hardlinks: %w", err)
+	}
+	for _, remotes := range groups {
+		sort.Strings(remotes)
+	}
+	f.linkGroups, f.linkRootID = groups, rootID
+	return groups, nil
+}
+
+// hardlinks replaces duplicated files in a restore of the snapshot
+// at dir on local disk with hardlinks to the first file of each group
+func (f *Fs) hardlinks(ctx context.Context, dir string) (*hardlinksReport, error) {
+	groups, err := f.hardlinkGroups(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report := &hardlinksReport{}
+	for _, remotes := range groups {
+		if len(remotes) < 2 {
+			continue
+		}
+		report.Groups++
+		first := filepath.Join(dir, filepath.FromSlash(remotes[0]))
+		for _, remote := range remotes[1:] {
+			err := relink(first, filepath.Join(dir, filepath.FromSlash(remote)))
+			if err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", remote, err))
+				continue
+			}
+			report.Linked++
+		}
+	}
+	sort.Strings(report.Errors)
+	fs.Infof(f, "hardlinks: %d groups, %d linked, %d errors", report.Groups, report.Linked, len(report.Errors))
+	return report, nil
+}
+
+// relink replaces dst with a hardlink to src if they are different
+// files with the same contents.
+//
+// The contents are compared as either may have been changed since
+// they were restored.
+func relink(src, dst string) error {
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	dstInfo, err := os.Stat(dst)
+	if err != nil {
+		return err
+	}
+	if os.SameFile(srcInfo, dstInfo) {
+		return nil
+	}
+	if srcInfo.Size() != dstInfo.Size() {
+		return errors.New("size differs from the other files in the group")
+	}
+	same, err := sameContents(src, dst)
+	if err != nil {
+		return err
+	}
+	if !same {
+		return errors.New("contents differ from the other files in the group")
+	}
+	tmp := dst + ".rclone-link"
+	if err := os.Link(src, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// sameContents returns true if the files at a and b hold the same bytes
+func sameContents(a, b string) (same bool, err error) {
+	inA, err := os.Open(a)
+	if err != nil {
+		return false, err
+	}
+	defer fs.CheckClose(inA, &err)
+	inB, err := os.Open(b)
+	if err != nil {
+		return false, err
+	}
+	defer fs.CheckClose(inB, &err)
+	bufA := make([]byte, 64*1024)
+	bufB := make([]byte, len(bufA))
+	for {
+		nA, errA := io.ReadFull(inA, bufA)
+		nB, errB := io.ReadFull(inB, bufB)
+		if !bytes.Equal(bufA[:nA], bufB[:nB]) {
+			return false, nil
+		}
+		endA, endB := errA == io.EOF || errA == io.ErrUnexpectedEOF, errB == io.EOF || errB == io.ErrUnexpectedEOF
+		if errA != nil && !endA {
+			return false, errA
+		}
+		if errB != nil && !endB {
+			return false, errB
+		}
+		if endA || endB {
+			return endA && endB, nil
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package kopia
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/lib/kv"
+)
+
+// hashCache stores checksums computed for objects keyed by object ID.
+//
+// Kopia objects are content addressed and immutable so entries never
+// go stale. If db is set the checksums are persisted there too.
+type hashCache struct {
+	mu     sync.Mutex
+	hashes map[string]map[hash.Type]string
+	db     *kv.DB
+}
+
+// newHashCache makes an empty hashCache backed by db if not nil
+func newHashCache(db *kv.DB) *hashCache {
+	return &hashCache{
+		hashes: make(map[string]map[hash.Type]string),
+		db:     db,
+	}
+}
+
+// get the checksum of type ty for object id if known
+func (c *hashCache) get(id string, ty hash.Type) (sum string, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if m, found := c.hashes[id]; found || c.db == nil {
+		sum, ok = m[ty]
+		return sum, ok
+	}
+	op := &kvGetHashes{id: id}
+	if err := c.db.Do(false, op); err != nil {
+		fs.Debugf(nil, "kopia: failed to read hash cache: %v", err)
+		return "", false
+	}
+	m := make(map[hash.Type]string, len(op.sums))
+	for name, sum := range op.sums {
+		var ht hash.Type
+		if ht.Set(name) == nil {
+			m[ht] = sum
+		}
+	}
+	c.hashes[id] = m
+	sum, ok = m[ty]
+	return sum, ok
+}
+
+// put the checksums for object id
+func (c *hashCache) put(id string, sums map[hash.Type]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m := c.hashes[id]
+	if m == nil {
+		m = make(map[hash.Type]string, len(sums))
+		c.hashes[id] = m
+	}
+	for ty, sum := range sums {
+		m[ty] = sum
+	}
+	if c.db == nil {
+		return
+	}
+	op := &kvPutHashes{id: id, sums: make(map[string]string, len(sums))}
+	for ty, sum := range sums {
+		op.sums[ty.String()] = sum
+	}
+	if err := c.db.Do(true, op); err != nil {
+		fs.Debugf(nil, "kopia: failed to write hash cache: %v", err)
+	}
+}
+
+// close stops the database, if any, writing out the checksums. The
+// cache carries on working in memory afterwards.
+func (c *hashCache) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.db == nil || c.db.IsStopped() {
+		c.db = nil
+		return nil
+	}
+	err := c.db.Stop(false)
+	c.db = nil
+	return err
+}
+
+// clear forgets all the checksums, removing them from the database
+// too
+func (c *hashCache) clear() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hashes = make(map[string]map[hash.Type]string)
+	if c.db == nil {
+		return nil
+	}
+	return c.db.Do(true, &kvClearHashes{})
+}
+
+// kvGetHashes reads the checksums of an object from the database
+type kvGetHashes struct {
+	id   string
+	sums map[string]string
+}
+
+// Do the database operation
+func (op *kvGetHashes) Do(ctx context.Context, b kv.Bucket) error {
+	data := b.Get([]byte(op.id))
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, &op.sums)
+}
+
+// kvPutHashes merges the checksums of an object into the database
+type kvPutHashes struct {
+	id   string
+	sums map[string]string
+}
+
+// Do the database operation
+func (op *kvPutHashes) Do(ctx context.Context, b kv.Bucket) error {
+	sums := map[string]string{}
+	if data := b.Get([]byte(op.id)); len(data) != 0 {
+		_ = json.Unmarshal(data, &sums)
+	}
+	for name, sum := range op.sums {
+		sums[name] = sum
+	}
+	data, err := json.Marshal(sums)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(op.id), data)
+}
+
+// kvClearHashes removes all the checksums from the database
+type kvClearHashes struct{}
+
+// Do the database operation
+func (op *kvClearHashes) Do(ctx context.Context, b kv.Bucket) error {
+	var keys [][]byte
+	cur := b.Cursor()
+	for key, _ := cur.First(); key != nil; key, _ = cur.Next() {
+		keys = append(keys, append([]byte(nil), key...))
+	}
+	for _, key := range keys {
+		if err := b.Delete(key); err != nil {
+			return err
+		}
+	}
+	fs.Debugf(nil, "kopia: %d entries removed from hash cache", len(keys))
+	return nil
+}
+
+// parseHashes turns the hashes option into a hash.Set
+func parseHashes(names fs.CommaSepList) (set hash.Set, err error) {
+	for _, name := range names {
+		var ht hash.Type
+		if err := ht.Set(name); err != nil {
+			return set, fmt.Errorf("invalid token %q in hash string %q", name, names.String())
+		}
+		set.Add(ht)
+	}
+	return set, nil
+}
+
+// Hash returns the selected checksum of the file
+//
+// Checksums are computed by rclone. If the object hasn't been read
+// already it is downloaded to compute them, unless it is larger than
+// hash_max_size in which case "" is returned.
+func (o *Object) Hash(ctx context.Context, ty hash.Type) (string, error) {
+	if !o.fs.hashes.Contains(ty) {
+		return "", hash.ErrUnsupported
+	}
+	if sum, ok := o.fs.hashCache.get(o.id, ty); ok {
+		return sum, nil
+	}
+	if o.fs.opt.HashMaxSize >= 0 && o.size > int64(o.fs.opt.HashMaxSize) {
+		return "", nil
+	}
+	sums, err := o.computeHashes(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to compute %v hash: %w", ty, err)
+	}
+	return sums[ty], nil
+}
+
+// computeHashes downloads the object to compute all the configured
+// checksums, storing them in the cache.
+func (o *Object) computeHashes(ctx context.Context) (sums map[hash.Type]string, err error) {
+	fs.Debugf(o, "Downloading to compute %v", o.fs.hashes)
+	in, err := o.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer fs.CheckClose(in, &err)
+	hasher, err := hash.NewMultiHasherTypes(o.fs.hashes)
+	if err != nil {
+		return nil, err
+	}
+	n, err := io.Copy(hasher, in)
+	if err != nil {
+		return nil, err
+	}
+	if n != o.size {
+		return nil, fmt.Errorf("read %d bytes expecting %d", n, o.size)
+	}
+	sums = hasher.Sums()
+	o.fs.hashCache.put(o.id, sums)
+	return sums, nil
+}
+
+// hashingReader computes checksums of the data read through it and
+// stores them in the cache when the whole object has been read.
+type hashingReader struct {
+	io.ReadCloser
+	o      *Object
+	hasher *hash.MultiHasher
+	done   bool
+}
+
+// newHashingReader wraps in to compute o's checksums on the fly
+func newHashingReader(o *Object, in io.ReadCloser) io.ReadCloser {
+	hasher, err := hash.NewMultiHasherTypes(o.fs.hashes)
+	if err != nil {
+		return in
+	}
+	return &hashingReader{ReadCloser: in, o: o, hasher: hasher}
+}
+
+// Read bytes, hashing them
+func (r *hashingReader) Read(p []byte) (n int, err error) {
+	n, err = r.ReadCloser.Read(p)
+	_, _ = r.hasher.Write(p[:n])
+	if err == io.EOF && !r.done && r.hasher.Size() == r.o.size {
+		r.done = true
+		r.o.fs.hashCache.put(r.o.id, r.hasher.Sums())
+	}
+	return n, err
+}
@@ -0,0 +1,138 @@
+package kopia
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Names in snapshots are the bytes read from the source, which needn't
+// be valid UTF-8. encoding/json replaces invalid bytes with U+FFFD so
+// entry names are decoded and encoded here keeping them.
+
+// validPrefix returns the length of the valid UTF-8 at the start of data
+func validPrefix(data []byte) int {
+	n := 0
+	for n < len(data) {
+		r, size := utf8.DecodeRune(data[n:])
+		if r == utf8.RuneError && size == 1 {
+			break
+		}
+		n += size
+	}
+	return n
+}
+
+// unquoteRaw decodes the JSON string data like encoding/json but keeps
+// bytes which aren't valid UTF-8 as they are.
+func unquoteRaw(data []byte) (string, error) {
+	if len(data) < 2 || data[0] != '"' || data[len(data)-1] != '"' {
+		return "", fmt.Errorf("bad JSON string %q", data)
+	}
+	data = data[1 : len(data)-1]
+	var b strings.Builder
+	for len(data) > 0 {
+		// escapes are ASCII so each valid run can be decoded alone
+		n := validPrefix(data)
+		if n == 0 {
+			b.WriteByte(data[0])
+			data = data[1:]
+			continue
+		}
+		quoted := make([]byte, 0, n+2)
+		quoted = append(append(append(quoted, '"'), data[:n]...), '"')
+		var s string
+		if err := json.Unmarshal(quoted, &s); err != nil {
+			return "", err
+		}
+		b.WriteString(s)
+		data = data[n:]
+	}
+	return b.String(), nil
+}
+
+// quoteRaw encodes s as a JSON string like encoding/json but keeps
+// bytes which aren't valid UTF-8 as they are.
+func quoteRaw(s string) json.RawMessage {
+	out := []byte{'"'}
+	data := []byte(s)
+	for len(data) > 0 {
+		n := validPrefix(data)
+		if n == 0 {
+			out = append(out, data[0])
+			data = data[1:]
+			continue
+		}
+		quoted, _ := json.Marshal(string(data[:n]))
+		out = append(out, quoted[1:len(quoted)-1]...)
+		data = data[n:]
+	}
+	return append(out, '"')
+}
+
+// UnmarshalJSON decodes the entry keeping the bytes of its name
+func (e *Entry) UnmarshalJSON(data []byte) error {
+	type entry Entry
+	if err := json.Unmarshal(data, (*entry)(e)); err != nil {
+		return err
+	}
+	if utf8.Valid(data) {
+		return nil
+	}
+	var raw struct {
+		Name json.RawMessage `json:"name"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil || raw.Name == nil {
+		return err
+	}
+	name, err := unquoteRaw(raw.Name)
+	if err != nil {
+		return err
+	}
+	e.Name = name
+	return nil
+}
+
+// MarshalJSON encodes the entry keeping the bytes of its name
+func (e Entry) MarshalJSON() ([]byte, error) {
+	type entry Entry
+	if utf8.ValidString(e.Name) {
+		return json.Marshal(entry(e))
+	}
+	return json.Marshal(struct {
+		Name json.RawMessage `json:"name"`
+		entry
+	}{Name: quoteRaw(e.Name), entry: entry(e)})
+}
+
+// decodeName converts a name which isn't valid UTF-8 as set by
+// invalid_utf8, returning other names unchanged.
+func (f *Fs) decodeName(raw string) string {
+	if utf8.ValidString(raw) {
+		return raw
+	}
+	switch f.opt.InvalidUTF8 {
+	case "latin1":
+		runes := make([]rune, len(raw))
+		for i := 0; i < len(raw); i++ {
+			runes[i] = rune(raw[i])
+		}
+		return string(runes)
+	case "percent":
+		var b strings.Builder
+		for i := 0; i < len(raw); {
+			r, size := utf8.DecodeRuneInString(raw[i:])
+			if r == utf8.RuneError && size == 1 {
+				fmt.Fprintf(&b, "%%%02X", raw[i])
+			} else {
+				b.WriteString(raw[i : i+size])
+			}
+			i += size
+		}
+		return b.String()
+	case "replace":
+		return strings.ToValidUTF8(raw, string(utf8.RuneError))
+	}
+	return raw
+}
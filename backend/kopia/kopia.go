@@ -2,16 +2,22 @@ package kopia
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
 	"github.com/rclone/rclone/fs/config/configmap"
 	"github.com/rclone/rclone/fs/config/configstruct"
 	"github.com/rclone/rclone/fs/fserrors"
 	"github.com/rclone/rclone/fs/fshttp"
 	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/lib/encoder"
+	"github.com/rclone/rclone/lib/kv"
 	"github.com/rclone/rclone/lib/pacer"
 	"github.com/rclone/rclone/lib/rest"
+	"golang.org/x/text/unicode/norm"
+	gohash "hash"
 	"io"
 	"net/http"
 	"net/url"
@@ -22,12 +28,66 @@ import (
 	"time"
 )
 
+const linkSuffix = ".rclonelink" // The suffix added to a translated symbolic link
+
 // Register with Fs
 func init() {
 	fs.Register(&fs.RegInfo{
 		Name:        "kopia",
 		Description: "kopia",
 		NewFs:       NewFs,
+		CommandHelp: commandHelp,
+		MetadataInfo: &fs.MetadataInfo{
+			System: systemMetadataInfo,
+			Help: `The POSIX permissions and ownership recorded in the snapshot are
+returned as metadata, so they can be restored with --metadata to
+backends which support them, such as local.
+
+If the snapshot records the owner and group names as well as the
+numeric IDs they are returned as "owner" and "group" so restores to
+systems with different ID mappings can remap them, for example with
+--metadata-mapper.
+
+Access, change and creation times are returned as "atime", "ctime"
+and "btime" where the snapshot records them, so --metadata restores
+can preserve creation times on destinations which support them.
+
+Directories also return the summary kopia records for their tree as
+"tree-size", "tree-files", "tree-dirs", "tree-failed" and
+"tree-mtime", so tools can report tree sizes without walking them,
+for example with "rclone lsjson -R --dirs-only --metadata". "rclone
+size" uses them too, so it only needs a single call unless filters or
+--max-depth are given.
+
+For snapshots taken on Windows the file attributes and the names of
+any alternate data streams are returned as "attributes" and "streams"
+where the snapshot records them, so audits and restores back to NTFS
+can see them.
+
+Extended attributes recorded in the snapshot are returned too. Those
+in the user namespace are returned without the "user." prefix, the
+same as the local backend, so they are restored as extended attributes
+by "rclone copy --metadata" to local disk. Others, such as SELinux
+contexts, are returned as "xattr-" followed by their full name.
+
+POSIX ACLs and Windows security descriptors recorded in the snapshot
+are returned base64 encoded as "acl" and "security-descriptor". Most
+destinations can't apply them but they can be archived or copied
+between kopia remotes.
+
+Every entry also has "kopia-object-id" and "kopia-snapshot-id" which
+identify where it came from in the repository.
+
+The key names are stable so scripts run with --metadata-mapper can rely
+on them to transform the metadata during transfers.
+
+The holes in sparse files are returned as "holes" where the snapshot
+records them. Use the "sparse" backend command to restore such a file
+to local disk without filling in the holes.
+
+Metadata is read only and is supported on files and directories.
+`,
+		},
 		Options: []fs.Option{{
 			Name:     "url",
 			Help:     "URL of kopia host to connect to.",
@@ -58,31 +118,504 @@ func init() {
 				Value: "kd23e26ad7ae4434e1f9eebbd39603a28",
 			}},
 			Sensitive: true,
+		}, {
+			Name: "dir_cache_time",
+			Help: `How long to cache the snapshot list and directory listings for.
+
+When this expires the snapshot list and any directory listings are
+revalidated with the server. If the server supplied an ETag the
+revalidation is a conditional request, so unchanged data costs a
+cheap 304 Not Modified response.
+
+Set to 0 to cache for the lifetime of the remote.`,
+			Default:  fs.Duration(5 * time.Minute),
+			Advanced: true,
+		}, {
+			Name: "request_timeout",
+			Help: `Timeout for individual API calls.
+
+This applies to each listing and snapshot request, and to waiting for
+the response headers when opening an object. A call which times out
+is retried. It doesn't limit how long a download takes once it has
+started, which is governed by the global --timeout.
+
+Set to 0 to disable.`,
+			Default:  fs.Duration(0),
+			Advanced: true,
+		}, {
+			Name: "resolve_retries",
+			Help: `Number of times to look for the snapshot again if none matches.
+
+If no snapshot matches the snapshot option when the remote is first
+used the snapshot list is read again this many times before giving up
+with an error. This helps when rclone is started while the snapshot is
+still being made.
+
+Nothing is remembered if the snapshot isn't found, so the next use of
+the remote looks for it again.`,
+			Default:  0,
+			Advanced: true,
+		}, {
+			Name: "resolve_wait",
+			Help: `Time to wait before looking for the snapshot again.
+
+The wait is doubled after each attempt. See resolve_retries.`,
+			Default:  fs.Duration(time.Second),
+			Advanced: true,
+		}, {
+			Name: "maintenance_wait",
+			Help: `How long to wait for the server to come out of maintenance.
+
+While the server runs maintenance or is upgraded it answers with 503
+Service Unavailable. Calls which get this are made again after
+increasing waits, or the wait the server asks for, until this much
+time has passed, then fail saying the server is in maintenance.
+
+Set to 0 to fail straight away.`,
+			Default:  fs.Duration(5 * time.Minute),
+			Advanced: true,
+		}, {
+			Name: "mount_reads",
+			Help: `Read file contents through the server's WebDAV mount of the snapshot.
+
+If this is set the snapshot is mounted on the server and, if the
+server serves the mount over WebDAV, file contents are read from there
+while listings still use the API. This is faster for large sequential
+reads. The mount usually only listens on the server host so this is
+most useful when rclone runs there too.
+
+Files are read through the API if the mount isn't served over HTTP or
+can't be reached, and when the path in the snapshot isn't known, such
+as with snapshot_dirs, a layout, symlink translation or uncommitted
+changes. The mount is left in place on the server for later reads.`,
+			Default:  false,
+			Advanced: true,
+		}, {
+			Name: "hashes",
+			Help: `Comma separated list of checksum types to compute, e.g. md5,sha1,sha256.
+
+Kopia doesn't expose standard checksums so by default none are
+supported and "rclone check" needs --download. If this is set then
+rclone computes these checksums while downloading objects, and on
+demand by downloading the object, caching the results by object ID so
+repeated checks are free.
+
+Add "crc32c" for a cheap checksum which can be compared with object
+stores which natively support CRC-32C without cryptographic hashing.
+`,
+			Default:  fs.CommaSepList{},
+			Advanced: true,
+		}, {
+			Name: "hash_max_size",
+			Help: `Largest object to download just to compute its checksums.
+
+Objects bigger than this report an empty checksum unless it was
+computed while they were downloaded, so asking for a checksum never
+downloads more than this. Set to off for no limit.`,
+			Default:  fs.SizeSuffix(256 * fs.Mebi),
+			Advanced: true,
+		}, {
+			Name: "verify_sizes",
+			Help: `Check downloads return the size the snapshot says they should.
+
+If set, reading an object returns an error if the number of bytes
+received doesn't match the size recorded in the snapshot, rather than
+silently succeeding with a truncated file. This catches responses cut
+short by flaky proxies.`,
+			Default:  false,
+			Advanced: true,
+		}, {
+			Name: "verify_content",
+			Help: `Check downloads against the repository's own content hash.
+
+Kopia names each content after a keyed hash of its data, using the
+hash algorithm and secret of the repository. If this is set then when
+an object stored as a single content is read in full its data is
+hashed and an error returned if it doesn't match the object ID, so
+the data is verified end to end against kopia's metadata.
+
+Large objects are split into several contents and aren't checked.
+This needs the server to supply the repository parameters.`,
+			Default:  false,
+			Advanced: true,
+		}, {
+			Name: "hash_cache",
+			Help: `Persist computed checksums in a database in the cache directory.
+
+Kopia objects never change so checksums computed for them stay valid
+forever. If this is set they are stored on disk keyed by object ID so
+they survive restarts and repeated "rclone check" runs don't need to
+download anything.`,
+			Default:  false,
+			Advanced: true,
+		}, {
+			Name: "read_write",
+			Help: `Allow changes to be written as new snapshots.
+
+Normally the remote is read only and anything which would change it
+fails straight away with an error saying so, stopping a sync before it
+starts.
+
+If this is set, changes are staged against the latest snapshot of the
+source - new and changed files are uploaded with the server's content
+API and the directories containing them are rebuilt reusing the object
+IDs of anything unchanged - and then committed as a brand new snapshot.
+If the source has no snapshots yet it starts out empty and the first
+commit creates it, so a new backup target can be made with "rclone
+sync".
+
+The changes are shown in listings straight away but are only committed
+when rclone finishes, so a sync makes a single snapshot. With --dry-run
+the changes are logged but no content is uploaded and no snapshot is
+made.
+
+This needs the server to supply the repository parameters so rclone
+can compute content IDs, and can't be used with a fixed snapshot.`,
+			Default:  false,
+			Advanced: true,
+		}, {
+			Name: "snapshot_description",
+			Help: `Description of the snapshots made in read_write mode.
+
+This is shown in kopia's snapshot list and UI.`,
+			Default:  "",
+			Advanced: true,
+		}, {
+			Name: "snapshot_tags",
+			Help: `Tags for the snapshots made in read_write mode.
+
+A comma separated list of key=value pairs, eg "source=rclone,job=nightly".
+They are stored as kopia snapshot tags so the snapshots rclone makes
+can be found with "kopia snapshot list --tags".`,
+			Default:  fs.CommaSepList{},
+			Advanced: true,
+		}, {
+			Name: "compression",
+			Help: `Compression for the data uploaded in read_write mode.
+
+If this is empty the compression policy of the source is read from the
+server and followed, including its minimum and maximum sizes and the
+file extensions it always or never compresses, so files rclone writes
+are stored the same way as those from "kopia snapshot create".
+
+Set it to a kopia compressor name, eg "zstd" or "s2-default", to
+compress everything with that, or to "none" to compress nothing.
+
+Compression is done by the server and is only used if the repository
+supports it.`,
+			Default:  "",
+			Advanced: true,
+		}, {
+			Name: "snapshot_dirs",
+			Help: `Show every snapshot of the source as a directory in the root.
+
+If this is set the root of the remote has a directory for each
+snapshot, named after its start time in UTC like "kopia mount" does,
+eg "20240829-120000", and the snapshot option is ignored. A snapshot
+ID or root object ID can be used in place of the directory name.
+
+This lets "rclone serve http" or "rclone serve webdav" expose the
+whole history of a source from one server, with the first path
+element of the URL choosing the snapshot.
+
+It can't be used with read_write.`,
+			Default:  false,
+			Advanced: true,
+		}, {
+			Name: "layout",
+			Help: `How to lay out the snapshots of the source in the root.
+
+With the time-machine layout every snapshot of the source is shown
+in a year/month/day/HHMMSS directory named after its start time in
+UTC, eg "2024/08/29/120000", so "rclone mount" can browse the history
+of the source like Time Machine. The year, month and day directories
+have the time of the newest snapshot in them. As with snapshot_dirs
+the snapshot option is ignored and a snapshot ID or root object ID
+can be used in place of the path of its directory.
+
+It can't be used with read_write or snapshot_dirs.`,
+			Default: "",
+			Examples: []fs.OptionExample{{
+				Value: "",
+				Help:  "Show the snapshot chosen with the snapshot option",
+			}, {
+				Value: layoutTimeMachine,
+				Help:  "Show every snapshot as year/month/day/HHMMSS",
+			}},
+			Advanced: true,
+		}, {
+			Name: "follow_symlinks",
+			Help: `Follow symlinks to their targets within the snapshot.
+
+If this is set symlinks are shown as the file or directory they point
+to. This makes restores of trees relying on internal symlinks usable
+on platforms without symlink support. Absolute targets are only
+followed if they are inside the snapshot source path. Links which
+can't be resolved, point outside the snapshot or loop are skipped
+with an error.`,
+			Default:  false,
+			Advanced: true,
+		}, {
+			Name:     "links",
+			Help:     "Translate symlinks to regular files with a '" + linkSuffix + "' extension.",
+			Default:  false,
+			NoPrefix: true,
+			ShortOpt: "l",
+			Advanced: true,
+		}, {
+			Name: "sniff_mime_type",
+			Help: `Detect the MIME type of files from their content.
+
+The MIME type is normally worked out from the file extension. If this
+is set then files whose extension doesn't give a MIME type have the
+first 512 bytes read to detect it, which gives better Content-Type
+headers when serving a snapshot with "rclone serve http" or "webdav"
+at the cost of an extra request per file.`,
+			Default:  false,
+			Advanced: true,
+		}, {
+			Name: "hardlinks",
+			Help: `Detect hardlinks from files sharing an object ID.
+
+If this is set, files in the snapshot with the same object ID are
+returned with "nlink" and "link-group" metadata. Working this out
+needs the whole snapshot to be listed the first time it is used.
+
+Kopia deduplicates content so files with identical content can't be
+told apart from hardlinks and are grouped together too.
+
+The "hardlinks" backend command uses the groups to recreate the
+hardlinks in a restore to local disk.`,
+			Default:  false,
+			Advanced: true,
+		}, {
+			Name: "show_special",
+			Help: `Show special files recorded in the snapshot.
+
+Device nodes, sockets and FIFOs in the snapshot are normally skipped.
+If this is set they are listed as zero-byte objects with their kopia
+entry type in the "type" metadata, so audits of a snapshot see the
+full tree.`,
+			Default:  false,
+			Advanced: true,
+		}, {
+			Name: "error_on_failed_entries",
+			Help: `Fail listing directories with entries which failed to back up.
+
+Kopia records entries which couldn't be read when the snapshot was
+taken. Listing a directory containing any logs a warning with the
+failures so it is clear the backup itself was incomplete. If this is
+set listing the directory returns an error instead.`,
+			Default:  false,
+			Advanced: true,
+		}, {
+			Name: "restore_perms",
+			Help: `Only return the metadata needed to restore files faithfully.
+
+Normally all the metadata recorded in the snapshot is returned. When
+copying with --metadata to the local backend any keys it doesn't know
+about are written as extended attributes. If this is set only the
+mode, uid, gid, atime, mtime and btime are returned, so
+
+    rclone copy --metadata kopia:path /restore
+
+reproduces the permissions, ownership and times of the original tree
+without adding extended attributes. Ownership can only be restored
+when running with enough privilege to change it.`,
+			Default:  false,
+			Advanced: true,
+		}, {
+			Name: "case_insensitive",
+			Help: `Match file names case insensitively.
+
+Set this when using snapshots taken on Windows or macOS where the
+original paths were case insensitive, so looking up a path matches
+the entry in the snapshot whatever its case.`,
+			Default:  false,
+			Advanced: true,
+		}, {
+			Name: "unicode_normalization",
+			Help: `Unicode normalization form to use for file names.
+
+Snapshots taken on macOS may store names in decomposed form (NFD)
+while names typed elsewhere are usually composed (NFC), so a file can
+be shown in a listing but not found by name. If this is set names are
+normalized to this form when listing and looking up paths.`,
+			Default: "",
+			Examples: []fs.OptionExample{{
+				Value: "",
+				Help:  "Don't normalize names",
+			}, {
+				Value: "NFC",
+				Help:  "Normalize names to NFC",
+			}, {
+				Value: "NFD",
+				Help:  "Normalize names to NFD",
+			}},
+			Advanced: true,
+		}, {
+			Name: "invalid_utf8",
+			Help: `How to show file names which aren't valid UTF-8.
+
+Snapshots of older systems can contain names in other character sets
+such as Latin-1. By default the bytes which aren't valid UTF-8 are
+escaped by the encoding, which rclone can reverse but other programs
+won't understand. These names are converted as set here before the
+encoding is applied. Entries are still read and written under their
+original names in the snapshot.`,
+			Default: "encode",
+			Examples: []fs.OptionExample{{
+				Value: "encode",
+				Help:  "Leave it to the encoding option",
+			}, {
+				Value: "latin1",
+				Help:  "Decode the whole name as Latin-1 (ISO-8859-1)",
+			}, {
+				Value: "percent",
+				Help:  "Replace each invalid byte with %XX",
+			}, {
+				Value: "replace",
+				Help:  "Replace invalid bytes with the U+FFFD replacement character",
+			}},
+			Advanced: true,
+		}, {
+			Name: "duplicate_names",
+			Help: `What to do with entries in a directory which have the same name.
+
+A snapshot can contain two entries with the same name once the names
+are encoded or normalized, or differing only in case with
+case_insensitive set, if they were distinct when backed up. Listings
+show all of them, so "rclone dedupe list" reports them, but only one
+can be read by name or synced.`,
+			Default: "first",
+			Examples: []fs.OptionExample{{
+				Value: "first",
+				Help:  "Use the first entry in the snapshot",
+			}, {
+				Value: "newest",
+				Help:  "Use the entry with the newest modification time",
+			}, {
+				Value: "error",
+				Help:  "Fail to list or read the directory",
+			}},
+			Advanced: true,
+		}, {
+			Name: "modtime_window",
+			Help: `Treat modification times this close together as the same.
+
+Snapshots record the modification times read on the machine which was
+backed up, so if its clock was out or it stores times less precisely
+than the other remote, sync and check see unchanged files as modified
+and copy them again. Set this to the largest difference to ignore, for
+example 2s for FAT file systems.
+
+This is used as the precision of the remote, so rclone uses the larger
+of this and the precision of the other remote.`,
+			Default:  fs.Duration(0),
+			Advanced: true,
+		}, {
+			Name:     config.ConfigEncoding,
+			Help:     config.ConfigEncodingHelp,
+			Advanced: true,
+			// Snapshot file names may be any bytes other than /
+			// recorded on the source OS, so encode those which
+			// rclone can't represent.
+			Default: (encoder.Base |
+				encoder.EncodeInvalidUtf8),
 		}},
 	})
 }
 
 // Options defines the configuration for this backend
 type Options struct {
-	URL      string `config:"url"`
-	User     string `config:"user"`
-	Host     string `config:"host"`
-	Path     string `config:"path"`
-	Snapshot string `config:"snapshot"`
+	URL             string               `config:"url"`
+	User            string               `config:"user"`
+	Host            string               `config:"host"`
+	Path            string               `config:"path"`
+	Snapshot        string               `config:"snapshot"`
+	DirCacheTime    fs.Duration          `config:"dir_cache_time"`
+	RequestTimeout  fs.Duration          `config:"request_timeout"`
+	ResolveRetries  int                  `config:"resolve_retries"`
+	ResolveWait     fs.Duration          `config:"resolve_wait"`
+	MaintenanceWait fs.Duration          `config:"maintenance_wait"`
+	MountReads      bool                 `config:"mount_reads"`
+	Hashes          fs.CommaSepList      `config:"hashes"`
+	HashMaxSize     fs.SizeSuffix        `config:"hash_max_size"`
+	VerifySizes     bool                 `config:"verify_sizes"`
+	VerifyContent   bool                 `config:"verify_content"`
+	HashCache       bool                 `config:"hash_cache"`
+	ReadWrite       bool                 `config:"read_write"`
+	Description     string               `config:"snapshot_description"`
+	Tags            fs.CommaSepList      `config:"snapshot_tags"`
+	Compression     string               `config:"compression"`
+	SnapshotDirs    bool                 `config:"snapshot_dirs"`
+	Layout          string               `config:"layout"`
+	FollowSymlinks  bool                 `config:"follow_symlinks"`
+	TranslateLinks  bool                 `config:"links"`
+	SniffMimeType   bool                 `config:"sniff_mime_type"`
+	Hardlinks       bool                 `config:"hardlinks"`
+	ShowSpecial     bool                 `config:"show_special"`
+	ErrorOnFailed   bool                 `config:"error_on_failed_entries"`
+	RestorePerms    bool                 `config:"restore_perms"`
+	CaseInsensitive bool                 `config:"case_insensitive"`
+	Normalization   string               `config:"unicode_normalization"`
+	InvalidUTF8     string               `config:"invalid_utf8"`
+	DuplicateNames  string               `config:"duplicate_names"`
+	ModTimeWindow   fs.Duration          `config:"modtime_window"`
+	Enc             encoder.MultiEncoder `config:"encoding"`
 }
 
 // Fs represents a remote seafile
 type Fs struct {
-	name     string
-	root     string
-	opt      Options
-	features *fs.Features
-	srv      *rest.Client
-	pacer    *fs.Pacer
-	initOnce sync.Once
-	rootId   string
+	name       string
+	root       string
+	opt        Options
+	features   *fs.Features
+	client     *http.Client // the http client srv uses
+	srv        *rest.Client
+	pacer      *fs.Pacer
+	rootMu     sync.Mutex // held while looking for the snapshot to use, protects rootId, snapshotId, rootEtag, rootFetched and newSource
+	rootId     string
+	snapshotId string // ID of the snapshot rootId came from
+
+	rootEtag    string    // ETag of the snapshot list rootId was chosen from
+	rootFetched time.Time // when the snapshot list was last validated
+	rootListing *dirListing
+	rootFile    string // set to the leaf name if the root pointed to a file
+	newSource   bool   // set in write mode if the source had no snapshots
+
+	listMu sync.Mutex // protects rootListing, the two below and the listings cached in a Directory
+
+	snapshotDirNames    map[string]string      // snapshot and root IDs to directory paths with snapshot_dirs or a layout
+	snapshotDirListings map[string]*dirListing // listings of the directories leading to the snapshots
+
+	hashes    hash.Set          // checksums computed by rclone
+	hashCache *hashCache        // checksums computed so far
+	commitGen int               // last commit to the source seen
+	tags      map[string]string // tags for the snapshots made
+
+	repoHashMu sync.Mutex         // protects repoHash
+	repoHash   func() gohash.Hash // repository hash for content IDs, if known
+
+	serverStatus *RepoStatus // repository status read by NewFs, nil if the server didn't answer
+
+	canCompress bool               // the repository supports content compression
+	policyMu    sync.Mutex         // protects policy
+	policy      *CompressionPolicy // compression policy of the source, once read
+
+	mountMu sync.Mutex        // protects mounts
+	mounts  map[string]string // root object ID to the WebDAV URL of its mount or ""
 
-	rootEntries *fs.DirEntries
+	stageMu sync.Mutex // protects staged
+	staged  *staging   // changes for the next snapshot in write mode
+
+	notifyMu    sync.Mutex                         // protects the following
+	notifyID    int                                // ID of the last function registered
+	notifyFuncs map[int]func(string, fs.EntryType) // functions registered with ChangeNotify
+
+	linkMu     sync.Mutex          // protects the following
+	linkRootID string              // snapshot root linkGroups was made from
+	linkGroups map[string][]string // object ID to remotes sharing it
 }
 
 // NewFs creates a new Fs object from the name and root. It connects to
@@ -93,87 +626,454 @@ func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, e
 	if err != nil {
 		return nil, err
 	}
+	switch opt.Normalization {
+	case "", "NFC", "NFD":
+	default:
+		return nil, fmt.Errorf("kopia: unknown unicode_normalization %q - must be NFC or NFD", opt.Normalization)
+	}
+	switch opt.InvalidUTF8 {
+	case "", "encode", "latin1", "percent", "replace":
+	default:
+		return nil, fmt.Errorf("kopia: unknown invalid_utf8 %q - must be encode, latin1, percent or replace", opt.InvalidUTF8)
+	}
+	switch opt.DuplicateNames {
+	case "", "first", "newest", "error":
+	default:
+		return nil, fmt.Errorf("kopia: unknown duplicate_names %q - must be first, newest or error", opt.DuplicateNames)
+	}
+	if opt.FollowSymlinks && opt.TranslateLinks {
+		return nil, errors.New("kopia: can't use -l/--links with --kopia-follow-symlinks")
+	}
+	if opt.ReadWrite && opt.Snapshot != "" && opt.Snapshot != "latest" {
+		return nil, errors.New("kopia: can't use read_write with a fixed snapshot")
+	}
+	if opt.ReadWrite && opt.SnapshotDirs {
+		return nil, errors.New("kopia: can't use read_write with snapshot_dirs")
+	}
+	switch opt.Layout {
+	case "":
+	case layoutTimeMachine:
+		if opt.ReadWrite || opt.SnapshotDirs {
+			return nil, fmt.Errorf("kopia: can't use the %s layout with read_write or snapshot_dirs", opt.Layout)
+		}
+	default:
+		return nil, fmt.Errorf("kopia: unknown layout %q - must be %s", opt.Layout, layoutTimeMachine)
+	}
+	hashes, err := parseHashes(opt.Hashes)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := parseTags(opt.Tags)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCompressor(opt.Compression); err != nil {
+		return nil, err
+	}
+	if opt.ResolveRetries < 0 {
+		return nil, errors.New("kopia: resolve_retries must not be negative")
+	}
+	if opt.ModTimeWindow < 0 {
+		return nil, errors.New("kopia: modtime_window must not be negative")
+	}
 	root = cleanPath(root)
+	client := fshttp.NewClient(ctx)
+	client.CheckRedirect = checkRedirect
 	f := &Fs{
-		name:     name,
-		root:     root,
-		opt:      *opt,
-		features: &fs.Features{},
-		srv:      rest.NewClient(fshttp.NewClient(ctx)).SetRoot(strings.TrimRight(opt.URL, "/")),
-		pacer:    fs.NewPacer(ctx, pacer.NewDefault(pacer.MinSleep(10*time.Millisecond), pacer.MaxSleep(3200*time.Millisecond), pacer.DecayConstant(2))),
+		name:   name,
+		root:   root,
+		opt:    *opt,
+		client: client,
+		srv:    rest.NewClient(client).SetRoot(strings.TrimRight(opt.URL, "/")).SetErrorHandler(errorHandler),
+		pacer:  fs.NewPacer(ctx, pacer.NewDefault(pacer.MinSleep(10*time.Millisecond), pacer.MaxSleep(3200*time.Millisecond), pacer.DecayConstant(2))),
+		hashes: hashes,
+		tags:   tags,
+	}
+	f.features = (&fs.Features{
+		// checksums need the object to be downloaded
+		SlowHash:                true,
+		DuplicateFiles:          true, // snapshots can contain entries with the same name
+		ReadMetadata:            true,
+		ReadDirMetadata:         true,
+		ReadMimeType:            true,
+		CanHaveEmptyDirectories: true,
+		CaseInsensitive:         opt.CaseInsensitive,
+		// Copy and Move check the remotes share the repository or
+		// source themselves
+		ServerSideAcrossConfigs: opt.ReadWrite,
+		// so sync fails straight away unless read_write is set
+		ReadOnly: !opt.ReadWrite,
+	}).Fill(ctx, f)
+	if !opt.ReadWrite {
+		// so rclone doesn't try server-side operations
+		f.features.Copy = nil
+		f.features.Move = nil
+		f.features.DirMove = nil
+		f.features.PutStream = nil
+		f.features.Purge = nil
+		f.features.MergeDirs = nil
+	}
+	if err := f.probeServer(ctx); err != nil {
+		return nil, err
+	}
+	var db *kv.DB
+	if f.opt.HashCache && f.hashes.Count() > 0 {
+		db, err = kv.Start(ctx, "kopia", f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start hash cache: %w", err)
+		}
+	}
+	f.hashCache = newHashCache(db)
+	if f.opt.VerifyContent || f.opt.ReadWrite {
+		if err := f.setupRepoHash(ctx); err != nil {
+			return nil, err
+		}
 	}
 	if root != "" {
+		// use the directory name if the root starts with a snapshot ID
+		root = f.snapshotDirPath(ctx, root)
+		f.root = root
 		obj, err := f.newObject(ctx, root)
+		if errors.Is(err, fs.ErrorObjectNotFound) || errors.Is(err, fs.ErrorDirNotFound) || errors.Is(err, fs.ErrorIsFile) {
+			// listing a missing root returns fs.ErrorDirNotFound and in
+			// read_write mode it may be created by writing to it
+			return f, nil
+		}
 		if err != nil {
 			return nil, err
 		}
-		if _, ok := obj.(*Object); ok {
-			dir, _ := path.Split(root)
+		if o, ok := obj.(*Object); ok {
+			dir := path.Dir(root)
 			if dir == "." || dir == "/" {
 				dir = ""
 			}
 			f.root = dir
+			// use the name in the snapshot as root may differ in case
+			// or normalization
+			f.rootFile = o.Name()
 			return f, fs.ErrorIsFile
 		}
 	}
 	return f, nil
 }
 
-func (f *Fs) getRootId(ctx context.Context) (string, error) {
-	f.initOnce.Do(func() {
-		result := SnapshotResponse{}
-		var resp *http.Response
-		var err error
-		err = f.pacer.Call(func() (bool, error) {
-			resp, err = f.srv.CallJSON(ctx, &rest.Opts{
-				Method: "GET",
-				Path:   "/api/v1/snapshots",
-				Parameters: url.Values{
-					"userName": []string{f.opt.User},
-					"host":     []string{f.opt.Host},
-					"path":     []string{f.opt.Path},
-				},
-			}, nil, &result)
-			return f.shouldRetry(ctx, resp, err)
-		})
-		if err != nil {
-			return
+// fetchSnapshots reads the snapshot list for the configured source.
+//
+// If etag is set it is sent as If-None-Match and notModified is
+// returned if the server says the list hasn't changed.
+func (f *Fs) fetchSnapshots(ctx context.Context, etag string) (result *SnapshotResponse, newEtag string, notModified bool, err error) {
+	result = &SnapshotResponse{}
+	opts := rest.Opts{
+		Method: "GET",
+		Path:   "/api/v1/snapshots",
+		Parameters: url.Values{
+			"userName": []string{f.opt.User},
+			"host":     []string{f.opt.Host},
+			"path":     []string{f.opt.Path},
+		},
+	}
+	if etag != "" {
+		opts.ExtraHeaders = map[string]string{"If-None-Match": etag}
+	}
+	var resp *http.Response
+	err = f.call(ctx, func() (bool, error) {
+		reqCtx, cancel := f.requestContext(ctx)
+		defer cancel()
+		resp, err = f.srv.CallJSON(reqCtx, &opts, nil, result)
+		return f.shouldRetry(ctx, resp, err)
+	})
+	if isNotModified(resp) {
+		return nil, etag, true, nil
+	}
+	if err != nil {
+		return nil, "", false, err
+	}
+	return result, resp.Header.Get("ETag"), false, nil
+}
+
+// selectSnapshot picks the snapshot matching the snapshot option from
+// result, returning nil if there isn't one.
+func (f *Fs) selectSnapshot(result *SnapshotResponse) *Snapshot {
+	for i := len(result.Snapshots) - 1; i >= 0; i-- {
+		snapshot := &result.Snapshots[i]
+		if f.opt.Snapshot == snapshot.RootID {
+			return snapshot
 		}
-		for i := len(result.Snapshots) - 1; i >= 0; i-- {
-			snapshot := result.Snapshots[i]
-			if f.opt.Snapshot == snapshot.RootID {
-				f.rootId = snapshot.RootID
-				break
-			}
-			if !slices.Contains(snapshot.Retention, "incomplete") {
-				if (f.opt.Snapshot == "pin" && len(snapshot.Pins) > 0) ||
-					(f.opt.Snapshot == "" || f.opt.Snapshot == "latest") {
-					f.rootId = snapshot.RootID
-					break
-				}
+		if !slices.Contains(snapshot.Retention, "incomplete") {
+			if (f.opt.Snapshot == "pin" && len(snapshot.Pins) > 0) ||
+				(f.opt.Snapshot == "" || f.opt.Snapshot == "latest") {
+				return snapshot
 			}
 		}
-		if f.rootId == "" {
-			fs.Errorf(nil, "kopia snapshot: %s not found", f.opt.Snapshot)
-			go func() {
-				time.Sleep(3 * time.Second)
-				f.initOnce = sync.Once{}
-			}()
-			return
+	}
+	return nil
+}
+
+// errSnapshotNotFound is returned if no snapshot matches the snapshot option
+var errSnapshotNotFound = errors.New("snapshot not found")
+
+// resolveRoot finds the snapshot to use from the snapshot list if it
+// hasn't been found yet.
+//
+// If there isn't one the list is read again up to resolve_retries
+// times, waiting resolve_wait between attempts and doubling the wait
+// each time. Nothing is remembered if it fails so the next call looks
+// again.
+func (f *Fs) resolveRoot(ctx context.Context) error {
+	f.rootMu.Lock()
+	defer f.rootMu.Unlock()
+	if f.rootId != "" || f.newSource {
+		return nil
+	}
+	wait := time.Duration(f.opt.ResolveWait)
+	for try := 0; ; try++ {
+		result, etag, _, err := f.fetchSnapshots(ctx, "")
+		if err != nil {
+			return fmt.Errorf("failed to read the snapshot list: %w", err)
 		}
-		fs.Infof(nil, "kopia load snapshot: %s", f.rootId)
-	})
+		snapshot := f.selectSnapshot(result)
+		if snapshot == nil && f.opt.ReadWrite && len(result.Snapshots) == 0 {
+			// the first write creates the first snapshot
+			fs.Infof(f, "No snapshots of %v yet - the first will be made when written to", f.source())
+			f.newSource = true
+			f.rootEtag = etag
+			f.rootFetched = time.Now()
+			return nil
+		}
+		if snapshot != nil {
+			f.rootId, f.snapshotId = snapshot.RootID, snapshot.ID
+			f.rootEtag = etag
+			f.rootFetched = time.Now()
+			fs.Infof(nil, "kopia load snapshot: %s", f.rootId)
+			return nil
+		}
+		if try >= f.opt.ResolveRetries {
+			return fmt.Errorf("%w: no snapshot of %v matches %q after %d attempts", errSnapshotNotFound, f.source(), f.opt.Snapshot, try+1)
+		}
+		fs.Debugf(f, "Snapshot %q not found - looking again in %v (%d/%d)", f.opt.Snapshot, wait, try+1, f.opt.ResolveRetries)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		wait *= 2
+	}
+}
+
+func (f *Fs) getRootId(ctx context.Context) (string, error) {
+	if err := f.resolveRoot(ctx); err != nil {
+		return "", err
+	}
+	f.adoptCommit()
+	f.rootMu.Lock()
+	defer f.rootMu.Unlock()
+	if f.expired(f.rootFetched) {
+		f.revalidateRoot(ctx)
+	}
 	if f.rootId == "" {
-		return "", fmt.Errorf("%s not found", f.String())
+		return "", errNoSnapshots
 	}
 	return f.rootId, nil
 }
 
+// currentRoot returns the root being read, the snapshot it came from
+// and when the snapshot list was last validated
+func (f *Fs) currentRoot() (rootID, snapshotID string, fetched time.Time) {
+	f.rootMu.Lock()
+	defer f.rootMu.Unlock()
+	return f.rootId, f.snapshotId, f.rootFetched
+}
+
+// setRoot switches to reading rootID from snapshotID, returning true
+// if the root changed.
+//
+// etag is the ETag of the snapshot list the snapshot was chosen from,
+// or "" if it isn't known. An empty rootID starts a new source with no
+// snapshots.
+func (f *Fs) setRoot(rootID, snapshotID, etag string) (changed bool) {
+	f.rootMu.Lock()
+	defer f.rootMu.Unlock()
+	changed = rootID != f.rootId
+	f.rootId, f.snapshotId = rootID, snapshotID
+	f.rootEtag = etag
+	f.rootFetched = time.Now()
+	f.newSource = rootID == ""
+	return changed
+}
+
+// revalidateRoot checks the snapshot list is still current once the
+// cache time has expired, switching to a new root if it has changed.
+//
+// Errors are logged and the existing root is kept. It must be called
+// with rootMu held.
+func (f *Fs) revalidateRoot(ctx context.Context) {
+	result, etag, notModified, err := f.fetchSnapshots(ctx, f.rootEtag)
+	if err != nil {
+		fs.Debugf(f, "failed to revalidate snapshot list: %v", err)
+		return
+	}
+	f.rootFetched = time.Now()
+	if notModified {
+		return
+	}
+	f.rootEtag = etag
+	snapshot := f.selectSnapshot(result)
+	if snapshot == nil && f.rootId == "" {
+		// still no snapshots of a new source
+		return
+	}
+	if snapshot == nil {
+		fs.Errorf(f, "kopia snapshot: %s no longer found - keeping %s", f.opt.Snapshot, f.rootId)
+		return
+	}
+	if snapshot.RootID != f.rootId {
+		fs.Infof(nil, "kopia load snapshot: %s", snapshot.RootID)
+		f.rootId, f.snapshotId = snapshot.RootID, snapshot.ID
+		f.rootChanged()
+	}
+}
+
+// expired returns true if something validated at t needs revalidating
+func (f *Fs) expired(t time.Time) bool {
+	return f.opt.DirCacheTime > 0 && time.Since(t) > time.Duration(f.opt.DirCacheTime)
+}
+
+// isJSON returns true if resp has a JSON body
+func isJSON(resp *http.Response) bool {
+	return resp != nil && strings.Contains(resp.Header.Get("Content-Type"), "json")
+}
+
+// isNotModified returns true if resp is a 304 Not Modified response
+func isNotModified(resp *http.Response) bool {
+	return resp != nil && resp.StatusCode == http.StatusNotModified
+}
+
+// shouldRetry returns a boolean as to whether this resp and err
+// deserve to be retried. It returns the err as a convenience
 func (f *Fs) shouldRetry(ctx context.Context, resp *http.Response, err error) (bool, error) {
 	if fserrors.ContextError(ctx, &err) {
 		return false, err
 	}
-	return fserrors.ShouldRetry(err) || resp == nil || resp.StatusCode >= 500, err
+	var apiErr *ErrorResponse
+	if errors.As(err, &apiErr) && apiErr.Code == codeNotConnected {
+		// the server is still starting
+		return true, err
+	}
+	if isMaintenance(err) {
+		// waited for by f.call rather than the pacer
+		return false, err
+	}
+	return fserrors.ShouldRetry(err) || resp == nil || resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, err
+}
+
+// maxErrorBody is the most of an error body put in the error message
+const maxErrorBody = 512
+
+// errorHandler parses a non 2xx response from the server into an
+// error, wrapping the fs error matching the status where there is one
+// so callers can tell what went wrong.
+func errorHandler(resp *http.Response) error {
+	body, err := rest.ReadBody(resp)
+	if err != nil {
+		return fmt.Errorf("error reading error out of body: %w", err)
+	}
+	apiErr := &ErrorResponse{StatusCode: resp.StatusCode, RetryAfter: retryAfter(resp)}
+	if json.Unmarshal(body, apiErr) != nil || apiErr.Message == "" {
+		// not a kopia error so show the body as it is
+		apiErr.Code = ""
+		apiErr.Message = strings.TrimSpace(string(body))
+		if len(apiErr.Message) > maxErrorBody {
+			apiErr.Message = apiErr.Message[:maxErrorBody] + "..."
+		}
+	}
+	if apiErr.Code == codeNotConnected {
+		// the server is starting so this will go away
+		return apiErr
+	}
+	// errors which will happen again aren't worth retrying the sync for
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fserrors.NoRetryError(fmt.Errorf("%w - check the user and password: %w", fs.ErrorPermissionDenied, apiErr))
+	case http.StatusNotFound:
+		return fmt.Errorf("%w: %w", fs.ErrorObjectNotFound, apiErr)
+	case http.StatusBadRequest:
+		return fserrors.NoRetryError(fmt.Errorf("server rejected the request - check the url, user, host and path options: %w", apiErr))
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		// only seen if the redirect couldn't be followed
+		return fmt.Errorf("server redirected to %q - set the url option to the address redirected to: %w", resp.Header.Get("Location"), apiErr)
+	}
+	return apiErr
+}
+
+// maxRedirects is the most redirects followed for one request
+const maxRedirects = 10
+
+// checkRedirect is the CheckRedirect of the http client which follows
+// redirects from the server, such as a proxy adding a trailing slash
+// or enforcing a canonical host.
+//
+// Go re-issues a POST redirected with 301 or 302 as a GET with no
+// body, and drops credentials given in the url, so this puts back the
+// method, body and credentials of the original request. Credentials
+// are never sent to an http address redirected to from https.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+	orig := via[0]
+	hasAuth := orig.URL.User != nil || orig.Header.Get("Authorization") != ""
+	if hasAuth && orig.URL.Scheme == "https" && req.URL.Scheme != "https" {
+		return fmt.Errorf("refusing to send credentials to insecure redirect %q", req.URL.Redacted())
+	}
+	if req.Method != orig.Method {
+		req.Method = orig.Method
+	}
+	if req.Body == nil && orig.Body != nil && orig.Body != http.NoBody {
+		if orig.GetBody == nil {
+			return fmt.Errorf("can't resend the request body to redirect %q", req.URL.Redacted())
+		}
+		body, err := orig.GetBody()
+		if err != nil {
+			return err
+		}
+		req.Body = body
+		req.GetBody = orig.GetBody
+		req.ContentLength = orig.ContentLength
+	}
+	for k, vv := range orig.Header {
+		if _, ok := req.Header[k]; !ok {
+			req.Header[k] = vv
+		}
+	}
+	if req.URL.User == nil {
+		req.URL.User = orig.URL.User
+	}
+	fs.Debugf(nil, "kopia: following redirect from %q to %q", orig.URL.Redacted(), req.URL.Redacted())
+	return nil
+}
+
+// callJSON makes a JSON API call with retries
+func (f *Fs) callJSON(ctx context.Context, opts *rest.Opts, request interface{}, response interface{}) (err error) {
+	var resp *http.Response
+	err = f.call(ctx, func() (bool, error) {
+		reqCtx, cancel := f.requestContext(ctx)
+		defer cancel()
+		resp, err = f.srv.CallJSON(reqCtx, opts, request, response)
+		return f.shouldRetry(ctx, resp, err)
+	})
+	return decodeError(opts, err)
+}
+
+// requestContext returns a context for a single API call limited by
+// the request timeout, if set.
+//
+// This should only be used for calls which have read their whole
+// response before cancel is called.
+func (f *Fs) requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if f.opt.RequestTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, time.Duration(f.opt.RequestTimeout))
 }
 
 // Name of the remote (as passed into NewFs)
@@ -191,14 +1091,14 @@ func (f *Fs) String() string {
 	return fmt.Sprintf("kopia %s[%s@%s:%s/%s]", f.name, f.opt.User, f.opt.Host, f.opt.Path, f.root)
 }
 
-// Precision of the ModTimes in this Fs
+// Precision of the ModTimes in this Fs, widened to modtime_window if set
 func (f *Fs) Precision() time.Duration {
-	return time.Nanosecond
+	return max(time.Duration(f.opt.ModTimeWindow), time.Nanosecond)
 }
 
 // Hashes returns the supported hash sets.
 func (f *Fs) Hashes() hash.Set {
-	return hash.Set(hash.None)
+	return f.hashes
 }
 
 // Features returns the optional features of this Fs
@@ -213,6 +1113,26 @@ func cleanPath(p string) string {
 	return p
 }
 
+// relativePath returns remote relative to root, which must be remote
+// or one of its parents.
+//
+// The names in remote are those in the snapshot, which may differ from
+// those in root in case or normalization, so the directories of root
+// are removed by counting them rather than by comparing names.
+func relativePath(root, remote string) string {
+	if root == "" {
+		return remote
+	}
+	for i := strings.Count(root, "/"); i >= 0; i-- {
+		_, rest, found := strings.Cut(remote, "/")
+		if !found {
+			return ""
+		}
+		remote = rest
+	}
+	return remote
+}
+
 // List the objects and directories in dir into entries.  The
 // entries can be returned in any order but should be for a
 // complete directory.
@@ -223,13 +1143,36 @@ func cleanPath(p string) string {
 // This should return fs.ErrorDirNotFound if the directory isn't
 // found.
 func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
-	return f.list(ctx, path.Join(f.root, dir))
+	if dir == "" && f.rootFile != "" {
+		// the root pointed to a file so list just that
+		o, err := f.NewObject(ctx, f.rootFile)
+		if errors.Is(err, fs.ErrorObjectNotFound) || errors.Is(err, fs.ErrorIsDir) {
+			return fs.DirEntries{}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return fs.DirEntries{o}, nil
+	}
+	entries, err = f.list(ctx, path.Join(f.root, dir))
+	if errors.Is(err, fs.ErrorIsFile) {
+		return nil, fs.ErrorDirNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	// return a copy as callers may filter the entries in place
+	return slices.Clone(entries), nil
 }
 
 // NewObject finds the Object at remote.  If it can't be found
 // it returns the error fs.ErrorObjectNotFound.
 func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
 	obj, err := f.newObject(ctx, path.Join(f.root, remote))
+	if errors.Is(err, fs.ErrorDirNotFound) || errors.Is(err, fs.ErrorIsFile) {
+		// a parent directory is missing or is a file
+		return nil, fs.ErrorObjectNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -239,71 +1182,327 @@ func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
 	return obj.(fs.Object), nil
 }
 
-func (f *Fs) listObject(ctx context.Context, remote string, objId string) (dirEntries fs.DirEntries, err error) {
-	result := FileResponse{}
+// errMissingObject is returned if the server doesn't have an object
+// which a directory in the snapshot refers to
+var errMissingObject = errors.New("object missing from the repository")
+
+// errIncompleteListing is returned when a directory listing doesn't
+// add up to its summary
+var errIncompleteListing = errors.New("directory listing incomplete")
+
+// errFailedEntries is returned by listings of directories with entries
+// which failed to back up if error_on_failed_entries is set
+var errFailedEntries = errors.New("snapshot incomplete")
+
+// validateListing checks the entries in result are consistent with
+// the directory summary, which is taken from the listing itself if
+// present otherwise from want (which may be nil).
+//
+// This detects listings which have been cut short in transit, which
+// could otherwise cause sync to delete files at the destination.
+func validateListing(result *FileResponse, want *Summary) error {
+	if result.Stream != "" && result.Stream != "kopia:directory" {
+		return fmt.Errorf("unexpected stream type %q for a directory", result.Stream)
+	}
+	summary := result.Summary
+	if summary.Files == 0 && summary.Size == 0 && want != nil {
+		summary = *want
+	}
+	if summary.Files == 0 && summary.Size == 0 {
+		// no summary to check against
+		return nil
+	}
+	var files int
+	var size int64
+	for _, item := range result.Entries {
+		switch item.Type {
+		case "f", "":
+			files++
+			size += item.Size
+		case "d":
+			files += item.Summary.Files
+			size += item.Summary.Size
+		}
+	}
+	if files != summary.Files || size != summary.Size {
+		return fmt.Errorf("%w: found %d files totalling %d bytes but summary says %d files totalling %d bytes",
+			errIncompleteListing, files, size, summary.Files, summary.Size)
+	}
+	return nil
+}
+
+// checkFailed warns about entries of the directory listed in result
+// at remote which failed to back up, or returns an error if
+// error_on_failed_entries is set.
+//
+// The failure count in a summary includes the whole tree so only the
+// failures not accounted for by subdirectories are reported.
+func (f *Fs) checkFailed(remote string, result *FileResponse, want *Summary) error {
+	summary := &result.Summary
+	if summary.empty() && want != nil {
+		summary = want
+	}
+	failed := summary.NumFailed
+	for _, item := range result.Entries {
+		if item.Type == "d" {
+			failed -= item.Summary.NumFailed
+		}
+	}
+	if failed <= 0 {
+		return nil
+	}
+	if f.opt.ErrorOnFailed {
+		return fmt.Errorf("%w: %d entries in %q failed to back up", errFailedEntries, failed, remote)
+	}
+	fs.Logf(f, "Snapshot is incomplete: %d entries in %q failed to back up", failed, remote)
+	for _, failure := range summary.Errors {
+		fs.Logf(f, "Failed entry %q: %s", failure.Path, failure.Error)
+	}
+	return nil
+}
+
+// listObject reads the directory object objId which is at remote.
+//
+// If old is a previous listing of the same object carrying an ETag then
+// the request is made conditional and old is reused if unchanged.
+//
+// want is the summary of the directory from its parent if known.
+func (f *Fs) listObject(ctx context.Context, remote string, objId string, old *dirListing, want *Summary) (listing *dirListing, err error) {
+	var result FileResponse
+	opts := rest.Opts{
+		Method: "GET",
+		Path:   fmt.Sprintf("/api/v1/objects/%s", objId),
+	}
+	if old != nil && old.id == objId && old.etag != "" {
+		opts.ExtraHeaders = map[string]string{"If-None-Match": old.etag}
+	} else {
+		old = nil
+	}
 	var resp *http.Response
-	err = f.pacer.Call(func() (bool, error) {
-		resp, err = f.srv.CallJSON(ctx, &rest.Opts{
-			Method: "GET",
-			Path:   fmt.Sprintf("/api/v1/objects/%s", objId),
-		}, nil, &result)
-		return f.shouldRetry(ctx, resp, err)
+	err = f.retryRestart(ctx, func() error {
+		return f.call(ctx, func() (bool, error) {
+			reqCtx, cancel := f.requestContext(ctx)
+			defer cancel()
+			result = FileResponse{}
+			resp, err = f.srv.CallJSON(reqCtx, &opts, nil, &result)
+			if err == nil && isJSON(resp) {
+				if err = validateListing(&result, want); err != nil {
+					return true, err
+				}
+			}
+			return f.shouldRetry(ctx, resp, err)
+		})
 	})
+	if old != nil && isNotModified(resp) {
+		f.listMu.Lock()
+		old.fetched = time.Now()
+		f.listMu.Unlock()
+		return old, nil
+	}
+	var apiErr *ErrorResponse
+	if errors.Is(err, fs.ErrorObjectNotFound) && errors.As(err, &apiErr) {
+		// the directory exists as its parent lists it, so this
+		// mustn't look like a missing directory
+		return nil, fmt.Errorf("%w: directory object %s of %q: %w", errMissingObject, objId, remote, apiErr)
+	}
+	if err != nil && isJSON(resp) {
+		err = decodeError(&opts, err)
+	}
 	if err != nil {
 		return nil, err
 	}
-	if resp == nil || !strings.Contains(resp.Header.Get("Content-Type"), "json") {
+	if !isJSON(resp) {
 		return nil, fs.ErrorIsFile
 	}
-	for _, item := range result.Entries {
+	if err = f.checkFailed(remote, &result, want); err != nil {
+		return nil, err
+	}
+	listing = f.newDirListing(remote, objId, objId, result.Entries)
+	listing.etag = resp.Header.Get("ETag")
+	return listing, nil
+}
+
+// newDirListing makes a listing of the directory at remote from its
+// entries. objId is the object ID of the directory, which may be ""
+// if it isn't known, and parentID the ID to give the entries.
+func (f *Fs) newDirListing(remote, objId, parentID string, entries []Entry) (listing *dirListing) {
+	listing = &dirListing{
+		id:      objId,
+		fetched: time.Now(),
+	}
+	for i := range entries {
+		item := &entries[i]
+		name, err := f.entryName(item.Name)
+		if err != nil {
+			fs.Errorf(f, "Skipping entry in %q: %v", remote, err)
+			continue
+		}
 		var entry fs.DirEntry
-		if item.Type == "d" {
+		switch item.Type {
+		case "d":
 			entry = &Directory{
 				ObjectInfo: ObjectInfo{
-					fs:      f,
-					id:      item.Obj,
-					name:    item.Name,
-					remote:  path.Join(remote, item.Name),
-					modTime: item.MTime,
-					size:    item.Summary.Size,
+					fs:       f,
+					id:       item.Obj,
+					name:     name,
+					remote:   path.Join(remote, name),
+					modTime:  item.MTime,
+					size:     item.Summary.Size,
+					entry:    item,
+					parentID: parentID,
 				},
-				entries: nil,
+				summary: item.Summary,
 			}
-		} else {
+		case "s":
+			if f.opt.FollowSymlinks {
+				listing.links = append(listing.links, symlink{
+					name:   name,
+					remote: path.Join(remote, name),
+					id:     item.Obj,
+					parent: parentID,
+				})
+				continue
+			}
+			if !f.opt.TranslateLinks {
+				fs.Logf(f, "Skipping symlink %q: use -l/--links to translate it", path.Join(remote, name))
+				continue
+			}
+			// the content of a symlink object is the link target
 			entry = &Object{
 				ObjectInfo: ObjectInfo{
-					fs:      f,
-					id:      item.Obj,
-					name:    item.Name,
-					remote:  path.Join(remote, item.Name),
-					modTime: item.MTime,
-					size:    item.Size,
+					fs:       f,
+					id:       item.Obj,
+					name:     name + linkSuffix,
+					remote:   path.Join(remote, name+linkSuffix),
+					modTime:  item.MTime,
+					size:     item.Size,
+					entry:    item,
+					parentID: parentID,
 				},
 			}
+		case "f", "":
+			entry = &Object{
+				ObjectInfo: ObjectInfo{
+					fs:       f,
+					id:       item.Obj,
+					name:     name,
+					remote:   path.Join(remote, name),
+					modTime:  item.MTime,
+					size:     item.Size,
+					entry:    item,
+					parentID: parentID,
+				},
+			}
+		default:
+			if !f.opt.ShowSpecial {
+				fs.Debugf(f, "Skipping special file %q of type %q", path.Join(remote, name), item.Type)
+				continue
+			}
+			entry = &Object{
+				ObjectInfo: ObjectInfo{
+					fs:       f,
+					id:       item.Obj,
+					name:     name,
+					remote:   path.Join(remote, name),
+					modTime:  item.MTime,
+					size:     0,
+					entry:    item,
+					parentID: parentID,
+				},
+				special: true,
+			}
 		}
-		dirEntries = append(dirEntries, entry)
+		listing.entries = append(listing.entries, entry)
+	}
+	f.checkDuplicates(remote, listing)
+	return listing
+}
+
+// errDuplicateName is returned for directories containing entries
+// with the same name when duplicate_names is "error"
+var errDuplicateName = errors.New("duplicate name")
+
+// checkDuplicates finds the entries in listing with the same name
+// and, if using the newest, moves the newest of them in front of the
+// others so it is found first.
+func (f *Fs) checkDuplicates(remote string, listing *dirListing) {
+	policy := f.opt.DuplicateNames
+	if policy == "" {
+		policy = "first"
+	}
+	first := map[string]int{} // name to index of the entry used
+	for i, entry := range listing.entries {
+		name := entry.(DirEntry).Name()
+		key := name
+		if f.opt.CaseInsensitive {
+			key = strings.ToLower(key)
+		}
+		j, ok := first[key]
+		if !ok {
+			first[key] = i
+			continue
+		}
+		listing.dupNames = append(listing.dupNames, name)
+		if policy == "error" {
+			continue
+		}
+		if policy == "newest" && entry.ModTime(context.Background()).After(listing.entries[j].ModTime(context.Background())) {
+			listing.entries[i], listing.entries[j] = listing.entries[j], listing.entries[i]
+		}
+		fs.Logf(f, "Duplicate name %q in %q - using the %s entry", name, remote, policy)
 	}
-	return dirEntries, nil
 }
 
 func (f *Fs) list(ctx context.Context, remote string) (fs.DirEntries, error) {
-	remote = cleanPath(remote)
-	var dirEntries fs.DirEntries
+	listing, err := f.listing(ctx, remote)
+	if err != nil {
+		return nil, err
+	}
+	if f.opt.DuplicateNames == "error" && len(listing.dupNames) > 0 {
+		return nil, fserrors.NoRetryError(fmt.Errorf("%w %q in %q - set duplicate_names to read it", errDuplicateName, listing.dupNames[0], remote))
+	}
+	return f.followedEntries(ctx, listing)
+}
+
+// forgetRootListing makes the next listing of the root read it from
+// the server
+func (f *Fs) forgetRootListing() {
+	f.listMu.Lock()
+	f.rootListing = nil
+	f.listMu.Unlock()
+}
+
+// listing returns the possibly cached listing of the directory at remote
+func (f *Fs) listing(ctx context.Context, remote string) (*dirListing, error) {
+	remote = f.snapshotDirPath(ctx, cleanPath(remote))
+	if listing, ok, err := f.stagedListing(ctx, remote); ok {
+		return listing, err
+	}
+	if f.isSnapshotDir(remote) {
+		return f.snapshotDirsListing(ctx, remote)
+	}
 	if remote == "" {
-		if f.rootEntries != nil {
-			dirEntries = *f.rootEntries
-		} else {
-			rootId, err := f.getRootId(ctx)
-			if err != nil {
-				return nil, err
-			}
-			dirEntries, err = f.listObject(ctx, remote, rootId)
+		rootId, err := f.getRootId(ctx)
+		if errors.Is(err, errNoSnapshots) {
+			return f.newDirListing(remote, "", "", nil), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		f.listMu.Lock()
+		listing := f.rootListing
+		valid := listing != nil && listing.id == rootId && !f.expired(listing.fetched)
+		f.listMu.Unlock()
+		if !valid {
+			listing, err = f.listObject(ctx, remote, rootId, listing, nil)
 			if err != nil {
 				return nil, err
 			}
-			f.rootEntries = &dirEntries
+			f.listMu.Lock()
+			f.rootListing = listing
+			f.listMu.Unlock()
 		}
-		return dirEntries, nil
+		return listing, nil
 	} else {
 		obj, err := f.newObject(ctx, remote)
 		if err != nil {
@@ -316,21 +1515,27 @@ func (f *Fs) list(ctx context.Context, remote string) (fs.DirEntries, error) {
 		if !ok {
 			return nil, fs.ErrorIsFile
 		}
-		if dirObj.entries == nil {
-			dirEntries, err = f.listObject(ctx, remote, dirObj.id)
+		f.listMu.Lock()
+		listing := dirObj.listing
+		valid := listing != nil && !f.expired(listing.fetched)
+		f.listMu.Unlock()
+		if !valid {
+			listing, err = f.listObject(ctx, dirObj.remote, dirObj.id, listing, &dirObj.summary)
 			if err != nil {
 				return nil, err
 			}
-			dirObj.entries = &dirEntries
+			f.listMu.Lock()
+			dirObj.listing = listing
+			f.listMu.Unlock()
 		}
-		return *dirObj.entries, nil
+		return listing, nil
 	}
 }
 
 // NewObject finds the Object at remote.  If it can't be found
 // it returns the error fs.ErrorObjectNotFound.
 func (f *Fs) newObject(ctx context.Context, remote string) (obj DirEntry, err error) {
-	remote = cleanPath(remote)
+	remote = f.snapshotDirPath(ctx, cleanPath(remote))
 	var dirEntries fs.DirEntries
 	dir, file := path.Split(remote)
 	dirEntries, err = f.list(ctx, dir)
@@ -345,23 +1550,230 @@ func (f *Fs) newObject(ctx context.Context, remote string) (obj DirEntry, err er
 			return item.(DirEntry), nil
 		}
 	}
+	// prefer an exact match before trying a looser one
+	for _, item := range dirEntries {
+		if f.sameName(item.(DirEntry).Name(), file) {
+			return item.(DirEntry), nil
+		}
+	}
 	return nil, fs.ErrorObjectNotFound
 }
 
+// sameName returns true if the name b refers to the entry named a
+func (f *Fs) sameName(a, b string) bool {
+	b = f.normalizeName(b)
+	return a == b || (f.opt.CaseInsensitive && strings.EqualFold(a, b))
+}
+
+// normalizeName returns name in the configured unicode normalization form
+func (f *Fs) normalizeName(name string) string {
+	switch f.opt.Normalization {
+	case "NFC":
+		return norm.NFC.String(name)
+	case "NFD":
+		return norm.NFD.String(name)
+	}
+	return name
+}
+
+// errUnsafeName is returned for entry names which can't be used in a remote
+var errUnsafeName = errors.New("unsafe entry name")
+
+// entryName converts the name of an entry read from the server to the
+// name used in remotes.
+//
+// The encoding normally replaces "/", NUL and the names "." and ".."
+// but not all encodings do, so names which would still make a remote
+// outside the directory, such as when restored to local disk, are
+// rejected.
+func (f *Fs) entryName(raw string) (string, error) {
+	name := f.normalizeName(f.opt.Enc.ToStandardName(f.decodeName(raw)))
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\x00") {
+		return "", fmt.Errorf("%w %q", errUnsafeName, raw)
+	}
+	return name, nil
+}
+
+// Put the object
+//
+// Copy the reader in to the new object which is returned.
+//
+// The new object may have been created if an error is returned
 func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
-	return nil, fs.ErrorPermissionDenied
+	o, err := f.upload(ctx, path.Join(f.root, src.Remote()), in, src)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// PutStream uploads to the remote path with the modTime given of
+// indeterminate size
+//
+// The stream is split into contents as it is read and assembled into
+// an indirect object so the size needn't be known in advance.
+func (f *Fs) PutStream(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	return f.Put(ctx, in, src, options...)
 }
 
 // Mkdir makes the directory or library
 //
 // Shouldn't return an error if it already exists
 func (f *Fs) Mkdir(ctx context.Context, dir string) error {
-	return fs.ErrorPermissionDenied
+	return f.mkdir(ctx, path.Join(f.root, dir))
 }
 
 // Rmdir removes the directory or library if empty
 //
 // Return an error if it doesn't exist or isn't empty
 func (f *Fs) Rmdir(ctx context.Context, dir string) error {
-	return fs.ErrorPermissionDenied
+	if dir == "" && f.rootFile != "" {
+		// the root is the file, not its parent directory
+		return fs.ErrorIsFile
+	}
+	return f.rmdir(ctx, path.Join(f.root, dir))
 }
+
+// Purge all files in the directory specified
+//
+// In read_write mode this drops the whole directory from the staged
+// snapshot in one edit.
+//
+// Return an error if it doesn't exist
+func (f *Fs) Purge(ctx context.Context, dir string) error {
+	if dir == "" && f.rootFile != "" {
+		// the root is the file, not its parent directory
+		return fs.ErrorIsFile
+	}
+	return f.purge(ctx, path.Join(f.root, dir))
+}
+
+// About gets quota information from the summary kopia records for
+// the snapshot, or for the directory at the root of the remote.
+//
+// The directory count isn't part of fs.Usage so it is only logged.
+func (f *Fs) About(ctx context.Context) (*fs.Usage, error) {
+	var summary Summary
+	if f.root == "" {
+		rootID, err := f.getRootId(ctx)
+		if err != nil && !errors.Is(err, errNoSnapshots) {
+			return nil, err
+		}
+		d, err := f.readDirObject(ctx, rootID)
+		if err != nil {
+			return nil, err
+		}
+		summary = d.summary
+	} else {
+		obj, err := f.newObject(ctx, f.root)
+		if err != nil && !errors.Is(err, fs.ErrorObjectNotFound) && !errors.Is(err, fs.ErrorDirNotFound) {
+			return nil, err
+		}
+		if dir, ok := obj.(*Directory); ok {
+			summary = dir.summary
+		}
+	}
+	fs.Debugf(f, "Snapshot summary: %d files and %d directories totalling %d bytes", summary.Files, summary.Dirs, summary.Size)
+	usage := &fs.Usage{
+		Used:    fs.NewUsageValue(summary.Size),
+		Objects: fs.NewUsageValue(int64(summary.Files)),
+	}
+	if !f.opt.ReadWrite {
+		// a snapshot can't grow
+		usage.Total = fs.NewUsageValue(summary.Size)
+		usage.Free = fs.NewUsageValue(0)
+	}
+	return usage, nil
+}
+
+// DirSize returns the number of files in dir and their total size
+// from the summary kopia records for it, so rclone size needn't list
+// the tree.
+//
+// It returns fs.ErrorNotImplemented if the summary may not match what
+// listing would find, for example if symlinks or special files are
+// shown or there are staged changes which haven't been committed.
+func (f *Fs) DirSize(ctx context.Context, dir string) (objects int64, size int64, err error) {
+	remote := cleanPath(path.Join(f.root, dir))
+	f.stageMu.Lock()
+	pending := f.staged != nil && f.staged.changes > 0
+	f.stageMu.Unlock()
+	if pending || f.isSnapshotDir(remote) || f.opt.ShowSpecial {
+		return 0, 0, fs.ErrorNotImplemented
+	}
+	var summary Summary
+	if remote == "" {
+		rootID, err := f.getRootId(ctx)
+		if errors.Is(err, errNoSnapshots) {
+			return 0, 0, nil
+		} else if err != nil {
+			return 0, 0, err
+		}
+		d, err := f.readDirObject(ctx, rootID)
+		if err != nil {
+			return 0, 0, err
+		}
+		summary = d.summary
+	} else {
+		obj, err := f.newObject(ctx, remote)
+		if errors.Is(err, fs.ErrorObjectNotFound) {
+			return 0, 0, fs.ErrorDirNotFound
+		} else if err != nil {
+			return 0, 0, err
+		}
+		d, ok := obj.(*Directory)
+		if !ok {
+			return 0, 0, fs.ErrorIsFile
+		}
+		summary = d.summary
+	}
+	if summary.empty() || summary.NumFailed > 0 || (summary.Symlinks > 0 && (f.opt.TranslateLinks || f.opt.FollowSymlinks)) {
+		return 0, 0, fs.ErrorNotImplemented
+	}
+	fs.Debugf(f, "Size of %q from snapshot summary: %d files totalling %d bytes", remote, summary.Files, summary.Size)
+	return int64(summary.Files), summary.Size, nil
+}
+
+// CleanUp clears the checksums, listings and other data cached by the
+// remote, including the persistent hash cache, so they are read again
+// from the server. Changes staged in read_write mode are kept.
+func (f *Fs) CleanUp(ctx context.Context) error {
+	if err := f.hashCache.clear(); err != nil {
+		return fmt.Errorf("failed to clear hash cache: %w", err)
+	}
+	f.forgetRootListing()
+	f.linkMu.Lock()
+	f.linkRootID, f.linkGroups = "", nil
+	f.linkMu.Unlock()
+	f.policyMu.Lock()
+	f.policy = nil
+	f.policyMu.Unlock()
+	fs.Infof(f, "Cleared cached checksums and listings")
+	return nil
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs             = &Fs{}
+	_ fs.Abouter        = &Fs{}
+	_ fs.ChangeNotifier = &Fs{}
+	_ fs.DirSizer       = &Fs{}
+	_ fs.CleanUpper     = &Fs{}
+	_ fs.Commander      = &Fs{}
+	_ fs.PutStreamer    = &Fs{}
+	_ fs.Copier         = &Fs{}
+	_ fs.Purger         = &Fs{}
+	_ fs.MergeDirser    = &Fs{}
+	_ fs.Mover          = &Fs{}
+	_ fs.DirMover       = &Fs{}
+	_ fs.Shutdowner     = &Fs{}
+	_ fs.Object         = &Object{}
+	_ fs.Directory      = &Directory{}
+	_ fs.IDer           = &Object{}
+	_ fs.IDer           = &Directory{}
+	_ fs.ParentIDer     = &Object{}
+	_ fs.ParentIDer     = &Directory{}
+	_ fs.Metadataer     = &Object{}
+	_ fs.MimeTyper      = &Object{}
+	_ fs.Metadataer     = &Directory{}
+)
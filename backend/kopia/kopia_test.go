@@ -0,0 +1,30 @@
+// Test kopia filesystem interface
+package kopia_test
+
+import (
+	"testing"
+
+	"github.com/rclone/rclone/backend/kopia"
+	"github.com/rclone/rclone/fstest/fstests"
+)
+
+var (
+	unimplementableFsMethods     = []string{"UnWrap", "WrapFs", "SetWrapper", "UserInfo", "Disconnect", "PublicLink", "PutUnchecked", "OpenWriterAt", "OpenChunkWriter", "ListR", "MkdirMetadata", "DirSetModTime", "DirCacheFlush"}
+	unimplementableObjectMethods = []string{"SetMetadata", "SetTier", "GetTier", "UnWrap"}
+)
+
+// TestIntegration runs integration tests against the remote
+//
+// The suite writes to the remote so the snapshot source is opened
+// read_write. Without it the remote is read-only.
+func TestIntegration(t *testing.T) {
+	fstests.Run(t, &fstests.Opt{
+		RemoteName: "TestKopia:",
+		NilObject:  (*kopia.Object)(nil),
+		ExtraConfig: []fstests.ExtraConfigItem{
+			{Name: "TestKopia", Key: "read_write", Value: "true"},
+		},
+		UnimplementableFsMethods:     unimplementableFsMethods,
+		UnimplementableObjectMethods: unimplementableObjectMethods,
+	})
+}
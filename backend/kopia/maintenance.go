@@ -0,0 +1,53 @@
+package kopia
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/rest"
+)
+
+// maintenance runs quick maintenance of the repository on the server,
+// or full maintenance if opt["full"] is set, waiting for it to finish
+// unless opt["async"] is set.
+//
+// opt["confirm"] must be "quick" or "full" to match so it can't be
+// started by accident.
+func (f *Fs) maintenance(ctx context.Context, opt map[string]string) (*TaskInfo, error) {
+	if err := f.checkWritable(); err != nil {
+		return nil, err
+	}
+	full, err := boolOption(opt, "full")
+	if err != nil {
+		return nil, err
+	}
+	async, err := boolOption(opt, "async")
+	if err != nil {
+		return nil, err
+	}
+	mode := "quick"
+	if full {
+		mode = "full"
+	}
+	if opt["confirm"] != mode {
+		return nil, fmt.Errorf("this runs %s maintenance of the whole repository - confirm with -o confirm=%s", mode, mode)
+	}
+	if fs.GetConfig(ctx).DryRun {
+		fs.Logf(f, "Not running %s maintenance as --dry-run is set", mode)
+		return nil, nil
+	}
+	var task TaskInfo
+	err = f.callJSON(ctx, &rest.Opts{
+		Method: "POST",
+		Path:   "/api/v1/repo/maintenance",
+	}, &MaintenanceRequest{Full: full}, &task)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start maintenance: %w", err)
+	}
+	fs.Infof(f, "Started %s maintenance task %s on the server", mode, task.ID)
+	if async {
+		return &task, nil
+	}
+	return f.waitTask(ctx, &task)
+}
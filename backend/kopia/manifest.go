@@ -0,0 +1,76 @@
+package kopia
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/rclone/rclone/lib/rest"
+)
+
+// manifestReport is the output of the manifest command
+type manifestReport struct {
+	ID       string            `json:"id"`
+	Labels   map[string]string `json:"labels"`
+	ModTime  time.Time         `json:"mtime"`
+	Manifest json.RawMessage   `json:"manifest"`
+	Root     json.RawMessage   `json:"root,omitempty"`
+}
+
+// manifest returns the manifest of a snapshot as stored in the
+// repository.
+//
+// opt["snapshot"] is the snapshot, by default the one the remote is
+// showing, as a snapshot ID, a root object ID or "latest". If
+// opt["root"] is set the root directory object is returned too.
+func (f *Fs) manifest(ctx context.Context, opt map[string]string) (*manifestReport, error) {
+	result, _, _, err := f.fetchSnapshots(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	spec := opt["snapshot"]
+	if spec == "" {
+		if spec, err = f.getRootId(ctx); err != nil {
+			return nil, err
+		}
+	}
+	i, err := findSnapshot(result.Snapshots, spec)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &result.Snapshots[i]
+	var m ManifestWithMetadata
+	err = f.callJSON(ctx, &rest.Opts{
+		Method: "GET",
+		Path:   fmt.Sprintf("/api/v1/manifests/%s", snapshot.ID),
+	}, nil, &m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest of snapshot %s: %w", snapshot.ID, err)
+	}
+	report := &manifestReport{
+		ID:       m.Metadata.ID,
+		Labels:   m.Metadata.Labels,
+		ModTime:  m.Metadata.ModTime,
+		Manifest: m.Payload,
+	}
+	root := false
+	if s, ok := opt["root"]; ok {
+		root, err = strconv.ParseBool(s)
+		if err != nil && s != "" {
+			return nil, fmt.Errorf("bad root option: %w", err)
+		}
+		root = root || s == ""
+	}
+	if root {
+		err = f.callJSON(ctx, &rest.Opts{
+			Method: "GET",
+			Path:   fmt.Sprintf("/api/v1/objects/%s", snapshot.RootID),
+		}, nil, &report.Root)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read root directory object %s: %w", snapshot.RootID, err)
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,341 @@
+package kopia
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// system metadata keys which this backend reads
+var systemMetadataInfo = map[string]fs.MetadataHelp{
+	"mode": {
+		Help:     "File type and mode",
+		Type:     "octal, unix style",
+		Example:  "0100664",
+		ReadOnly: true,
+	},
+	"uid": {
+		Help:     "User ID of owner",
+		Type:     "decimal number",
+		Example:  "500",
+		ReadOnly: true,
+	},
+	"gid": {
+		Help:     "Group ID of owner",
+		Type:     "decimal number",
+		Example:  "500",
+		ReadOnly: true,
+	},
+	"owner": {
+		Help:     "User name of owner, if recorded in the snapshot",
+		Type:     "string",
+		Example:  "alice",
+		ReadOnly: true,
+	},
+	"group": {
+		Help:     "Group name of owner, if recorded in the snapshot",
+		Type:     "string",
+		Example:  "staff",
+		ReadOnly: true,
+	},
+	"atime": {
+		Help:     "Time of last access, if recorded in the snapshot",
+		Type:     "RFC 3339",
+		Example:  "2006-01-02T15:04:05.999999999Z07:00",
+		ReadOnly: true,
+	},
+	"mtime": {
+		Help:     "Time of last modification",
+		Type:     "RFC 3339",
+		Example:  "2006-01-02T15:04:05.999999999Z07:00",
+		ReadOnly: true,
+	},
+	"ctime": {
+		Help:     "Time of last status change, if recorded in the snapshot",
+		Type:     "RFC 3339",
+		Example:  "2006-01-02T15:04:05.999999999Z07:00",
+		ReadOnly: true,
+	},
+	"btime": {
+		Help:     "Time of file birth (creation), if recorded in the snapshot",
+		Type:     "RFC 3339",
+		Example:  "2006-01-02T15:04:05.999999999Z07:00",
+		ReadOnly: true,
+	},
+	"type": {
+		Help:     "Kopia entry type of a special file",
+		Type:     "string",
+		Example:  "p",
+		ReadOnly: true,
+	},
+	"attributes": {
+		Help:     "Windows file attributes, if recorded in the snapshot",
+		Type:     "comma separated list",
+		Example:  "hidden,readonly",
+		ReadOnly: true,
+	},
+	"streams": {
+		Help:     "Names of the alternate data streams, if recorded in the snapshot",
+		Type:     "comma separated list",
+		Example:  "Zone.Identifier",
+		ReadOnly: true,
+	},
+	"acl": {
+		Help:     "POSIX ACL, if recorded in the snapshot",
+		Type:     "base64 encoded opaque data",
+		Example:  "AgAAAAEABgD/////BAAEAP////8gAAQA/////w==",
+		ReadOnly: true,
+	},
+	"security-descriptor": {
+		Help:     "Windows security descriptor, if recorded in the snapshot",
+		Type:     "base64 encoded opaque data",
+		Example:  "AQAEgBQAAAAkAAAAAAAAADQAAAA=",
+		ReadOnly: true,
+	},
+	"holes": {
+		Help:     "Holes in a sparse file, if recorded in the snapshot",
+		Type:     "comma separated list of offset:length",
+		Example:  "4096:1048576,2097152:65536",
+		ReadOnly: true,
+	},
+	"nlink": {
+		Help:     "Number of files sharing this object, if detecting hardlinks",
+		Type:     "decimal number",
+		Example:  "2",
+		ReadOnly: true,
+	},
+	"link-group": {
+		Help:     "Object ID shared by the files in a hardlink group",
+		Type:     "string",
+		Example:  "k1b44e8cbc6d8afb4bd3e4f8d2b7c4b0c",
+		ReadOnly: true,
+	},
+	"kopia-object-id": {
+		Help:     "Kopia object ID of the entry",
+		Type:     "string",
+		Example:  "k1b44e8cbc6d8afb4bd3e4f8d2b7c4b0c",
+		ReadOnly: true,
+	},
+	"kopia-snapshot-id": {
+		Help:     "ID of the kopia snapshot the entry is from",
+		Type:     "string",
+		Example:  "c4c3b8e1a1cbbd0d9c6c5bb0b2e5f7a9",
+		ReadOnly: true,
+	},
+	"tree-size": {
+		Help:     "Total size of the files in the directory tree",
+		Type:     "decimal number",
+		Example:  "1234567",
+		ReadOnly: true,
+	},
+	"tree-files": {
+		Help:     "Number of files in the directory tree",
+		Type:     "decimal number",
+		Example:  "123",
+		ReadOnly: true,
+	},
+	"tree-dirs": {
+		Help:     "Number of directories in the directory tree",
+		Type:     "decimal number",
+		Example:  "12",
+		ReadOnly: true,
+	},
+	"tree-failed": {
+		Help:     "Number of entries in the directory tree which failed to snapshot",
+		Type:     "decimal number",
+		Example:  "0",
+		ReadOnly: true,
+	},
+	"tree-mtime": {
+		Help:     "Latest modification time in the directory tree",
+		Type:     "RFC 3339",
+		Example:  "2006-01-02T15:04:05.999999999Z07:00",
+		ReadOnly: true,
+	},
+}
+
+// unix file type bits for each kopia entry type
+var entryTypeMode = map[string]uint32{
+	"f": 0100000,
+	"d": 0040000,
+	"s": 0120000,
+}
+
+// prefix for extended attributes outside the user namespace
+const xattrPrefix = "xattr-"
+
+// setXattrs adds the extended attributes in xattrs to m
+//
+// Attributes in the user namespace are returned without the "user."
+// prefix, which is how the local backend reads and writes them, so
+// they survive a restore to local disk. Others, such as SELinux
+// contexts, are returned with the "xattr-" prefix and their full name.
+func setXattrs(m fs.Metadata, xattrs map[string]string) {
+	for k, v := range xattrs {
+		if name, ok := strings.CutPrefix(k, "user."); ok {
+			if _, found := systemMetadataInfo[name]; !found {
+				m[name] = v
+				continue
+			}
+		}
+		m[xattrPrefix+k] = v
+	}
+}
+
+// keys returned when restore_perms is set
+var restoreMetadataKeys = []string{"mode", "uid", "gid", "atime", "mtime", "btime"}
+
+// restoreMetadata returns the subset of m needed to restore a file
+// with restore_perms
+func restoreMetadata(m fs.Metadata) fs.Metadata {
+	out := make(fs.Metadata, len(restoreMetadataKeys))
+	for _, k := range restoreMetadataKeys {
+		if v, ok := m[k]; ok {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// names of the Windows file attributes in the order they are shown
+var windowsAttributes = []struct {
+	bit  uint32
+	name string
+}{
+	{0x1, "readonly"},
+	{0x2, "hidden"},
+	{0x4, "system"},
+	{0x20, "archive"},
+	{0x100, "temporary"},
+	{0x800, "compressed"},
+	{0x1000, "offline"},
+	{0x2000, "not-content-indexed"},
+	{0x4000, "encrypted"},
+}
+
+// attributeNames returns the names of the Windows attributes in attrs
+func attributeNames(attrs uint32) string {
+	var names []string
+	for _, attr := range windowsAttributes {
+		if attrs&attr.bit != 0 {
+			names = append(names, attr.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// metadata returns the system metadata from the directory entry
+func (o *ObjectInfo) metadata() (fs.Metadata, error) {
+	if o.entry == nil {
+		return nil, nil
+	}
+	m := fs.Metadata{
+		"kopia-object-id": o.id,
+	}
+	if _, snapshotID, _ := o.fs.currentRoot(); snapshotID != "" {
+		m["kopia-snapshot-id"] = snapshotID
+	}
+	if o.entry.Mode != "" {
+		perm, err := strconv.ParseUint(o.entry.Mode, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("bad mode %q: %w", o.entry.Mode, err)
+		}
+		m["mode"] = fmt.Sprintf("%0o", entryTypeMode[o.entry.Type]|uint32(perm))
+		m["uid"] = strconv.FormatUint(uint64(o.entry.UserID), 10)
+		m["gid"] = strconv.FormatUint(uint64(o.entry.GroupID), 10)
+	}
+	if o.entry.User != "" {
+		m["owner"] = o.entry.User
+	}
+	if o.entry.Group != "" {
+		m["group"] = o.entry.Group
+	}
+	if names := attributeNames(o.entry.Attrs); names != "" {
+		m["attributes"] = names
+	}
+	if len(o.entry.Streams) > 0 {
+		m["streams"] = strings.Join(o.entry.Streams, ",")
+	}
+	setXattrs(m, o.entry.XAttrs)
+	if len(o.entry.ACL) > 0 {
+		m["acl"] = base64.StdEncoding.EncodeToString(o.entry.ACL)
+	}
+	if len(o.entry.SD) > 0 {
+		m["security-descriptor"] = base64.StdEncoding.EncodeToString(o.entry.SD)
+	}
+	if len(o.entry.Holes) > 0 {
+		holes := make([]string, len(o.entry.Holes))
+		for i, hole := range o.entry.Holes {
+			holes[i] = fmt.Sprintf("%d:%d", hole.Start, hole.Length)
+		}
+		m["holes"] = strings.Join(holes, ",")
+	}
+	setTime := func(key string, t time.Time) {
+		if !t.IsZero() {
+			m[key] = t.Format(time.RFC3339Nano)
+		}
+	}
+	setTime("atime", o.entry.ATime)
+	setTime("mtime", o.entry.MTime)
+	setTime("ctime", o.entry.CTime)
+	setTime("btime", o.entry.BTime)
+	return m, nil
+}
+
+// Metadata returns metadata for an object
+//
+// It should return nil if there is no Metadata
+func (o *Object) Metadata(ctx context.Context) (fs.Metadata, error) {
+	m, err := o.metadata()
+	if err == nil && m != nil && o.fs.opt.RestorePerms {
+		return restoreMetadata(m), nil
+	}
+	if err == nil && m != nil && o.special {
+		m["type"] = o.entry.Type
+	}
+	if err != nil || m == nil || !o.fs.opt.Hardlinks || !o.linkable() {
+		return m, err
+	}
+	groups, err := o.fs.hardlinkGroups(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if n := len(groups[o.id]); n > 1 {
+		m["nlink"] = strconv.Itoa(n)
+		m["link-group"] = o.id
+	}
+	return m, nil
+}
+
+// Metadata returns metadata for a directory
+//
+// This includes the summary of the directory tree kopia records so
+// tree sizes can be reported without walking it.
+//
+// It should return nil if there is no Metadata
+func (o *Directory) Metadata(ctx context.Context) (fs.Metadata, error) {
+	m, err := o.metadata()
+	if err != nil || m == nil {
+		return m, err
+	}
+	if o.fs.opt.RestorePerms {
+		return restoreMetadata(m), nil
+	}
+	s := o.summary
+	if s.empty() {
+		return m, nil
+	}
+	m["tree-size"] = strconv.FormatInt(s.Size, 10)
+	m["tree-files"] = strconv.Itoa(s.Files)
+	m["tree-dirs"] = strconv.Itoa(s.Dirs)
+	m["tree-failed"] = strconv.Itoa(s.NumFailed)
+	if !s.MaxTime.IsZero() {
+		m["tree-mtime"] = s.MaxTime.Format(time.RFC3339Nano)
+	}
+	return m, nil
+}
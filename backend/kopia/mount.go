@@ -0,0 +1,74 @@
+package kopia
+
+import (
+	"context"
+	"strings"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/rest"
+)
+
+// mountURL returns the WebDAV URL of the server's mount of the
+// directory object rootID, or "" if it isn't served over HTTP.
+//
+// The server is only asked once for each root.
+func (f *Fs) mountURL(ctx context.Context, rootID string) string {
+	f.mountMu.Lock()
+	defer f.mountMu.Unlock()
+	if u, ok := f.mounts[rootID]; ok {
+		return u
+	}
+	var mount MountedSnapshot
+	err := f.callJSON(ctx, &rest.Opts{
+		Method: "POST",
+		Path:   "/api/v1/mounts",
+	}, &MountSnapshotRequest{Root: rootID}, &mount)
+	u := ""
+	switch {
+	case err != nil:
+		fs.Logf(f, "Failed to mount %s on the server - reading through the API: %v", rootID, err)
+	case strings.HasPrefix(mount.Path, "http://") || strings.HasPrefix(mount.Path, "https://"):
+		u = strings.TrimRight(mount.Path, "/")
+		fs.Debugf(f, "Reading %s through its mount at %s", rootID, u)
+	default:
+		fs.Logf(f, "%s is mounted at %q on the server which isn't WebDAV - reading through the API", rootID, mount.Path)
+	}
+	if f.mounts == nil {
+		f.mounts = map[string]string{}
+	}
+	f.mounts[rootID] = u
+	return u
+}
+
+// mountFailed stops reads of rootID going through its mount
+func (f *Fs) mountFailed(rootID string) {
+	f.mountMu.Lock()
+	f.mounts[rootID] = ""
+	f.mountMu.Unlock()
+}
+
+// mountPath returns the root ID of the snapshot the remote is showing
+// and the URL to read o from in its mount, or "" to read o through the
+// API.
+func (o *Object) mountPath(ctx context.Context) (rootID, u string) {
+	f := o.fs
+	if !f.opt.MountReads || o.parentID == "" || f.snapshotLayout() != 0 ||
+		f.opt.FollowSymlinks || f.opt.TranslateLinks || f.opt.CaseInsensitive || f.opt.Normalization != "" {
+		return "", ""
+	}
+	f.stageMu.Lock()
+	pending := f.staged != nil && f.staged.changes > 0
+	f.stageMu.Unlock()
+	if pending {
+		return "", ""
+	}
+	rootID, err := f.getRootId(ctx)
+	if err != nil {
+		return "", ""
+	}
+	base := f.mountURL(ctx, rootID)
+	if base == "" {
+		return "", ""
+	}
+	return rootID, base + "/" + rest.URLPathEscape(f.opt.Enc.FromStandardPath(o.remote))
+}
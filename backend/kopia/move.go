@@ -0,0 +1,312 @@
+package kopia
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// sameSource returns true if other writes snapshots of the same
+// source in the same repository as f
+func (f *Fs) sameSource(other *Fs) bool {
+	return f.sourceKey() == other.sourceKey()
+}
+
+// joinStaging makes sure any changes staged by src are visible to f
+// so entries can be moved from one to the other.
+func (f *Fs) joinStaging(ctx context.Context, src *Fs) error {
+	if src == f {
+		return nil
+	}
+	src.stageMu.Lock()
+	pending := src.staged != nil && src.staged.changes > 0
+	src.stageMu.Unlock()
+	if !pending {
+		return nil
+	}
+	if _, err := src.commit(ctx); err != nil {
+		return err
+	}
+	f.stageMu.Lock()
+	defer f.stageMu.Unlock()
+	if f.staged != nil && f.staged.changes > 0 {
+		return errors.New("can't combine the uncommitted changes of two remotes")
+	}
+	f.staged = nil
+	f.adoptCommit()
+	return nil
+}
+
+// moveEntry moves the entry at srcRemote to dstRemote in the staged
+// snapshot, creating the parent directories of dstRemote if needed.
+//
+// If isDir is set the entry must be a directory and dstRemote must not
+// exist, otherwise it must not be a directory and replaces any file at
+// dstRemote.
+func (f *Fs) moveEntry(ctx context.Context, srcRemote, dstRemote string, isDir bool) error {
+	if err := f.checkRemote(dstRemote); err != nil {
+		return err
+	}
+	f.stageMu.Lock()
+	defer f.stageMu.Unlock()
+	srcDir, srcLeaf := path.Split(cleanPath(srcRemote))
+	srcDirs, err := f.stagedDirs(ctx, srcDir, false)
+	if err != nil {
+		return err
+	}
+	_, e := f.find(srcDirs[len(srcDirs)-1], srcLeaf)
+	if e == nil || (e.entry.Type == "d") != isDir {
+		if isDir {
+			return fs.ErrorDirNotFound
+		}
+		return fs.ErrorObjectNotFound
+	}
+
+	// check the destination before changing anything
+	dstDir, dstLeaf := path.Split(cleanPath(dstRemote))
+	dstDirs, err := f.stagedDirs(ctx, dstDir, false)
+	if err == nil {
+		if _, old := f.find(dstDirs[len(dstDirs)-1], dstLeaf); old != nil && old != e {
+			if isDir {
+				return fs.ErrorDirExists
+			}
+			if old.entry.Type == "d" {
+				return fs.ErrorIsDir
+			}
+		}
+	} else if !errors.Is(err, fs.ErrorDirNotFound) {
+		return err
+	}
+
+	if _, err = f.unstageLocked(ctx, srcRemote, nil); err != nil {
+		return err
+	}
+	dstDirs, err = f.stagedDirs(ctx, dstDir, true)
+	if err != nil {
+		return err
+	}
+	d := dstDirs[len(dstDirs)-1]
+	raw, old := f.find(d, dstLeaf)
+	if old != nil {
+		delete(d.entries, raw)
+	} else {
+		raw = f.opt.Enc.FromStandardName(dstLeaf)
+	}
+	e.entry.Name = raw
+	d.entries[raw] = e
+	d.changed = true
+	f.staged.changes++
+	f.logDryRun(ctx, "move %q to %q", srcRemote, dstRemote)
+	return nil
+}
+
+// Move src to this remote using server side move operations.
+//
+// This is stored with the remote path given.
+//
+// It returns the destination Object and a possible error.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantMove
+func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	srcObj, ok := src.(*Object)
+	if !ok || !f.opt.ReadWrite || !f.sameSource(srcObj.fs) {
+		fs.Debugf(src, "Can't move - not in the same snapshot source")
+		return nil, fs.ErrorCantMove
+	}
+	if err := f.joinStaging(ctx, srcObj.fs); err != nil {
+		return nil, err
+	}
+	dstRemote := path.Join(f.root, remote)
+	if err := f.moveEntry(ctx, srcObj.remote, dstRemote, false); err != nil {
+		return nil, err
+	}
+	return f.stagedObject(ctx, dstRemote)
+}
+
+// DirMove moves src, srcRemote to this remote at dstRemote
+// using server side move operations.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantDirMove
+//
+// If destination exists then return fs.ErrorDirExists
+func (f *Fs) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string) error {
+	srcFs, ok := src.(*Fs)
+	if !ok || !f.opt.ReadWrite || !f.sameSource(srcFs) {
+		fs.Debugf(srcFs, "Can't move directory - not in the same snapshot source")
+		return fs.ErrorCantDirMove
+	}
+	srcPath := cleanPath(path.Join(srcFs.root, srcRemote))
+	dstPath := cleanPath(path.Join(f.root, dstRemote))
+	if srcPath == "" || dstPath == "" || dstPath == srcPath || strings.HasPrefix(dstPath, srcPath+"/") {
+		fs.Debugf(srcFs, "Can't move directory %q to %q", srcPath, dstPath)
+		return fs.ErrorCantDirMove
+	}
+	if err := f.joinStaging(ctx, srcFs); err != nil {
+		return err
+	}
+	return f.moveEntry(ctx, srcPath, dstPath, true)
+}
+
+// sameRepository returns true if other reads from the same repository
+// as f so object IDs can be shared between them
+func (f *Fs) sameRepository(other *Fs) bool {
+	return strings.TrimRight(f.opt.URL, "/") == strings.TrimRight(other.opt.URL, "/")
+}
+
+// Copy src to this remote using server-side copy operations.
+//
+// This is stored with the remote path given.
+//
+// It returns the destination Object and a possible error.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantCopy
+func (f *Fs) Copy(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	srcObj, ok := src.(*Object)
+	if !ok || !f.opt.ReadWrite || !f.sameRepository(srcObj.fs) {
+		fs.Debugf(src, "Can't copy - not in the same repository")
+		return nil, fs.ErrorCantCopy
+	}
+	if srcObj.entry == nil || srcObj.special || (srcObj.entry.Type != "f" && srcObj.entry.Type != "") {
+		fs.Debugf(src, "Can't copy - not a regular file")
+		return nil, fs.ErrorCantCopy
+	}
+	// copy all the fields of the entry, such as the holes and owner
+	data, err := json.Marshal(srcObj.entry)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	dstRemote := path.Join(f.root, remote)
+	if err := f.checkRemote(dstRemote); err != nil {
+		return nil, err
+	}
+	err = f.stageEntry(ctx, dstRemote, Entry{
+		Type:  "f",
+		Mode:  srcObj.entry.Mode,
+		Size:  srcObj.entry.Size,
+		MTime: srcObj.entry.MTime,
+		Obj:   srcObj.id,
+	}, fields)
+	if err != nil {
+		return nil, err
+	}
+	return f.stagedObject(ctx, dstRemote)
+}
+
+// stagedDirEntry finds the staged entry of dir, returning the staged
+// directory containing it and its name there.
+//
+// Call with stageMu held.
+func (f *Fs) stagedDirEntry(ctx context.Context, dir fs.Directory) (parent *stagedDir, raw string, e *stagedEntry, err error) {
+	d, ok := dir.(*Directory)
+	if !ok || d.entry == nil {
+		return nil, "", nil, fmt.Errorf("can't merge %v", dir)
+	}
+	parentPath := path.Dir(d.remote)
+	if parentPath == "." {
+		parentPath = ""
+	}
+	dirs, err := f.stagedDirs(ctx, parentPath, false)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	parent = dirs[len(dirs)-1]
+	// look up the exact name as the directories may only differ in case
+	raw = d.entry.Name
+	e = parent.entries[raw]
+	if e == nil || e.entry.Type != "d" {
+		return nil, "", nil, fs.ErrorDirNotFound
+	}
+	return parent, raw, e, nil
+}
+
+// mergeInto moves the entries of src into dst, merging directories
+// with the same name. Where files have the same name the newest is
+// kept.
+//
+// Call with stageMu held.
+func (f *Fs) mergeInto(ctx context.Context, dst, src *stagedDir, remote string) error {
+	for raw, e := range src.entries {
+		old, ok := dst.entries[raw]
+		switch {
+		case !ok:
+			dst.entries[raw] = e
+		case old.entry.Type == "d" && e.entry.Type == "d":
+			oldDir, err := f.loadDir(ctx, old)
+			if err != nil {
+				return err
+			}
+			srcDir, err := f.loadDir(ctx, e)
+			if err != nil {
+				return err
+			}
+			if err := f.mergeInto(ctx, oldDir, srcDir, path.Join(remote, raw)); err != nil {
+				return err
+			}
+		default:
+			if e.entry.MTime.After(old.entry.MTime) {
+				dst.entries[raw] = e
+			}
+			fs.Logf(f, "Merging %q: kept the newest of the entries with the same name", path.Join(remote, raw))
+		}
+	}
+	dst.changed = true
+	f.staged.changes++
+	f.logDryRun(ctx, "merge directories into %q", remote)
+	return nil
+}
+
+// MergeDirs merges the contents of all the directories passed
+// in into the first one and rmdirs the other directories.
+func (f *Fs) MergeDirs(ctx context.Context, dirs []fs.Directory) error {
+	if err := f.checkWritable(); err != nil {
+		return err
+	}
+	if len(dirs) < 2 {
+		return nil
+	}
+	f.stageMu.Lock()
+	defer f.stageMu.Unlock()
+	_, _, dstEntry, err := f.stagedDirEntry(ctx, dirs[0])
+	if err != nil {
+		return err
+	}
+	dst, err := f.loadDir(ctx, dstEntry)
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs[1:] {
+		parent, raw, e, err := f.stagedDirEntry(ctx, dir)
+		if err != nil {
+			return err
+		}
+		if e == dstEntry {
+			continue
+		}
+		src, err := f.loadDir(ctx, e)
+		if err != nil {
+			return err
+		}
+		fs.Infof(dir, "Merging contents into %v", dirs[0])
+		if err := f.mergeInto(ctx, dst, src, dirs[0].(*Directory).remote); err != nil {
+			return err
+		}
+		delete(parent.entries, raw)
+		parent.changed = true
+	}
+	return nil
+}
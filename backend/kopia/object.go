@@ -2,13 +2,17 @@ package kopia
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/rclone/rclone/lib/rest"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/fserrors"
 	"github.com/rclone/rclone/fs/hash"
 )
 
@@ -17,28 +21,49 @@ type DirEntry interface {
 }
 
 type ObjectInfo struct {
-	fs      *Fs
-	id      string
-	name    string
-	remote  string
-	size    int64
-	modTime time.Time
+	fs       *Fs
+	id       string
+	name     string
+	remote   string
+	size     int64
+	modTime  time.Time
+	entry    *Entry // directory entry this came from - nil for the root
+	parentID string // object ID of the directory listing it
 }
 
 type Object struct {
 	ObjectInfo
+	mimeType string // sniffed MIME type, if read
+	special  bool   // set for device nodes, sockets and FIFOs which have no content
 }
 
 type Directory struct {
 	ObjectInfo
-	entries *fs.DirEntries
+	summary Summary     // summary of the directory tree from the parent
+	listing *dirListing // cached listing - protected by Fs.listMu
+}
+
+// dirListing is a cached listing of a directory object
+//
+// Listings are shared between goroutines once cached so fetched,
+// entries and followed may only be changed with Fs.listMu held.
+type dirListing struct {
+	id       string // object ID which was listed
+	etag     string // ETag returned by the server, if any
+	fetched  time.Time
+	entries  fs.DirEntries
+	links    []symlink     // symlinks to follow, if following symlinks
+	dupNames []string      // names used by more than one entry
+	followed chan struct{} // closed once links have been followed, nil until started
 }
 
 func (o *Directory) Items() int64 {
-	if o.entries == nil {
+	o.fs.listMu.Lock()
+	defer o.fs.listMu.Unlock()
+	if o.listing == nil {
 		return -1
 	}
-	return int64(len(*o.entries))
+	return int64(len(o.listing.entries))
 }
 
 func (o *ObjectInfo) Name() string {
@@ -50,17 +75,20 @@ func (o *ObjectInfo) String() string {
 	if o == nil {
 		return "<nil>"
 	}
-	return o.remote
+	return o.Remote()
 }
 
-// Remote returns the remote string
-func (o *ObjectInfo) Remote() string {
-	s := len(o.fs.root)
-	if s == 0 {
-		return o.remote
-	} else {
-		return o.remote[s+1:]
+// Return a string version
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
 	}
+	return o.ObjectInfo.String()
+}
+
+// Remote returns the remote string relative to the root of the Fs
+func (o *ObjectInfo) Remote() string {
+	return relativePath(o.fs.root, o.remote)
 }
 
 // ModTime returns last modified time
@@ -94,24 +122,313 @@ func (o *ObjectInfo) Storable() bool {
 // ==================== Interface fs.Object ====================
 
 // SetModTime sets the metadata on the object to set the modification date
-func (o *ObjectInfo) SetModTime(ctx context.Context, t time.Time) error {
-	return fs.ErrorCantSetModTime
+//
+// In read_write mode the time is changed in the staged snapshot
+// keeping the same object ID, so nothing is uploaded.
+func (o *Object) SetModTime(ctx context.Context, t time.Time) error {
+	if !o.fs.opt.ReadWrite || o.special || o.entry == nil || (o.entry.Type != "f" && o.entry.Type != "") {
+		return fs.ErrorCantSetModTime
+	}
+	err := o.fs.stageEntry(ctx, o.remote, Entry{
+		Type:  o.entry.Type,
+		Size:  o.size,
+		MTime: t,
+		Obj:   o.id,
+	}, nil)
+	if err != nil {
+		return err
+	}
+	o.modTime = t
+	return nil
 }
 
 // Open opens the file for read.  Call Close() on the returned io.ReadCloser
 func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (reader io.ReadCloser, err error) {
-	var resp *http.Response
-	err = o.fs.pacer.Call(func() (bool, error) {
-		resp, err = o.fs.srv.CallJSON(ctx, &rest.Opts{
-			Method: "GET",
-			Path:   fmt.Sprintf("/api/v1/objects/%s", o.id),
-		}, nil, nil)
-		return o.fs.shouldRetry(ctx, resp, err)
-	})
+	fs.FixRangeOption(options, o.size)
+	in, err := o.open(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+	if o.fs.hashes.Count() > 0 && !isPartialRead(options) {
+		in = newHashingReader(o, in)
+	}
+	return in, nil
+}
+
+// isPartialRead returns true if options request less than the whole object
+func isPartialRead(options []fs.OpenOption) bool {
+	for _, option := range options {
+		switch option.(type) {
+		case *fs.RangeOption, *fs.SeekOption:
+			return true
+		}
+	}
+	return false
+}
+
+// open the object for reading without any processing
+func (o *Object) open(ctx context.Context, options ...fs.OpenOption) (reader io.ReadCloser, err error) {
+	if o.special {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	resp, cancel, err := o.get(ctx, options...)
 	if err != nil {
 		return nil, err
 	}
-	return resp.Body, nil
+	reader = &cancelReadCloser{ReadCloser: resp.Body, cancel: cancel}
+	reader = newResumingReader(ctx, o, reader, resp, options)
+	if o.fs.opt.VerifySizes {
+		want := o.size
+		if resp.StatusCode == http.StatusPartialContent {
+			want = expectedLength(options, o.size)
+		}
+		reader = &sizeCheckingReader{ReadCloser: reader, o: o, want: want}
+	}
+	if o.fs.opt.VerifyContent && !isPartialRead(options) {
+		newHash, err := o.fs.contentHasher(ctx)
+		if err != nil {
+			_ = reader.Close()
+			return nil, err
+		}
+		reader = newContentCheckingReader(o, reader, newHash)
+	}
+	return reader, nil
+}
+
+// get starts a download of the object, through the server's mount of
+// the snapshot if mount_reads is set and it can be used.
+//
+// On success the caller must close resp.Body then call cancel.
+func (o *Object) get(ctx context.Context, options ...fs.OpenOption) (resp *http.Response, cancel context.CancelFunc, err error) {
+	if rootID, u := o.mountPath(ctx); u != "" {
+		resp, cancel, err = o.request(ctx, &rest.Opts{
+			Method:  "GET",
+			RootURL: u,
+			Options: options,
+		})
+		if err == nil && resp.StatusCode == http.StatusOK && resp.ContentLength >= 0 && resp.ContentLength != o.size {
+			// the file at the path has changed since it was listed
+			_ = resp.Body.Close()
+			cancel()
+			err = fmt.Errorf("mount returned %d bytes, expecting %d", resp.ContentLength, o.size)
+		} else if err != nil && resp == nil {
+			o.fs.mountFailed(rootID)
+		}
+		if err == nil {
+			return resp, cancel, nil
+		}
+		fs.Debugf(o, "Reading through the API as reading through the mount failed: %v", err)
+	}
+	err = o.fs.retryRestart(ctx, func() error {
+		return o.fs.call(ctx, func() (bool, error) {
+			var reqErr error
+			resp, cancel, reqErr = o.request(ctx, &rest.Opts{
+				Method:  "GET",
+				Path:    fmt.Sprintf("/api/v1/objects/%s", o.id),
+				Options: options,
+			})
+			retry, err := o.fs.shouldRetry(ctx, resp, reqErr)
+			if reqErr == nil && err != nil {
+				// the download started but won't be used, such as if
+				// ctx was cancelled as the response arrived
+				_ = resp.Body.Close()
+				cancel()
+			}
+			return retry, err
+		})
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, cancel, nil
+}
+
+// request makes a single request for the object contents, applying the
+// request timeout to waiting for the response headers.
+//
+// On success the caller must close resp.Body then call cancel. On
+// error resp may be set if the server responded.
+func (o *Object) request(ctx context.Context, opts *rest.Opts) (resp *http.Response, cancel context.CancelFunc, err error) {
+	var reqCtx context.Context
+	reqCtx, cancel = context.WithCancel(ctx)
+	var timer *time.Timer
+	if o.fs.opt.RequestTimeout > 0 {
+		timer = time.AfterFunc(time.Duration(o.fs.opt.RequestTimeout), cancel)
+	}
+	resp, err = o.fs.srv.CallJSON(reqCtx, opts, nil, nil)
+	if timer != nil && !timer.Stop() {
+		if err == nil {
+			// timed out just as the headers arrived
+			_ = resp.Body.Close()
+		}
+		resp = nil
+		err = fmt.Errorf("no response within request timeout %v", o.fs.opt.RequestTimeout)
+	}
+	if err != nil {
+		cancel()
+	}
+	return resp, cancel, err
+}
+
+// errNoContentRange is returned if a partial response has no Content-Range
+var errNoContentRange = errors.New("server didn't return Content-Range")
+
+// remoteSize finds the size of the object as stored on the server
+// without downloading it.
+func (o *Object) remoteSize(ctx context.Context) (size int64, err error) {
+	var options []fs.OpenOption
+	if o.size > 0 {
+		options = append(options, &fs.RangeOption{Start: 0, End: 0})
+	}
+	resp, cancel, err := o.get(ctx, options...)
+	if err != nil {
+		return -1, err
+	}
+	defer cancel()
+	defer fs.CheckClose(resp.Body, &err)
+	if resp.StatusCode == http.StatusPartialContent {
+		// Content-Range: bytes 0-0/1234
+		contentRange := resp.Header.Get("Content-Range")
+		i := strings.LastIndex(contentRange, "/")
+		if i < 0 {
+			return -1, errNoContentRange
+		}
+		return strconv.ParseInt(contentRange[i+1:], 10, 64)
+	}
+	if resp.ContentLength >= 0 {
+		return resp.ContentLength, nil
+	}
+	return io.Copy(io.Discard, resp.Body)
+}
+
+// expectedLength returns the number of bytes a read of an object of
+// size with options should return
+func expectedLength(options []fs.OpenOption, size int64) int64 {
+	for _, option := range options {
+		switch x := option.(type) {
+		case *fs.RangeOption:
+			offset, limit := x.Decode(size)
+			if limit < 0 || offset+limit > size {
+				return size - offset
+			}
+			return limit
+		case *fs.SeekOption:
+			return size - x.Offset
+		}
+	}
+	return size
+}
+
+// sizeCheckingReader returns an error at EOF if the wrong number of
+// bytes were read
+type sizeCheckingReader struct {
+	io.ReadCloser
+	o    *Object
+	want int64
+	got  int64
+}
+
+// Read bytes checking the total at EOF
+func (r *sizeCheckingReader) Read(p []byte) (n int, err error) {
+	n, err = r.ReadCloser.Read(p)
+	r.got += int64(n)
+	if err == io.EOF && r.got != r.want {
+		err = fmt.Errorf("%v: read %d bytes expecting %d: %w", r.o, r.got, r.want, io.ErrUnexpectedEOF)
+	}
+	return n, err
+}
+
+// resumingReader reads a download, asking for the rest of it again if
+// the connection fails part way through, as reading an object can be
+// repeated.
+type resumingReader struct {
+	ctx   context.Context
+	o     *Object
+	in    io.ReadCloser
+	start int64 // offset in the object of the first byte wanted
+	limit int64 // number of bytes wanted or -1 for the rest
+	read  int64 // number of bytes read so far
+	tries int   // number of times the download has been resumed
+}
+
+// newResumingReader makes a resumingReader for in, the body of resp
+// which was requested with options
+func newResumingReader(ctx context.Context, o *Object, in io.ReadCloser, resp *http.Response, options []fs.OpenOption) *resumingReader {
+	r := &resumingReader{ctx: ctx, o: o, in: in, limit: -1}
+	if resp.StatusCode != http.StatusPartialContent {
+		// the server sent the whole object
+		return r
+	}
+	for _, option := range options {
+		switch x := option.(type) {
+		case *fs.RangeOption:
+			r.start, r.limit = x.Decode(o.size)
+		case *fs.SeekOption:
+			r.start, r.limit = x.Offset, -1
+		}
+	}
+	return r
+}
+
+// Read bytes resuming the download on errors which may not happen again
+func (r *resumingReader) Read(p []byte) (n int, err error) {
+	for {
+		n, err = r.in.Read(p)
+		r.read += int64(n)
+		if err == nil || err == io.EOF {
+			return n, err
+		}
+		if resumeErr := r.resume(err); resumeErr != nil {
+			return n, resumeErr
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// resume starts reading again where the download failed with readErr,
+// returning an error if it can't.
+func (r *resumingReader) resume(readErr error) error {
+	if r.ctx.Err() != nil || !fserrors.ShouldRetry(readErr) || r.tries >= fs.GetConfig(r.ctx).LowLevelRetries {
+		return readErr
+	}
+	r.tries++
+	offset := r.start + r.read
+	fs.Debugf(r.o, "Resuming download at offset %d after error: %v", offset, readErr)
+	_ = r.in.Close()
+	option := &fs.RangeOption{Start: offset, End: -1}
+	if r.limit >= 0 {
+		option.End = r.start + r.limit - 1
+	}
+	resp, cancel, err := r.o.get(r.ctx, option)
+	if err != nil {
+		return fmt.Errorf("failed to resume download after %v: %w", readErr, err)
+	}
+	if resp.StatusCode != http.StatusPartialContent && offset != 0 {
+		_ = resp.Body.Close()
+		cancel()
+		return fmt.Errorf("failed to resume download after %v: server returned status %d not a partial response", readErr, resp.StatusCode)
+	}
+	r.in = &cancelReadCloser{ReadCloser: resp.Body, cancel: cancel}
+	return nil
+}
+
+// Close the download
+func (r *resumingReader) Close() error {
+	return r.in.Close()
+}
+
+// cancelReadCloser cancels the request context when closed
+type cancelReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close the body and release the request context
+func (c *cancelReadCloser) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
 }
 
 // Update in to the object with the modTime given of the given size
@@ -119,13 +436,19 @@ func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (reader io.
 // When called from outside an Fs by rclone, src.Size() will always be >= 0.
 // But for unknown-sized objects (indicated by src.Size() == -1), Upload should either
 // return an error or update the object properly (rather than e.g. calling panic).
-func (o *ObjectInfo) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
-	return fs.ErrorPermissionDenied
+func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	newO, err := o.fs.upload(ctx, o.remote, in, src)
+	if err != nil {
+		return err
+	}
+	o.ObjectInfo = newO.ObjectInfo
+	o.mimeType, o.special = "", false
+	return nil
 }
 
 // Remove this object
-func (o *ObjectInfo) Remove(ctx context.Context) error {
-	return fs.ErrorPermissionDenied
+func (o *Object) Remove(ctx context.Context) error {
+	return o.fs.remove(ctx, o.remote)
 }
 
 // ==================== Optional Interface fs.IDer ====================
@@ -134,3 +457,48 @@ func (o *ObjectInfo) Remove(ctx context.Context) error {
 func (o *ObjectInfo) ID() string {
 	return o.id
 }
+
+// ==================== Optional Interface fs.ParentIDer ====================
+
+// ParentID returns the ID of the parent directory if known or "" if not
+func (o *ObjectInfo) ParentID() string {
+	return o.parentID
+}
+
+// defaultMimeType is returned for objects with an unknown extension
+const defaultMimeType = "application/octet-stream"
+
+// MimeType returns the content type of the Object if known, or ""
+// if not
+//
+// This is worked out from the extension, or if that is unknown and
+// sniff_mime_type is set, from the first bytes of the content.
+func (o *Object) MimeType(ctx context.Context) string {
+	mimeType := fs.MimeTypeFromName(o.remote)
+	if mimeType != defaultMimeType || !o.fs.opt.SniffMimeType || o.size == 0 {
+		return mimeType
+	}
+	if o.mimeType == "" {
+		sniffed, err := o.sniffMimeType(ctx)
+		if err != nil {
+			fs.Debugf(o, "Failed to detect MIME type: %v", err)
+			return mimeType
+		}
+		o.mimeType = sniffed
+	}
+	return o.mimeType
+}
+
+// sniffMimeType reads the start of the object to detect its MIME type
+func (o *Object) sniffMimeType(ctx context.Context) (mimeType string, err error) {
+	in, err := o.open(ctx, &fs.RangeOption{Start: 0, End: 511})
+	if err != nil {
+		return "", err
+	}
+	defer fs.CheckClose(in, &err)
+	buf, err := io.ReadAll(io.LimitReader(in, 512))
+	if err != nil {
+		return "", err
+	}
+	return http.DetectContentType(buf), nil
+}
@@ -0,0 +1,166 @@
+package kopia
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/rc"
+)
+
+func init() {
+	rc.Add(rc.Call{
+		Path:         "kopia/refresh",
+		AuthRequired: true,
+		Fn:           rcRefresh,
+		Title:        "Look for a newer snapshot of a kopia remote",
+		Help: `This takes the following parameters:
+
+- fs - a kopia remote name string e.g. "kopia:"
+
+It reads the snapshot list from the server straight away, rather than
+waiting for --kopia-dir-cache-time to expire, and switches to the
+latest snapshot if it has changed.
+
+It returns the ID and root object ID of the snapshot now in use.
+`,
+	})
+	rc.Add(rc.Call{
+		Path:         "kopia/set-snapshot",
+		AuthRequired: true,
+		Fn:           rcSetSnapshot,
+		Title:        "Switch a kopia remote to a different snapshot",
+		Help: `This takes the following parameters:
+
+- fs - a kopia remote name string e.g. "kopia:"
+- snapshot - the snapshot to show
+
+This changes the snapshot shown by a remote which is in use, for
+example by "rclone mount" or "rclone serve", without restarting it.
+The snapshot can be a snapshot ID, a root object ID, "pin" or
+"latest".
+
+A mount or serve using the remote is told the whole tree has changed
+and reads the directories again from the new snapshot, so history can
+be browsed in a file manager without unmounting.
+
+It returns the ID and root object ID of the snapshot now in use.
+`,
+	})
+	rc.Add(rc.Call{
+		Path:         "kopia/stats",
+		AuthRequired: true,
+		Fn:           rcStats,
+		Title:        "Show the state of a kopia remote",
+		Help: `This takes the following parameters:
+
+- fs - a kopia remote name string e.g. "kopia:"
+
+It returns the snapshot in use, when the snapshot list was last read,
+the number of checksums cached and, in read_write mode, the number of
+changes staged for the next snapshot.
+`,
+	})
+}
+
+// rcFs returns the kopia remote named in the "fs" parameter
+func rcFs(ctx context.Context, in rc.Params) (*Fs, error) {
+	f, err := rc.GetFs(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	kf, ok := f.(*Fs)
+	if !ok {
+		return nil, fmt.Errorf("%v is not a kopia remote", f)
+	}
+	return kf, nil
+}
+
+// rcSnapshot returns the snapshot f is showing
+func (f *Fs) rcSnapshot() rc.Params {
+	rootID, snapshotID, _ := f.currentRoot()
+	return rc.Params{
+		"snapshot": snapshotID,
+		"rootID":   rootID,
+	}
+}
+
+// rcRefresh revalidates the snapshot list now
+func rcRefresh(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	f, err := rcFs(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.getRootId(ctx); err != nil && !errors.Is(err, errNoSnapshots) {
+		return nil, err
+	}
+	f.rootMu.Lock()
+	f.revalidateRoot(ctx)
+	f.rootMu.Unlock()
+	return f.rcSnapshot(), nil
+}
+
+// rcSetSnapshot switches f to a different snapshot
+func rcSetSnapshot(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	f, err := rcFs(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := in.GetString("snapshot")
+	if err != nil {
+		return nil, err
+	}
+	if f.opt.ReadWrite && spec != "" && spec != "latest" {
+		return nil, errors.New("can't use read_write with a fixed snapshot")
+	}
+	result, etag, _, err := f.fetchSnapshots(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	// the option takes root object IDs rather than snapshot IDs
+	selector := spec
+	for _, s := range result.Snapshots {
+		if s.ID == spec {
+			selector = s.RootID
+			break
+		}
+	}
+	old := f.opt.Snapshot
+	f.opt.Snapshot = selector
+	snapshot := f.selectSnapshot(result)
+	if snapshot == nil {
+		f.opt.Snapshot = old
+		return nil, fmt.Errorf("snapshot %q not found", spec)
+	}
+	fs.Infof(f, "Switching to snapshot %s", snapshot.ID)
+	if f.setRoot(snapshot.RootID, snapshot.ID, etag) {
+		f.rootChanged()
+	}
+	return f.rcSnapshot(), nil
+}
+
+// rcStats returns the state of f
+func rcStats(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	f, err := rcFs(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	out = f.rcSnapshot()
+	_, _, lastRefresh := f.currentRoot()
+	out["lastRefresh"] = lastRefresh
+	out["readWrite"] = f.opt.ReadWrite
+	f.hashCache.mu.Lock()
+	out["cachedHashes"] = len(f.hashCache.hashes)
+	f.hashCache.mu.Unlock()
+	if f.opt.ReadWrite {
+		f.stageMu.Lock()
+		changes := 0
+		if f.staged != nil {
+			changes = f.staged.changes
+		}
+		f.stageMu.Unlock()
+		out["stagedChanges"] = changes
+	}
+	return out, nil
+}
@@ -0,0 +1,225 @@
+package kopia
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	gohash "hash"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/rest"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/blake2s"
+	"golang.org/x/crypto/sha3"
+)
+
+// errContentMismatch is returned when data doesn't hash to the
+// content ID it was stored under
+var errContentMismatch = errors.New("data doesn't match its content ID")
+
+// truncatedHash truncates the sum of a hash to size bytes
+type truncatedHash struct {
+	gohash.Hash
+	size int
+}
+
+// Sum appends the truncated sum to b
+func (h truncatedHash) Sum(b []byte) []byte {
+	sum := h.Hash.Sum(nil)
+	return append(b, sum[:h.size]...)
+}
+
+// Size returns the truncated size
+func (h truncatedHash) Size() int {
+	return h.size
+}
+
+// newRepoHashFunc returns a constructor for the keyed hash algorithm
+// used by kopia to compute content IDs.
+func newRepoHashFunc(algorithm string, secret []byte) (func() gohash.Hash, error) {
+	truncate := func(newHash func() gohash.Hash, size int) func() gohash.Hash {
+		return func() gohash.Hash {
+			return truncatedHash{Hash: newHash(), size: size}
+		}
+	}
+	keyed := func(newHash func([]byte) (gohash.Hash, error)) func() gohash.Hash {
+		return func() gohash.Hash {
+			h, err := newHash(secret)
+			if err != nil {
+				panic(err) // key length is checked below
+			}
+			return h
+		}
+	}
+	hmacOf := func(newHash func() gohash.Hash) func() gohash.Hash {
+		return func() gohash.Hash {
+			return hmac.New(newHash, secret)
+		}
+	}
+	switch strings.ToUpper(algorithm) {
+	case "HMAC-SHA256":
+		return hmacOf(sha256.New), nil
+	case "HMAC-SHA256-128":
+		return truncate(hmacOf(sha256.New), 16), nil
+	case "HMAC-SHA224":
+		return hmacOf(sha256.New224), nil
+	case "HMAC-SHA3-224":
+		return hmacOf(sha3.New224), nil
+	case "HMAC-SHA3-256":
+		return hmacOf(sha3.New256), nil
+	}
+	if len(secret) > 32 {
+		return nil, fmt.Errorf("hmac secret too long for %s", algorithm)
+	}
+	switch strings.ToUpper(algorithm) {
+	case "BLAKE2B-256":
+		return keyed(blake2b.New256), nil
+	case "BLAKE2B-256-128":
+		return truncate(keyed(blake2b.New256), 16), nil
+	case "BLAKE2S-128":
+		return keyed(blake2s.New128), nil
+	case "BLAKE2S-256":
+		return keyed(blake2s.New256), nil
+	}
+	return nil, fmt.Errorf("unsupported repository hash algorithm %q", algorithm)
+}
+
+// getRepoStatus reads the repository status from the server
+func (f *Fs) getRepoStatus(ctx context.Context) (status *RepoStatus, err error) {
+	status = &RepoStatus{}
+	err = f.callJSON(ctx, &rest.Opts{
+		Method: "GET",
+		Path:   "/api/v1/repo/status",
+	}, nil, status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read repository status: %w", err)
+	}
+	return status, nil
+}
+
+// getRepoParameters reads the repository parameters from the server
+func (f *Fs) getRepoParameters(ctx context.Context) (params *RepoParameters, err error) {
+	params = &RepoParameters{}
+	err = f.callJSON(ctx, &rest.Opts{
+		Method: "GET",
+		Path:   "/api/v1/repo/parameters",
+	}, nil, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read repository parameters: %w", err)
+	}
+	return params, nil
+}
+
+// setupRepoHash reads the hash the repository uses to make content
+// IDs so they can be computed and verified
+func (f *Fs) setupRepoHash(ctx context.Context) (err error) {
+	status := f.serverStatus
+	if status == nil {
+		status, err = f.getRepoStatus(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	params, err := f.getRepoParameters(ctx)
+	var apiErr *ErrorResponse
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: it doesn't give the repository parameters needed for verify_content and read_write: %w", errServerTooOld, err)
+	}
+	if err != nil {
+		return err
+	}
+	algorithm := params.HashFunction
+	if algorithm == "" {
+		algorithm = status.Hash
+	}
+	newHash, err := newRepoHashFunc(algorithm, params.HMACSecret)
+	if err != nil {
+		return err
+	}
+	f.repoHash = newHash
+	f.canCompress = params.SupportsContentCompression || status.SupportsContentCompression
+	fs.Debugf(f, "Using repository hash %s", algorithm)
+	return nil
+}
+
+// contentHasher returns the repository hash, reading it from the
+// server the first time it is needed
+func (f *Fs) contentHasher(ctx context.Context) (func() gohash.Hash, error) {
+	f.repoHashMu.Lock()
+	defer f.repoHashMu.Unlock()
+	if f.repoHash == nil {
+		if err := f.setupRepoHash(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return f.repoHash, nil
+}
+
+// contentIDHash returns the repository hash of an object if it can be
+// read directly from the object ID, which is the case when the object
+// is stored as a single content.
+//
+// Objects compressed by kopia before they were stored, which have IDs
+// starting with "Z", have the hash of the compressed data so aren't
+// included.
+func contentIDHash(id string) (string, bool) {
+	if id == "" || id[0] == 'I' || id[0] == 'Z' {
+		return "", false
+	}
+	// content ID prefixes are a single letter in g-z
+	if c := id[0]; c >= 'g' && c <= 'z' {
+		id = id[1:]
+	}
+	for _, c := range id {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return "", false
+		}
+	}
+	return id, len(id) > 0
+}
+
+// repoSum returns the repository hash of the data read from in
+func repoSum(newHash func() gohash.Hash, in io.Reader) (string, error) {
+	h := newHash()
+	if _, err := io.Copy(h, in); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// contentCheckingReader returns an error at EOF if the data read
+// doesn't hash to the content ID of the object
+type contentCheckingReader struct {
+	io.ReadCloser
+	o    *Object
+	h    gohash.Hash
+	want string
+}
+
+// newContentCheckingReader wraps in to check the data read from o
+// against its content ID, if it has one
+func newContentCheckingReader(o *Object, in io.ReadCloser, newHash func() gohash.Hash) io.ReadCloser {
+	want, ok := contentIDHash(o.id)
+	if !ok {
+		return in
+	}
+	return &contentCheckingReader{ReadCloser: in, o: o, h: newHash(), want: want}
+}
+
+// Read bytes checking the hash at EOF
+func (r *contentCheckingReader) Read(p []byte) (n int, err error) {
+	n, err = r.ReadCloser.Read(p)
+	_, _ = r.h.Write(p[:n])
+	if err == io.EOF {
+		if got := hex.EncodeToString(r.h.Sum(nil)); got != r.want {
+			err = fmt.Errorf("%v: read data with hash %s: %w", r.o, got, errContentMismatch)
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,46 @@
+package kopia
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/rclone/rclone/lib/rest"
+)
+
+// repoStatusReport is the output of the repo-status command
+type repoStatusReport struct {
+	*RepoStatus
+	Clients []string `json:"clients"` // user@host of the clients with sources in the repository
+}
+
+// repoStatus returns the repository details the server reports along
+// with the clients which have snapshot sources in it
+func (f *Fs) repoStatus(ctx context.Context) (*repoStatusReport, error) {
+	status, err := f.getRepoStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var sources SourcesResponse
+	err = f.callJSON(ctx, &rest.Opts{
+		Method: "GET",
+		Path:   "/api/v1/sources",
+	}, nil, &sources)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sources: %w", err)
+	}
+	report := &repoStatusReport{
+		RepoStatus: status,
+		Clients:    []string{},
+	}
+	seen := map[string]bool{}
+	for _, source := range sources.Sources {
+		client := source.Source.UserName + "@" + source.Source.Host
+		if !seen[client] {
+			seen[client] = true
+			report.Clients = append(report.Clients, client)
+		}
+	}
+	sort.Strings(report.Clients)
+	return report, nil
+}
@@ -0,0 +1,141 @@
+package kopia
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/lib/pacer"
+)
+
+// codeNotConnected is the error code the server returns while it
+// isn't connected to the repository, such as just after it starts
+const codeNotConnected = "NOT_CONNECTED"
+
+// isRestartError returns true if err suggests the server restarted or
+// dropped the connection while the call was being made.
+func isRestartError(err error) bool {
+	var apiErr *ErrorResponse
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == codeNotConnected || apiErr.StatusCode == http.StatusBadGateway
+	}
+	return fserrors.ShouldRetry(err)
+}
+
+// afterRestart waits for the server to answer again after it may have
+// restarted by reading the snapshot list, then forgets anything which
+// doesn't survive a restart.
+//
+// The root found earlier is kept even if its snapshot has gone, as
+// object IDs are stable and the objects are kept until the repository
+// is maintained.
+func (f *Fs) afterRestart(ctx context.Context) error {
+	f.rootMu.Lock()
+	defer f.rootMu.Unlock()
+	result, _, _, err := f.fetchSnapshots(ctx, "")
+	if err != nil {
+		return err
+	}
+	if f.rootId != "" && !slices.ContainsFunc(result.Snapshots, func(s Snapshot) bool { return s.RootID == f.rootId }) {
+		fs.Logf(f, "Snapshot %s is no longer listed after the server restarted - still reading from it", f.snapshotId)
+	}
+	// mounts are made again if used
+	f.mountMu.Lock()
+	f.mounts = nil
+	f.mountMu.Unlock()
+	return nil
+}
+
+// retryRestart calls fn and, if it fails because the server restarted,
+// calls it again once the server answers.
+func (f *Fs) retryRestart(ctx context.Context, fn func() error) error {
+	err := fn()
+	if err == nil || ctx.Err() != nil || !isRestartError(err) {
+		return err
+	}
+	fs.Infof(f, "Server may have restarted - checking the snapshot root before trying again: %v", err)
+	if restartErr := f.afterRestart(ctx); restartErr != nil {
+		fs.Debugf(f, "Server didn't come back: %v", restartErr)
+		return err
+	}
+	return fn()
+}
+
+// errMaintenance is returned if the server is still unavailable after
+// waiting maintenance_wait for it
+var errMaintenance = errors.New("kopia server is in maintenance or upgrading")
+
+// maintenanceSleep is the first wait for the server to come out of
+// maintenance, doubled after each attempt up to maxMaintenanceSleep
+var maintenanceSleep = time.Second
+
+// maxMaintenanceSleep is the longest wait between attempts
+const maxMaintenanceSleep = time.Minute
+
+// isMaintenance returns true if err is the server saying it is
+// unavailable, as it does during maintenance and upgrades.
+func isMaintenance(err error) bool {
+	var apiErr *ErrorResponse
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusServiceUnavailable
+}
+
+// retryAfter returns the wait asked for by the Retry-After header of
+// resp, or 0 if there isn't one.
+func retryAfter(resp *http.Response) time.Duration {
+	s := resp.Header.Get("Retry-After")
+	if s == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(s); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if t, err := http.ParseTime(s); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
+
+// call makes an API call with fn through the pacer.
+//
+// While the server is in maintenance the call is made again after
+// increasing waits, or the wait the server asks for, for up to
+// maintenance_wait.
+func (f *Fs) call(ctx context.Context, fn pacer.Paced) error {
+	limit := time.Duration(f.opt.MaintenanceWait)
+	deadline := time.Now().Add(limit)
+	sleep := maintenanceSleep
+	for try := 1; ; try++ {
+		err := f.pacer.CallContext(ctx, fn)
+		if !isMaintenance(err) {
+			return err
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			// the sync may work if retried later
+			return fserrors.RetryError(fmt.Errorf("%w - still unavailable after waiting %v: %w", errMaintenance, limit, err))
+		}
+		wait := sleep
+		var apiErr *ErrorResponse
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+			wait = apiErr.RetryAfter
+		}
+		wait = min(wait, remaining)
+		if try == 1 {
+			fs.Logf(f, "Server is unavailable, probably for maintenance - waiting up to %v for it: %v", limit, err)
+		} else {
+			fs.Debugf(f, "Server still unavailable - trying again in %v", wait)
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		sleep = min(2*sleep, maxMaintenanceSleep)
+	}
+}
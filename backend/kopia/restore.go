@@ -0,0 +1,145 @@
+package kopia
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/rest"
+)
+
+// taskPollInterval is how often running server tasks are checked
+var taskPollInterval = time.Second
+
+// boolOption reads the boolean option name from opt. Giving the option
+// with no value sets it.
+func boolOption(opt map[string]string, name string) (bool, error) {
+	s, ok := opt[name]
+	if !ok {
+		return false, nil
+	}
+	if s == "" {
+		return true, nil
+	}
+	value, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, fmt.Errorf("bad %s option: %w", name, err)
+	}
+	return value, nil
+}
+
+// pathObjectID returns the object ID of the file or directory at
+// remote, or the root if remote is ""
+func (f *Fs) pathObjectID(ctx context.Context, remote string) (string, error) {
+	if remote == "" {
+		remote = f.rootFile
+	}
+	full := cleanPath(path.Join(f.root, remote))
+	if full == "" {
+		return f.getRootId(ctx)
+	}
+	obj, err := f.newObject(ctx, full)
+	if err != nil {
+		return "", err
+	}
+	id := obj.(fs.IDer).ID()
+	if id == "" {
+		return "", fmt.Errorf("%q has uncommitted changes - commit them first", full)
+	}
+	return id, nil
+}
+
+// startRestore starts a restore task on the server
+func (f *Fs) startRestore(ctx context.Context, req *RestoreRequest) (*TaskInfo, error) {
+	var task TaskInfo
+	err := f.callJSON(ctx, &rest.Opts{
+		Method: "POST",
+		Path:   "/api/v1/restore",
+	}, req, &task)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start restore: %w", err)
+	}
+	return &task, nil
+}
+
+// waitTask polls the task until it has finished, returning an error
+// if it didn't succeed
+func (f *Fs) waitTask(ctx context.Context, task *TaskInfo) (*TaskInfo, error) {
+	ticker := time.NewTicker(taskPollInterval)
+	defer ticker.Stop()
+	for task.Status == "RUNNING" || task.Status == "CANCELING" {
+		select {
+		case <-ctx.Done():
+			return task, ctx.Err()
+		case <-ticker.C:
+		}
+		id := task.ID
+		task = &TaskInfo{}
+		err := f.callJSON(ctx, &rest.Opts{
+			Method: "GET",
+			Path:   fmt.Sprintf("/api/v1/tasks/%s", id),
+		}, nil, task)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read task %s: %w", id, err)
+		}
+	}
+	if task.Status != "SUCCESS" {
+		msg := task.ErrorMessage
+		if msg == "" {
+			msg = "status " + task.Status
+		}
+		return task, fmt.Errorf("task %s failed: %s", task.ID, msg)
+	}
+	return task, nil
+}
+
+// restore runs a restore of remote on the server to the dest option
+func (f *Fs) restore(ctx context.Context, remote string, opt map[string]string) (*TaskInfo, error) {
+	dest := opt["dest"]
+	if dest == "" {
+		return nil, errors.New("need -o dest=path on the server to restore to")
+	}
+	out := &FilesystemOutput{
+		TargetPath:           dest,
+		OverwriteDirectories: true,
+	}
+	req := &RestoreRequest{Filesystem: out}
+	var err error
+	for _, flag := range []struct {
+		name  string
+		value *bool
+	}{
+		{"overwrite", &out.OverwriteFiles},
+		{"skip-owners", &out.SkipOwners},
+		{"skip-permissions", &out.SkipPermissions},
+		{"skip-times", &out.SkipTimes},
+		{"incremental", &req.Options.Incremental},
+		{"ignore-errors", &req.Options.IgnoreErrors},
+	} {
+		if *flag.value, err = boolOption(opt, flag.name); err != nil {
+			return nil, err
+		}
+	}
+	out.OverwriteSymlinks = out.OverwriteFiles
+	async, err := boolOption(opt, "async")
+	if err != nil {
+		return nil, err
+	}
+	req.Root, err = f.pathObjectID(ctx, remote)
+	if err != nil {
+		return nil, err
+	}
+	task, err := f.startRestore(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	fs.Infof(f, "Started restore task %s of %s to %q on the server", task.ID, req.Root, dest)
+	if async {
+		return task, nil
+	}
+	return f.waitTask(ctx, task)
+}
@@ -0,0 +1,173 @@
+package kopia
+
+import (
+	"context"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// snapshotDirFormat is the format of the directory names of the
+// snapshots with snapshot_dirs. It is the one "kopia mount" uses.
+const snapshotDirFormat = "20060102-150405"
+
+// layoutTimeMachine shows the snapshots as year/month/day/HHMMSS
+const layoutTimeMachine = "time-machine"
+
+// snapshotLayout returns the number of levels of directories which
+// lead to the snapshots in the root, or 0 if the root is a snapshot
+func (f *Fs) snapshotLayout() int {
+	switch {
+	case f.opt.Layout == layoutTimeMachine:
+		return 4
+	case f.opt.SnapshotDirs:
+		return 1
+	}
+	return 0
+}
+
+// isSnapshotDir returns true if remote is one of the directories
+// leading to the snapshots, rather than inside a snapshot
+func (f *Fs) isSnapshotDir(remote string) bool {
+	levels := f.snapshotLayout()
+	if levels == 0 {
+		return false
+	}
+	if remote == "" {
+		return true
+	}
+	return strings.Count(remote, "/")+1 < levels
+}
+
+// snapshotDirName returns the path of the directory of the snapshot
+// which started at t
+func (f *Fs) snapshotDirName(t time.Time) string {
+	t = t.UTC()
+	if f.opt.Layout == layoutTimeMachine {
+		return t.Format("2006/01/02/150405")
+	}
+	return t.Format(snapshotDirFormat)
+}
+
+// snapshotDirsListing returns the listing of the directory at remote
+// which leads to the snapshots of the source.
+//
+// With snapshot_dirs the root has a directory for each snapshot. With
+// the time-machine layout the snapshots are in year/month/day
+// directories, each with the time of the newest snapshot in it.
+func (f *Fs) snapshotDirsListing(ctx context.Context, remote string) (*dirListing, error) {
+	if err := f.readSnapshotDirs(ctx); err != nil {
+		return nil, err
+	}
+	f.listMu.Lock()
+	listing := f.snapshotDirListings[remote]
+	f.listMu.Unlock()
+	if listing == nil {
+		return nil, fs.ErrorDirNotFound
+	}
+	return listing, nil
+}
+
+// readSnapshotDirs makes the listings of the directories leading to
+// the snapshots if the snapshot list has changed
+func (f *Fs) readSnapshotDirs(ctx context.Context) error {
+	f.listMu.Lock()
+	old := f.rootListing
+	if old != nil && f.snapshotDirListings != nil && !f.expired(old.fetched) {
+		f.listMu.Unlock()
+		return nil
+	}
+	etag := ""
+	if old != nil && f.snapshotDirListings != nil {
+		etag = old.etag
+	}
+	f.listMu.Unlock()
+	result, newEtag, notModified, err := f.fetchSnapshots(ctx, etag)
+	if err != nil {
+		return err
+	}
+	if notModified {
+		f.listMu.Lock()
+		old.fetched = time.Now()
+		f.listMu.Unlock()
+		return nil
+	}
+	dirs := map[string][]Entry{"": {}}
+	names := make(map[string]string, 2*len(result.Snapshots))
+	seen := map[string]bool{}
+	for _, s := range result.Snapshots {
+		if slices.Contains(s.Retention, "incomplete") {
+			continue
+		}
+		name := f.snapshotDirName(s.StartTime)
+		if seen[name] {
+			name += "-" + s.ID
+		}
+		seen[name] = true
+		names[s.ID] = name
+		names[s.RootID] = name
+		parent, leaf := path.Split(name)
+		parent = strings.TrimSuffix(parent, "/")
+		dirs[parent] = append(dirs[parent], Entry{
+			Name:    leaf,
+			Type:    "d",
+			Mode:    "0755",
+			MTime:   s.StartTime,
+			Obj:     s.RootID,
+			Summary: s.Summary,
+		})
+		// add the year/month/day directories leading to it
+		for parent != "" {
+			dir, leaf := path.Split(parent)
+			dir = strings.TrimSuffix(dir, "/")
+			i := slices.IndexFunc(dirs[dir], func(e Entry) bool { return e.Name == leaf })
+			if i < 0 {
+				dirs[dir] = append(dirs[dir], Entry{Name: leaf, Type: "d", Mode: "0755"})
+				i = len(dirs[dir]) - 1
+			}
+			if s.StartTime.After(dirs[dir][i].MTime) {
+				dirs[dir][i].MTime = s.StartTime
+			}
+			parent = dir
+		}
+	}
+	listings := make(map[string]*dirListing, len(dirs))
+	for dir, entries := range dirs {
+		// the names are times so this sorts them oldest first
+		slices.SortFunc(entries, func(a, b Entry) int { return strings.Compare(a.Name, b.Name) })
+		listings[dir] = f.newDirListing(dir, "", "", entries)
+	}
+	listings[""].etag = newEtag
+	f.listMu.Lock()
+	f.rootListing = listings[""]
+	f.snapshotDirListings = listings
+	f.snapshotDirNames = names
+	f.listMu.Unlock()
+	return nil
+}
+
+// snapshotDirPath rewrites remote if its first element is the ID or
+// root object ID of a snapshot rather than the name of its directory
+// with snapshot_dirs.
+func (f *Fs) snapshotDirPath(ctx context.Context, remote string) string {
+	if f.snapshotLayout() == 0 || remote == "" {
+		return remote
+	}
+	if err := f.readSnapshotDirs(ctx); err != nil {
+		return remote
+	}
+	first, rest, _ := strings.Cut(remote, "/")
+	f.listMu.Lock()
+	name, ok := f.snapshotDirNames[first]
+	f.listMu.Unlock()
+	if !ok {
+		return remote
+	}
+	if rest == "" {
+		return name
+	}
+	return name + "/" + rest
+}
@@ -0,0 +1,212 @@
+package kopia
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// snapshotInfo describes a snapshot in the output of the snapshots
+// command
+type snapshotInfo struct {
+	ID          string    `json:"id"`
+	RootID      string    `json:"rootID"`
+	StartTime   time.Time `json:"startTime"`
+	EndTime     time.Time `json:"endTime"`
+	Description string    `json:"description,omitempty"`
+	Size        int64     `json:"size"`
+	Files       int       `json:"files"`
+	Dirs        int       `json:"dirs"`
+	Retention   []string  `json:"retention,omitempty"`
+	Pins        []string  `json:"pins,omitempty"`
+	Active      bool      `json:"active,omitempty"`
+}
+
+// snapshots lists the snapshots of the source, oldest first, marking
+// the one the remote is showing
+func (f *Fs) snapshots(ctx context.Context) ([]snapshotInfo, error) {
+	result, _, _, err := f.fetchSnapshots(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	if _, err := f.getRootId(ctx); err != nil && !errors.Is(err, errNoSnapshots) {
+		return nil, err
+	}
+	_, active, _ := f.currentRoot()
+	out := make([]snapshotInfo, 0, len(result.Snapshots))
+	for _, s := range result.Snapshots {
+		out = append(out, snapshotInfo{
+			ID:          s.ID,
+			RootID:      s.RootID,
+			StartTime:   s.StartTime,
+			EndTime:     s.EndTime,
+			Description: s.Description,
+			Size:        s.Summary.Size,
+			Files:       s.Summary.Files,
+			Dirs:        s.Summary.Dirs,
+			Retention:   s.Retention,
+			Pins:        s.Pins,
+			Active:      s.ID == active,
+		})
+	}
+	return out, nil
+}
+
+// diffReport is the output of the diff command
+type diffReport struct {
+	From    string   `json:"from"`
+	To      string   `json:"to"`
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Changed []string `json:"changed"`
+}
+
+// findSnapshot returns the index of the snapshot with the ID or root
+// object ID given by spec, or of the latest snapshot for "latest"
+func findSnapshot(snapshots []Snapshot, spec string) (int, error) {
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		s := &snapshots[i]
+		if spec == s.ID || spec == s.RootID || spec == "latest" {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("snapshot %q not found", spec)
+}
+
+// dirObjectID returns the object ID of the directory at remote in the
+// snapshot with root rootID, or "" if it isn't in the snapshot
+func (f *Fs) dirObjectID(ctx context.Context, rootID, remote string) (string, error) {
+	id := rootID
+	if remote == "" {
+		return id, nil
+	}
+	for _, name := range strings.Split(remote, "/") {
+		d, err := f.readDirObject(ctx, id)
+		if err != nil {
+			return "", err
+		}
+		_, e := f.find(d, name)
+		if e == nil {
+			return "", nil
+		}
+		if e.entry.Type != "d" {
+			return "", fs.ErrorIsFile
+		}
+		id = e.entry.Obj
+	}
+	return id, nil
+}
+
+// diff compares the directory at remote in two snapshots.
+//
+// opt["to"] is the snapshot to compare, by default the one the remote
+// is showing, and opt["from"] the one to compare it with, by default
+// the snapshot before it. Either may be a snapshot ID, a root object ID
+// or "latest".
+func (f *Fs) diff(ctx context.Context, remote string, opt map[string]string) (*diffReport, error) {
+	result, _, _, err := f.fetchSnapshots(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	to := opt["to"]
+	if to == "" {
+		if to, err = f.getRootId(ctx); err != nil {
+			return nil, err
+		}
+	}
+	toIndex, err := findSnapshot(result.Snapshots, to)
+	if err != nil {
+		return nil, err
+	}
+	fromIndex := toIndex - 1
+	if from := opt["from"]; from != "" {
+		if fromIndex, err = findSnapshot(result.Snapshots, from); err != nil {
+			return nil, err
+		}
+	} else if fromIndex < 0 {
+		return nil, errors.New("no earlier snapshot to compare with - use -o from")
+	}
+	fromSnapshot, toSnapshot := &result.Snapshots[fromIndex], &result.Snapshots[toIndex]
+	remote = cleanPath(path.Join(f.root, remote))
+	fromID, err := f.dirObjectID(ctx, fromSnapshot.RootID, remote)
+	if err != nil {
+		return nil, err
+	}
+	toID, err := f.dirObjectID(ctx, toSnapshot.RootID, remote)
+	if err != nil {
+		return nil, err
+	}
+	report := &diffReport{
+		From:    fromSnapshot.ID,
+		To:      toSnapshot.ID,
+		Added:   []string{},
+		Removed: []string{},
+		Changed: []string{},
+	}
+	if err := f.diffDir(ctx, report, remote, fromID, toID); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// diffDir adds the differences between the directory objects fromID
+// and toID at remote to report, skipping directories which haven't
+// changed.
+func (f *Fs) diffDir(ctx context.Context, report *diffReport, remote, fromID, toID string) error {
+	if fromID == toID {
+		return nil
+	}
+	from, err := f.readDirObject(ctx, fromID)
+	if err != nil {
+		return err
+	}
+	to, err := f.readDirObject(ctx, toID)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(from.entries)+len(to.entries))
+	for name := range from.entries {
+		names = append(names, name)
+	}
+	for name := range to.entries {
+		if _, ok := from.entries[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	// directories are shown with a trailing /
+	show := func(remote string, e *stagedEntry) string {
+		if e.entry.Type == "d" {
+			return remote + "/"
+		}
+		return remote
+	}
+	for _, name := range names {
+		a, b := from.entries[name], to.entries[name]
+		entryName, err := f.entryName(name)
+		if err != nil {
+			fs.Errorf(f, "Skipping entry in %q: %v", remote, err)
+			continue
+		}
+		entryRemote := path.Join(remote, entryName)
+		switch {
+		case a == nil:
+			report.Added = append(report.Added, show(entryRemote, b))
+		case b == nil:
+			report.Removed = append(report.Removed, show(entryRemote, a))
+		case a.entry.Type == "d" && b.entry.Type == "d":
+			if err := f.diffDir(ctx, report, entryRemote, a.entry.Obj, b.entry.Obj); err != nil {
+				return err
+			}
+		case a.entry.Type != b.entry.Type, a.entry.Obj != b.entry.Obj, a.entry.Mode != b.entry.Mode, !a.entry.MTime.Equal(b.entry.MTime):
+			report.Changed = append(report.Changed, show(entryRemote, b))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package kopia
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/rest"
+)
+
+// source returns the snapshot source f reads and writes
+func (f *Fs) source() SourceInfo {
+	return SourceInfo{Host: f.opt.Host, UserName: f.opt.User, Path: f.opt.Path}
+}
+
+// deleteSourceReport is the output of the delete-source command
+type deleteSourceReport struct {
+	Source    string   `json:"source"`
+	Snapshots []string `json:"snapshots"`
+	DryRun    bool     `json:"dryRun,omitempty"`
+}
+
+// deleteSource deletes every snapshot of the source along with the
+// source and its policy.
+//
+// opt["confirm"] must be the source as user@host:path so it can't be
+// done by accident.
+func (f *Fs) deleteSource(ctx context.Context, opt map[string]string) (*deleteSourceReport, error) {
+	if err := f.checkWritable(); err != nil {
+		return nil, err
+	}
+	source := f.source()
+	if opt["confirm"] != source.String() {
+		return nil, fmt.Errorf("this deletes every snapshot of %v - confirm with -o confirm=%q", source, source.String())
+	}
+	result, _, _, err := f.fetchSnapshots(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	report := &deleteSourceReport{
+		Source:    source.String(),
+		Snapshots: []string{},
+		DryRun:    fs.GetConfig(ctx).DryRun,
+	}
+	for _, snapshot := range result.Snapshots {
+		report.Snapshots = append(report.Snapshots, snapshot.ID)
+	}
+	if report.DryRun {
+		fs.Logf(f, "Not deleting source %v and its %d snapshots as --dry-run is set", source, len(report.Snapshots))
+		return report, nil
+	}
+	err = f.callJSON(ctx, &rest.Opts{
+		Method:     "POST",
+		Path:       "/api/v1/snapshots/delete",
+		NoResponse: true,
+	}, &DeleteSnapshotsRequest{
+		Source:                source,
+		SnapshotManifestIDs:   report.Snapshots,
+		DeleteSourceAndPolicy: true,
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete source %v: %w", source, err)
+	}
+	fs.Infof(f, "Deleted source %v and its %d snapshots", source, len(report.Snapshots))
+
+	// start again as a new source discarding anything staged
+	f.stageMu.Lock()
+	f.staged = nil
+	f.stageMu.Unlock()
+	f.setRoot("", "", "")
+	f.forgetRootListing()
+	return report, nil
+}
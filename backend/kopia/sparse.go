@@ -0,0 +1,83 @@
+package kopia
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// sparseReport is the output of the sparse command
+type sparseReport struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	Written int64  `json:"written"`
+	Skipped int64  `json:"skipped"`
+}
+
+// dataExtents returns the extents of o which aren't holes
+func (o *Object) dataExtents() (extents []Extent) {
+	var holes []Extent
+	if o.entry != nil {
+		holes = append(holes, o.entry.Holes...)
+	}
+	sort.Slice(holes, func(i, j int) bool { return holes[i].Start < holes[j].Start })
+	var offset int64
+	for _, hole := range holes {
+		if hole.Start > offset {
+			extents = append(extents, Extent{Start: offset, Length: hole.Start - offset})
+		}
+		offset = max(offset, hole.Start+hole.Length)
+	}
+	if offset < o.size {
+		extents = append(extents, Extent{Start: offset, Length: o.size - offset})
+	}
+	return extents
+}
+
+// sparse restores the object at remote to the local file dst,
+// leaving the holes in it unallocated
+func (f *Fs) sparse(ctx context.Context, remote string, dst string) (report *sparseReport, err error) {
+	obj, err := f.NewObject(ctx, remote)
+	if err != nil {
+		return nil, err
+	}
+	o := obj.(*Object)
+	out, err := os.Create(dst)
+	if err != nil {
+		return nil, err
+	}
+	defer fs.CheckClose(out, &err)
+	report = &sparseReport{Path: remote, Size: o.size}
+	for _, extent := range o.dataExtents() {
+		n, err := o.writeExtent(ctx, out, extent)
+		report.Written += n
+		if err != nil {
+			return nil, fmt.Errorf("failed to restore %d bytes at %d: %w", extent.Length, extent.Start, err)
+		}
+	}
+	// extend the file to its full size, leaving any trailing hole
+	if err = out.Truncate(o.size); err != nil {
+		return nil, err
+	}
+	report.Skipped = o.size - report.Written
+	fs.Infof(f, "sparse: %s restored %d bytes, skipped %d bytes of holes", remote, report.Written, report.Skipped)
+	return report, nil
+}
+
+// writeExtent copies extent of o to the same offset in out
+func (o *Object) writeExtent(ctx context.Context, out *os.File, extent Extent) (n int64, err error) {
+	in, err := o.open(ctx, &fs.RangeOption{Start: extent.Start, End: extent.Start + extent.Length - 1})
+	if err != nil {
+		return 0, err
+	}
+	defer fs.CheckClose(in, &err)
+	n, err = io.Copy(io.NewOffsetWriter(out, extent.Start), io.LimitReader(in, extent.Length))
+	if err == nil && n != extent.Length {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
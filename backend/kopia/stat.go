@@ -0,0 +1,103 @@
+package kopia
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// statReport is the output of the stat command
+type statReport struct {
+	Path      string    `json:"path"`
+	Type      string    `json:"type"`
+	ObjectID  string    `json:"objectID"`
+	Size      int64     `json:"size"`
+	Mode      string    `json:"mode"`
+	UserID    uint32    `json:"uid"`
+	GroupID   uint32    `json:"gid"`
+	User      string    `json:"user,omitempty"`
+	Group     string    `json:"group,omitempty"`
+	ModTime   time.Time `json:"mtime"`
+	Snapshot  string    `json:"snapshot"`  // snapshot the remote is showing
+	Snapshots []string  `json:"snapshots"` // snapshots with the same object at the path
+}
+
+// snapshotEntry returns the entry at remote in the snapshot with root
+// rootID or nil if it isn't there
+func (f *Fs) snapshotEntry(ctx context.Context, rootID, remote string) (*Entry, error) {
+	dir, leaf := path.Split(remote)
+	id, err := f.dirObjectID(ctx, rootID, cleanPath(dir))
+	if err != nil || id == "" {
+		return nil, err
+	}
+	d, err := f.readDirObject(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	_, e := f.find(d, leaf)
+	if e == nil {
+		return nil, nil
+	}
+	return &e.entry, nil
+}
+
+// stat returns the details of the file or directory at remote in the
+// snapshot and the snapshots which have the identical object at the
+// same path
+func (f *Fs) stat(ctx context.Context, remote string) (*statReport, error) {
+	result, _, _, err := f.fetchSnapshots(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	rootID, err := f.getRootId(ctx)
+	if err != nil {
+		return nil, err
+	}
+	i, err := findSnapshot(result.Snapshots, rootID)
+	if err != nil {
+		return nil, err
+	}
+	remote = cleanPath(path.Join(f.root, remote))
+	if remote == "" {
+		return nil, fs.ErrorIsDir
+	}
+	entry, err := f.snapshotEntry(ctx, rootID, remote)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, fs.ErrorObjectNotFound
+	}
+	report := &statReport{
+		Path:      remote,
+		Type:      entry.Type,
+		ObjectID:  entry.Obj,
+		Size:      entry.Size,
+		Mode:      entry.Mode,
+		UserID:    entry.UserID,
+		GroupID:   entry.GroupID,
+		User:      entry.User,
+		Group:     entry.Group,
+		ModTime:   entry.MTime,
+		Snapshot:  result.Snapshots[i].ID,
+		Snapshots: []string{},
+	}
+	if entry.Type == "d" {
+		report.Size = entry.Summary.Size
+	}
+	for _, s := range result.Snapshots {
+		other, err := f.snapshotEntry(ctx, s.RootID, remote)
+		if err == fs.ErrorIsFile {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if other != nil && other.Obj == entry.Obj {
+			report.Snapshots = append(report.Snapshots, s.ID)
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,93 @@
+package kopia
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/walk"
+	"golang.org/x/sync/errgroup"
+)
+
+// sums returns a checksum file like "rclone hashsum" makes for the
+// files in dir, or the file the remote points to, using the checksums
+// cached or computed by the backend.
+//
+// opt["format"] is the hash to use which must be one of the configured
+// hashes. Files without a checksum are logged as errors and left out.
+func (f *Fs) sums(ctx context.Context, dir string, opt map[string]string) ([]string, error) {
+	var ht hash.Type
+	if name := opt["format"]; name != "" {
+		if err := ht.Set(name); err != nil {
+			return nil, err
+		}
+	} else if f.hashes.Count() == 1 {
+		ht = f.hashes.GetOne()
+	} else {
+		return nil, fmt.Errorf("need -o format=hash - one of %v", f.hashes)
+	}
+	if !f.hashes.Contains(ht) {
+		return nil, fmt.Errorf("hash %v not enabled - add it to the hashes option", ht)
+	}
+	width := hash.Width(ht, false)
+	var (
+		mu      sync.Mutex
+		sums    = map[string]string{} // remote to checksum
+		missing int
+	)
+	add := func(ctx context.Context, o fs.Object) {
+		sum, err := o.Hash(ctx, ht)
+		if err == nil && sum == "" {
+			err = fmt.Errorf("%v hash not available - it may be larger than hash_max_size", ht)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			fs.Errorf(o, "%v", fs.CountError(err))
+			missing++
+			return
+		}
+		sums[o.Remote()] = sum
+	}
+	if dir == "" && f.rootFile != "" {
+		o, err := f.NewObject(ctx, f.rootFile)
+		if err != nil {
+			return nil, err
+		}
+		add(ctx, o)
+	} else {
+		g, gCtx := errgroup.WithContext(ctx)
+		g.SetLimit(fs.GetConfig(ctx).Checkers)
+		err := walk.ListR(ctx, f, dir, true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+			entries.ForObject(func(o fs.Object) {
+				g.Go(func() error {
+					add(gCtx, o)
+					return nil
+				})
+			})
+			return nil
+		})
+		if waitErr := g.Wait(); err == nil {
+			err = waitErr
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	remotes := make([]string, 0, len(sums))
+	for remote := range sums {
+		remotes = append(remotes, remote)
+	}
+	sort.Strings(remotes)
+	lines := make([]string, 0, len(remotes))
+	for _, remote := range remotes {
+		lines = append(lines, fmt.Sprintf("%*s  %s", width, sums[remote], remote))
+	}
+	if missing > 0 {
+		fs.Logf(f, "sums: %d files left out as their %v hash isn't available", missing, ht)
+	}
+	return lines, nil
+}
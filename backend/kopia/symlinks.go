@@ -0,0 +1,179 @@
+package kopia
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// maxLinkHops is the number of symlinks followed before giving up,
+// as for ELOOP on Linux
+const maxLinkHops = 40
+
+var (
+	errLinkLoop    = errors.New("too many levels of symbolic links")
+	errLinkOutside = errors.New("symlink points outside the snapshot")
+)
+
+// symlink is a symlink entry in a directory listing
+type symlink struct {
+	name   string // leaf name of the link
+	remote string // path of the link from the snapshot root
+	id     string // object ID containing the link target
+	parent string // object ID of the directory containing the link
+}
+
+// followingKey marks a context used while following symlinks
+type followingKey struct{}
+
+// followedEntries returns the entries of listing with the targets of
+// its symlinks added under the names of the links.
+//
+// The links are followed the first time the listing is used and other
+// callers wait for that to finish so they never see a partial listing.
+// Lookups made while following links don't wait, as they may need the
+// listing being followed, and get the entries without the targets.
+func (f *Fs) followedEntries(ctx context.Context, listing *dirListing) (fs.DirEntries, error) {
+	if len(listing.links) == 0 {
+		return listing.entries, nil
+	}
+	f.listMu.Lock()
+	entries, done := listing.entries, listing.followed
+	if done == nil {
+		done = make(chan struct{})
+		listing.followed = done
+		f.listMu.Unlock()
+		entries = f.followLinks(context.WithValue(ctx, followingKey{}, true), entries, listing.links)
+		f.listMu.Lock()
+		listing.entries = entries
+		f.listMu.Unlock()
+		close(done)
+		return entries, nil
+	}
+	f.listMu.Unlock()
+	if ctx.Value(followingKey{}) != nil {
+		return entries, nil
+	}
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	f.listMu.Lock()
+	defer f.listMu.Unlock()
+	return listing.entries, nil
+}
+
+// followLinks returns entries with the targets of links added under
+// the names of the links.
+func (f *Fs) followLinks(ctx context.Context, entries fs.DirEntries, links []symlink) fs.DirEntries {
+	entries = slices.Clip(entries)
+	for _, link := range links {
+		entry, err := f.followLink(ctx, link, 0)
+		if err != nil {
+			fs.Errorf(f, "Skipping symlink %q: %v", link.remote, err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// followLink returns the entry link points to renamed to be at the
+// link's path. hops is the number of links followed so far.
+func (f *Fs) followLink(ctx context.Context, link symlink, hops int) (fs.DirEntry, error) {
+	if hops >= maxLinkHops {
+		return nil, errLinkLoop
+	}
+	target, err := f.readLink(ctx, link.id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read symlink: %w", err)
+	}
+	if path.IsAbs(target) {
+		target = path.Clean(target)
+		source := path.Clean(f.opt.Path)
+		if f.opt.Path == "" || (target != source && !strings.HasPrefix(target, source+"/")) {
+			return nil, errLinkOutside
+		}
+		target = strings.TrimPrefix(strings.TrimPrefix(target, source), "/")
+	} else {
+		target = path.Join(path.Dir(link.remote), target)
+		if target == ".." || strings.HasPrefix(target, "../") {
+			return nil, errLinkOutside
+		}
+		if target == "." {
+			target = ""
+		}
+	}
+	entry, err := f.lookupLink(ctx, f.opt.Enc.ToStandardPath(target), hops+1)
+	if err != nil {
+		return nil, err
+	}
+	switch x := entry.(type) {
+	case *Object:
+		o := *x
+		o.name, o.remote, o.parentID = link.name, link.remote, link.parent
+		return &o, nil
+	case *Directory:
+		if x.remote == "" || x.remote == link.remote || strings.HasPrefix(link.remote, x.remote+"/") {
+			return nil, errLinkLoop
+		}
+		f.listMu.Lock()
+		d := *x
+		f.listMu.Unlock()
+		d.name, d.remote, d.parentID, d.listing = link.name, link.remote, link.parent, nil
+		return &d, nil
+	}
+	return nil, fmt.Errorf("unknown entry type %T", entry)
+}
+
+// lookupLink finds the entry at remote, following it if it is itself a
+// symlink.
+func (f *Fs) lookupLink(ctx context.Context, remote string, hops int) (fs.DirEntry, error) {
+	if remote == "" {
+		// the root is an ancestor of every link
+		return nil, errLinkLoop
+	}
+	dir, leaf := path.Split(remote)
+	listing, err := f.listing(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := f.followedEntries(ctx, listing)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if f.sameName(entry.(DirEntry).Name(), leaf) {
+			return entry, nil
+		}
+	}
+	for _, link := range listing.links {
+		if f.sameName(link.name, leaf) {
+			return f.followLink(ctx, link, hops)
+		}
+	}
+	return nil, fs.ErrorObjectNotFound
+}
+
+// readLink reads the target of the symlink stored in object id
+func (f *Fs) readLink(ctx context.Context, id string) (target string, err error) {
+	o := &Object{ObjectInfo: ObjectInfo{fs: f, id: id, size: -1}}
+	resp, cancel, err := o.get(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer cancel()
+	defer fs.CheckClose(resp.Body, &err)
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
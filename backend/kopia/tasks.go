@@ -0,0 +1,69 @@
+package kopia
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/rclone/rclone/lib/rest"
+)
+
+// taskReport is the output of the tasks command for a single task
+type taskReport struct {
+	TaskInfo
+	Logs []json.RawMessage `json:"logs,omitempty"`
+}
+
+// tasks returns the tasks on the server, only the running ones if
+// opt["running"] is set.
+func (f *Fs) tasks(ctx context.Context, opt map[string]string) ([]TaskInfo, error) {
+	running, err := boolOption(opt, "running")
+	if err != nil {
+		return nil, err
+	}
+	var result TaskListResponse
+	err = f.callJSON(ctx, &rest.Opts{
+		Method: "GET",
+		Path:   "/api/v1/tasks",
+	}, nil, &result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tasks: %w", err)
+	}
+	tasks := []TaskInfo{}
+	for _, task := range result.Tasks {
+		if !running || task.Status == "RUNNING" || task.Status == "CANCELING" {
+			tasks = append(tasks, task)
+		}
+	}
+	return tasks, nil
+}
+
+// task returns the task with the given id, with its log if
+// opt["logs"] is set.
+func (f *Fs) task(ctx context.Context, id string, opt map[string]string) (*taskReport, error) {
+	logs, err := boolOption(opt, "logs")
+	if err != nil {
+		return nil, err
+	}
+	report := &taskReport{}
+	err = f.callJSON(ctx, &rest.Opts{
+		Method: "GET",
+		Path:   fmt.Sprintf("/api/v1/tasks/%s", id),
+	}, nil, &report.TaskInfo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read task %s: %w", id, err)
+	}
+	if !logs {
+		return report, nil
+	}
+	var result TaskLogResponse
+	err = f.callJSON(ctx, &rest.Opts{
+		Method: "GET",
+		Path:   fmt.Sprintf("/api/v1/tasks/%s/logs", id),
+	}, nil, &result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read log of task %s: %w", id, err)
+	}
+	report.Logs = result.Logs
+	return report, nil
+}
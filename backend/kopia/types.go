@@ -1,6 +1,10 @@
 package kopia
 
-import "time"
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
 
 type SnapshotResponse struct {
 	Snapshots       []Snapshot `json:"snapshots"`
@@ -26,6 +30,17 @@ type Summary struct {
 	Dirs      int       `json:"dirs"`
 	MaxTime   time.Time `json:"maxTime"`
 	NumFailed int       `json:"numFailed"`
+	Errors    []Failure `json:"errors,omitempty"`
+}
+
+// empty returns true if no summary was supplied
+func (s *Summary) empty() bool {
+	return s.Size == 0 && s.Files == 0 && s.Symlinks == 0 && s.Dirs == 0 && s.MaxTime.IsZero() && s.NumFailed == 0
+}
+
+type Failure struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
 }
 
 type FileResponse struct {
@@ -35,11 +50,241 @@ type FileResponse struct {
 }
 
 type Entry struct {
-	Name    string    `json:"name"`
-	Type    string    `json:"type"`
-	Mode    string    `json:"mode"`
-	Size    int64     `json:"size"`
-	MTime   time.Time `json:"mtime"`
-	Obj     string    `json:"obj"`
-	Summary Summary   `json:"summ"`
+	Name    string            `json:"name"`
+	Type    string            `json:"type"`
+	Mode    string            `json:"mode"`
+	Size    int64             `json:"size"`
+	MTime   time.Time         `json:"mtime"`
+	ATime   time.Time         `json:"atime"`
+	CTime   time.Time         `json:"ctime"`
+	BTime   time.Time         `json:"btime"`
+	UserID  uint32            `json:"uid,omitempty"`
+	GroupID uint32            `json:"gid,omitempty"`
+	User    string            `json:"user,omitempty"`
+	Group   string            `json:"group,omitempty"`
+	Attrs   uint32            `json:"attrs,omitempty"`
+	Streams []string          `json:"streams,omitempty"`
+	XAttrs  map[string]string `json:"xattrs,omitempty"`
+	ACL     []byte            `json:"acl,omitempty"`
+	SD      []byte            `json:"sd,omitempty"`
+	Holes   []Extent          `json:"holes,omitempty"`
+	Obj     string            `json:"obj"`
+	Summary Summary           `json:"summ"`
+}
+
+// ErrorResponse is the body of an error returned by the server
+type ErrorResponse struct {
+	StatusCode int           `json:"-"`
+	RetryAfter time.Duration `json:"-"` // from the Retry-After header, if any
+	Code       string        `json:"code"`
+	Message    string        `json:"error"`
+}
+
+// Error returns a string for the error and satisfies the error interface
+func (e *ErrorResponse) Error() string {
+	out := fmt.Sprintf("kopia server returned HTTP %d", e.StatusCode)
+	if e.Code != "" {
+		out += " " + e.Code
+	}
+	if e.Message != "" {
+		out += ": " + e.Message
+	}
+	return out
+}
+
+type RepoStatus struct {
+	Connected                  bool   `json:"connected"`
+	ConfigFile                 string `json:"configFile"`
+	FormatVersion              int    `json:"formatVersion"`
+	Hash                       string `json:"hash"`
+	Encryption                 string `json:"encryption"`
+	ECC                        string `json:"ecc"`
+	Splitter                   string `json:"splitter"`
+	ECCOverheadPercent         int    `json:"eccOverheadPercent,omitempty"`
+	MaxPackSize                int64  `json:"maxPackSize"`
+	Storage                    string `json:"storage"`
+	APIServerURL               string `json:"apiServerURL,omitempty"`
+	SupportsContentCompression bool   `json:"supportsContentCompression"`
+	ClientOptions                     // inline in the response
+}
+
+type ClientOptions struct {
+	Description string `json:"description"`
+	Username    string `json:"username"`
+	Hostname    string `json:"hostname"`
+	ReadOnly    bool   `json:"readonly"`
+}
+
+type RepoParameters struct {
+	HashFunction               string `json:"hash"`
+	HMACSecret                 []byte `json:"hmacSecret"`
+	SupportsContentCompression bool   `json:"supportsContentCompression"`
+}
+
+type IndirectObject struct {
+	Stream  string          `json:"stream"`
+	Entries []IndirectEntry `json:"entries"`
+}
+
+type IndirectEntry struct {
+	Start  int64  `json:"s"`
+	Length int64  `json:"l"`
+	Object string `json:"o"`
+}
+
+type Extent struct {
+	Start  int64 `json:"s"`
+	Length int64 `json:"l"`
+}
+
+type SourceInfo struct {
+	Host     string `json:"host"`
+	UserName string `json:"userName"`
+	Path     string `json:"path"`
+}
+
+// String returns the source as kopia shows it, user@host:path
+func (s SourceInfo) String() string {
+	return s.UserName + "@" + s.Host + ":" + s.Path
+}
+
+type SourcesResponse struct {
+	LocalUsername string         `json:"localUsername"`
+	LocalHost     string         `json:"localHost"`
+	MultiUser     bool           `json:"multiUser"`
+	Sources       []SourceStatus `json:"sources"`
+}
+
+type SourceStatus struct {
+	Source SourceInfo `json:"source"`
+	Status string     `json:"status"`
+}
+
+type SnapshotManifest struct {
+	Source      SourceInfo        `json:"source"`
+	Description string            `json:"description"`
+	StartTime   time.Time         `json:"startTime"`
+	EndTime     time.Time         `json:"endTime"`
+	RootEntry   json.RawMessage   `json:"rootEntry"`
+	Tags        map[string]string `json:"tags,omitempty"`
+}
+
+type ManifestRequest struct {
+	Labels map[string]string `json:"labels"`
+	Data   interface{}       `json:"data"`
+}
+
+type ManifestResponse struct {
+	ID string `json:"id"`
+}
+
+type ManifestWithMetadata struct {
+	Payload  json.RawMessage  `json:"payload"`
+	Metadata ManifestMetadata `json:"metadata"`
+}
+
+type ManifestMetadata struct {
+	ID      string            `json:"id"`
+	Length  int               `json:"length"`
+	Labels  map[string]string `json:"labels"`
+	ModTime time.Time         `json:"mtime"`
+}
+
+type DirObject struct {
+	Stream  string            `json:"stream"`
+	Entries []json.RawMessage `json:"entries"`
+	Summary Summary           `json:"summary"`
+}
+
+type RestoreRequest struct {
+	Root            string            `json:"root"`
+	Options         RestoreOptions    `json:"options"`
+	Filesystem      *FilesystemOutput `json:"fsOutput,omitempty"`
+	ZipFile         string            `json:"zipFile,omitempty"`
+	UncompressedZip bool              `json:"uncompressedZip,omitempty"`
+	TarFile         string            `json:"tarFile,omitempty"`
+}
+
+type RestoreOptions struct {
+	Incremental  bool `json:"incremental"`
+	IgnoreErrors bool `json:"ignoreErrors"`
+}
+
+type FilesystemOutput struct {
+	TargetPath           string `json:"targetPath"`
+	OverwriteDirectories bool   `json:"overwriteDirectories"`
+	OverwriteFiles       bool   `json:"overwriteFiles"`
+	OverwriteSymlinks    bool   `json:"overwriteSymlinks"`
+	SkipOwners           bool   `json:"skipOwners"`
+	SkipPermissions      bool   `json:"skipPermissions"`
+	SkipTimes            bool   `json:"skipTimes"`
+}
+
+type TaskInfo struct {
+	ID           string                 `json:"id"`
+	StartTime    time.Time              `json:"startTime"`
+	EndTime      *time.Time             `json:"endTime,omitempty"`
+	Kind         string                 `json:"kind"`
+	Description  string                 `json:"description"`
+	Status       string                 `json:"status"`
+	ErrorMessage string                 `json:"errorMessage,omitempty"`
+	Counters     map[string]TaskCounter `json:"counters,omitempty"`
+}
+
+type EstimateRequest struct {
+	Root                 string `json:"root"`
+	MaxExamplesPerBucket int    `json:"maxExamplesPerBucket,omitempty"`
+}
+
+type MountSnapshotRequest struct {
+	Root string `json:"root"`
+}
+
+type MountedSnapshot struct {
+	Path string `json:"path"`
+	Root string `json:"root"`
+}
+
+type MaintenanceRequest struct {
+	Full bool `json:"full"`
+}
+
+type TaskListResponse struct {
+	Tasks []TaskInfo `json:"tasks"`
+}
+
+type TaskLogResponse struct {
+	Logs []json.RawMessage `json:"logs"`
+}
+
+type TaskCounter struct {
+	Value int64  `json:"value"`
+	Units string `json:"units,omitempty"`
+}
+
+type Policy struct {
+	Compression CompressionPolicy `json:"compression"`
+}
+
+type CompressionPolicy struct {
+	CompressorName string   `json:"compressorName,omitempty"`
+	OnlyCompress   []string `json:"onlyCompress,omitempty"`
+	NeverCompress  []string `json:"neverCompress,omitempty"`
+	MinSize        int64    `json:"minSize,omitempty"`
+	MaxSize        int64    `json:"maxSize,omitempty"`
+}
+
+type ResolvePolicyRequest struct {
+	Updates                  *Policy `json:"updates"`
+	NumUpcomingSnapshotTimes int     `json:"numUpcomingSnapshotTimes"`
+}
+
+type ResolvePolicyResponse struct {
+	Effective Policy `json:"effective"`
+}
+
+type DeleteSnapshotsRequest struct {
+	Source                SourceInfo `json:"source"`
+	SnapshotManifestIDs   []string   `json:"snapshotManifestIds"`
+	DeleteSourceAndPolicy bool       `json:"deleteSourceAndPolicy"`
 }
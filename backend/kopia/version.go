@@ -0,0 +1,74 @@
+package kopia
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/rest"
+)
+
+// maxFormatVersion is the newest repository format version rclone
+// knows how to write snapshots to
+const maxFormatVersion = 3
+
+var (
+	errServerTooOld = errors.New("kopia server is too old for rclone")
+	errServerTooNew = errors.New("kopia server is too new for rclone")
+)
+
+// isDecodeError returns true if err is from decoding a JSON response
+// which doesn't have the shape rclone expects
+func isDecodeError(err error) bool {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	return errors.As(err, &syntaxErr) || errors.As(err, &typeErr)
+}
+
+// decodeError explains err if it is from decoding the response to
+// opts, as that usually means the server speaks a different version of
+// the API.
+func decodeError(opts *rest.Opts, err error) error {
+	if !isDecodeError(err) {
+		return err
+	}
+	return fmt.Errorf("unexpected response to %s %s - the kopia server may be too old or too new for rclone: %w", opts.Method, opts.Path, err)
+}
+
+// probeServer reads the repository status once to check the server
+// can be used and to find out what it supports.
+//
+// Only an incompatible server is an error. If the server can't be
+// reached or isn't ready the calls made later report it.
+func (f *Fs) probeServer(ctx context.Context) error {
+	opts := rest.Opts{
+		Method: "GET",
+		Path:   "/api/v1/repo/status",
+	}
+	status := &RepoStatus{}
+	reqCtx, cancel := f.requestContext(ctx)
+	defer cancel()
+	_, err := f.srv.CallJSON(reqCtx, &opts, nil, status)
+	var apiErr *ErrorResponse
+	switch {
+	case errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusNotFound || apiErr.StatusCode == http.StatusMethodNotAllowed):
+		return fmt.Errorf("%w: %s has no repository status API - check url points to a kopia repository server: %w", errServerTooOld, f.opt.URL, err)
+	case isDecodeError(err):
+		return fmt.Errorf("%w or isn't a kopia server: can't read the repository status from %s: %w", errServerTooNew, f.opt.URL, err)
+	case err != nil:
+		fs.Debugf(f, "Couldn't check the server version: %v", err)
+		return nil
+	}
+	if status.FormatVersion > maxFormatVersion {
+		if f.opt.ReadWrite {
+			return fmt.Errorf("%w: repository format version %d is newer than %d so snapshots can't be written", errServerTooNew, status.FormatVersion, maxFormatVersion)
+		}
+		fs.Debugf(f, "Repository format version %d is newer than rclone knows about but can still be read", status.FormatVersion)
+	}
+	f.serverStatus = status
+	f.canCompress = status.SupportsContentCompression
+	return nil
+}
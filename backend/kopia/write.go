@@ -0,0 +1,889 @@
+package kopia
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/lib/rest"
+)
+
+// objectChunkSize is the size of the contents files are split into
+// when uploading
+var objectChunkSize = 4 * 1024 * 1024
+
+// stagedEntry is an entry in a directory of the staged snapshot
+type stagedEntry struct {
+	entry  Entry                      // the fields rclone reads and changes
+	fields map[string]json.RawMessage // all the fields as read, to preserve any rclone doesn't know about
+	dir    *stagedDir                 // contents of a directory, if loaded
+}
+
+// stagedDir is a directory in the staged snapshot
+type stagedDir struct {
+	id      string                  // object ID as read - "" if new
+	summary Summary                 // summary as read
+	entries map[string]*stagedEntry // entries by kopia name
+	changed bool                    // set if entries have been changed
+}
+
+// errReadOnly is returned by anything which would change the
+// repository unless read_write is set
+var errReadOnly = fmt.Errorf("kopia remote is read only - set read_write to allow changes: %w", fs.ErrorPermissionDenied)
+
+// errNoSnapshots is returned for the snapshot root of a source with no
+// snapshots yet in write mode
+var errNoSnapshots = errors.New("no snapshots of the source yet")
+
+// checkWritable returns an error unless f may make changes. The error
+// is fatal so a sync stops straight away rather than failing on every
+// file.
+func (f *Fs) checkWritable() error {
+	if !f.opt.ReadWrite {
+		return fserrors.FatalError(errReadOnly)
+	}
+	return nil
+}
+
+// maxNameLength is the longest name, in bytes, which can be written.
+// Longer names can be stored in a snapshot but most file systems can't
+// restore them.
+const maxNameLength = 255
+
+// maxPathLength is the longest path, in bytes, which can be written
+const maxPathLength = 4095
+
+// errInvalidPath is returned for paths which can't be written to the
+// snapshot
+var errInvalidPath = errors.New("invalid path")
+
+// checkRemote returns an error if remote can't be written to the
+// snapshot, so it fails before any API calls are made rather than when
+// the snapshot is read or restored. The error isn't retried.
+func (f *Fs) checkRemote(remote string) error {
+	remote = cleanPath(remote)
+	if remote == "" {
+		return nil
+	}
+	fail := func(format string, a ...interface{}) error {
+		return fserrors.NoRetryError(fmt.Errorf("%w %q: %s", errInvalidPath, remote, fmt.Sprintf(format, a...)))
+	}
+	if len(remote) > maxPathLength {
+		return fail("%d bytes long, more than the limit of %d", len(remote), maxPathLength)
+	}
+	for _, name := range strings.Split(remote, "/") {
+		raw := f.opt.Enc.FromStandardName(name)
+		if len(raw) > maxNameLength {
+			return fail("name %q is %d bytes long, more than the limit of %d", name, len(raw), maxNameLength)
+		}
+		// the name must read back as itself
+		if std, err := f.entryName(raw); err != nil || !f.sameName(std, name) {
+			return fail("name %q can't be stored with this encoding", name)
+		}
+	}
+	return nil
+}
+
+// tagPrefix is the prefix kopia gives snapshot tags in the manifest
+const tagPrefix = "tag:"
+
+// parseTags turns the snapshot_tags option into a map
+func parseTags(list fs.CommaSepList) (map[string]string, error) {
+	tags := make(map[string]string, len(list))
+	for _, item := range list {
+		k, v, ok := strings.Cut(item, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("kopia: bad snapshot tag %q - must be key=value", item)
+		}
+		tags[k] = v
+	}
+	return tags, nil
+}
+
+// logDryRun logs the staged change described if --dry-run is set
+func (f *Fs) logDryRun(ctx context.Context, format string, args ...interface{}) {
+	if fs.GetConfig(ctx).DryRun {
+		fs.Logf(f, "Would "+format+" in the next snapshot (--dry-run)", args...)
+	}
+}
+
+// commits records the last snapshot committed for each source so
+// every Fs writing to it sees the changes
+var (
+	commitsMu sync.Mutex
+	commits   = map[string]commitRecord{}
+)
+
+// commitRecord is a snapshot committed by rclone
+type commitRecord struct {
+	gen        int // increases with each commit to the source
+	rootID     string
+	snapshotID string
+}
+
+// sourceKey identifies the snapshot source f writes to
+func (f *Fs) sourceKey() string {
+	return strings.Join([]string{f.opt.URL, f.opt.User, f.opt.Host, f.opt.Path}, "\x00")
+}
+
+// recordCommit notes that rootID was committed as snapshotID
+func (f *Fs) recordCommit(rootID, snapshotID string) {
+	commitsMu.Lock()
+	defer commitsMu.Unlock()
+	key := f.sourceKey()
+	c := commitRecord{gen: commits[key].gen + 1, rootID: rootID, snapshotID: snapshotID}
+	commits[key] = c
+	f.commitGen = c.gen
+	f.setRoot(rootID, snapshotID, "")
+}
+
+// adoptCommit switches to the latest snapshot committed to the source
+// by another Fs, if there is one f hasn't seen yet
+func (f *Fs) adoptCommit() {
+	if !f.opt.ReadWrite {
+		return
+	}
+	commitsMu.Lock()
+	defer commitsMu.Unlock()
+	c, ok := commits[f.sourceKey()]
+	if !ok || c.gen <= f.commitGen {
+		return
+	}
+	f.commitGen = c.gen
+	if f.setRoot(c.rootID, c.snapshotID, "") {
+		fs.Debugf(f, "Switched to snapshot %s committed by another remote", c.snapshotID)
+	}
+}
+
+// staging holds the changes to be made in the next snapshot
+type staging struct {
+	rootID  string     // root object ID the changes are based on
+	root    *stagedDir // root directory of the snapshot
+	changes int        // number of changes staged
+}
+
+// newStagedEntry makes a stagedEntry from e
+func newStagedEntry(e Entry) *stagedEntry {
+	return &stagedEntry{entry: e}
+}
+
+// marshal the entry to JSON preserving any fields rclone doesn't know
+// about
+func (e *stagedEntry) marshal() (json.RawMessage, error) {
+	fields := make(map[string]json.RawMessage, len(e.fields)+8)
+	for k, v := range e.fields {
+		fields[k] = v
+	}
+	set := func(key string, value interface{}) {
+		data, err := json.Marshal(value)
+		if err == nil {
+			fields[key] = data
+		}
+	}
+	fields["name"] = quoteRaw(e.entry.Name)
+	set("type", e.entry.Type)
+	set("obj", e.entry.Obj)
+	set("mtime", e.entry.MTime)
+	if e.entry.Mode != "" {
+		set("mode", e.entry.Mode)
+	}
+	if e.entry.Type == "d" {
+		set("summ", e.entry.Summary)
+		delete(fields, "size")
+	} else {
+		set("size", e.entry.Size)
+	}
+	return json.Marshal(fields)
+}
+
+// readDirObject reads the directory object id for staging
+func (f *Fs) readDirObject(ctx context.Context, id string) (*stagedDir, error) {
+	d := &stagedDir{id: id, entries: map[string]*stagedEntry{}}
+	if id == "" {
+		return d, nil
+	}
+	var result DirObject
+	err := f.callJSON(ctx, &rest.Opts{
+		Method: "GET",
+		Path:   fmt.Sprintf("/api/v1/objects/%s", id),
+	}, nil, &result)
+	if errors.Is(err, fs.ErrorObjectNotFound) {
+		return nil, fmt.Errorf("%w: directory object %s: %w", errMissingObject, id, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory object %s: %w", id, err)
+	}
+	d.summary = result.Summary
+	for _, raw := range result.Entries {
+		e := &stagedEntry{}
+		if err := json.Unmarshal(raw, &e.entry); err != nil {
+			return nil, fmt.Errorf("bad entry in directory object %s: %w", id, err)
+		}
+		if err := json.Unmarshal(raw, &e.fields); err != nil {
+			return nil, fmt.Errorf("bad entry in directory object %s: %w", id, err)
+		}
+		d.entries[e.entry.Name] = e
+	}
+	return d, nil
+}
+
+// find the entry in d called name in rclone's encoding, returning the
+// kopia name and the entry or nil if not found
+func (f *Fs) find(d *stagedDir, name string) (string, *stagedEntry) {
+	raw := f.opt.Enc.FromStandardName(name)
+	if e, ok := d.entries[raw]; ok {
+		return raw, e
+	}
+	for raw, e := range d.entries {
+		if std, err := f.entryName(raw); err == nil && f.sameName(std, name) {
+			return raw, e
+		}
+	}
+	return "", nil
+}
+
+// loadDir makes sure the directory e has been read
+func (f *Fs) loadDir(ctx context.Context, e *stagedEntry) (*stagedDir, error) {
+	if e.dir == nil {
+		d, err := f.readDirObject(ctx, e.entry.Obj)
+		if err != nil {
+			return nil, err
+		}
+		e.dir = d
+	}
+	return e.dir, nil
+}
+
+// stagedDirs returns the staged directories from the root to the
+// directory at remote, creating any missing ones if create is set.
+//
+// Call with stageMu held.
+func (f *Fs) stagedDirs(ctx context.Context, remote string, create bool) (dirs []*stagedDir, err error) {
+	rootID, err := f.getRootId(ctx)
+	if errors.Is(err, errNoSnapshots) {
+		// start the first snapshot of the source from an empty root
+		rootID, err = "", nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if f.staged != nil && f.staged.changes == 0 && f.staged.rootID != rootID {
+		// nothing staged yet so start again from the new root
+		f.staged = nil
+	}
+	if f.staged == nil {
+		root, err := f.readDirObject(ctx, rootID)
+		if err != nil {
+			return nil, err
+		}
+		f.staged = &staging{rootID: rootID, root: root}
+	}
+	d := f.staged.root
+	dirs = append(dirs, d)
+	remote = cleanPath(remote)
+	if remote == "" {
+		return dirs, nil
+	}
+	for i, name := range strings.Split(remote, "/") {
+		_, e := f.find(d, name)
+		if e == nil {
+			if !create {
+				return nil, fs.ErrorDirNotFound
+			}
+			f.logDryRun(ctx, "create directory %q", strings.Join(strings.Split(remote, "/")[:i+1], "/"))
+			e = newStagedEntry(Entry{
+				Name:  f.opt.Enc.FromStandardName(name),
+				Type:  "d",
+				Mode:  "0755",
+				MTime: time.Now(),
+			})
+			e.dir = &stagedDir{entries: map[string]*stagedEntry{}, changed: true}
+			d.entries[e.entry.Name] = e
+			d.changed = true
+			f.staged.changes++
+		} else if e.entry.Type != "d" {
+			return nil, fs.ErrorIsFile
+		}
+		d, err = f.loadDir(ctx, e)
+		if err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, d)
+	}
+	return dirs, nil
+}
+
+// stageEntry puts e at remote in the staged snapshot, replacing any
+// existing entry and creating the parent directories if needed.
+//
+// The other fields of the entry are taken from fields if set,
+// otherwise from the entry being replaced.
+func (f *Fs) stageEntry(ctx context.Context, remote string, e Entry, fields map[string]json.RawMessage) error {
+	f.stageMu.Lock()
+	defer f.stageMu.Unlock()
+	dir, leaf := path.Split(cleanPath(remote))
+	dirs, err := f.stagedDirs(ctx, dir, true)
+	if err != nil {
+		return err
+	}
+	d := dirs[len(dirs)-1]
+	raw, old := f.find(d, leaf)
+	if old != nil {
+		if old.entry.Type == "d" && e.Type != "d" {
+			return fs.ErrorIsDir
+		}
+		delete(d.entries, raw)
+		e.Name = raw
+	} else {
+		e.Name = f.opt.Enc.FromStandardName(leaf)
+	}
+	if e.Mode == "" {
+		switch {
+		case old != nil:
+			e.Mode = old.entry.Mode
+		case e.Type == "d":
+			e.Mode = "0755"
+		default:
+			e.Mode = "0644"
+		}
+	}
+	staged := newStagedEntry(e)
+	if fields != nil {
+		staged.fields = fields
+	} else if old != nil {
+		// keep the fields rclone doesn't change, except the holes
+		// which describe the old content
+		staged.fields = old.fields
+		if old.entry.Obj != e.Obj {
+			staged.fields = maps.Clone(old.fields)
+			delete(staged.fields, "holes")
+		}
+	}
+	if staged.fields != nil {
+		// fill in the entry from the fields kept
+		raw, err := staged.marshal()
+		if err != nil {
+			return err
+		}
+		staged.entry = Entry{}
+		if err := json.Unmarshal(raw, &staged.entry); err != nil {
+			return err
+		}
+	}
+	d.entries[e.Name] = staged
+	d.changed = true
+	f.staged.changes++
+	f.logDryRun(ctx, "write %q", remote)
+	return nil
+}
+
+// unstageEntry removes the entry at remote from the staged snapshot
+// returning it. If check is set it is called with the entry first and
+// any error returned stops the removal.
+func (f *Fs) unstageEntry(ctx context.Context, remote string, check func(*stagedEntry) error) (*stagedEntry, error) {
+	f.stageMu.Lock()
+	defer f.stageMu.Unlock()
+	return f.unstageLocked(ctx, remote, check)
+}
+
+// unstageLocked is unstageEntry with stageMu held
+func (f *Fs) unstageLocked(ctx context.Context, remote string, check func(*stagedEntry) error) (*stagedEntry, error) {
+	dir, leaf := path.Split(cleanPath(remote))
+	if leaf == "" {
+		return nil, errors.New("can't remove the root of the snapshot")
+	}
+	dirs, err := f.stagedDirs(ctx, dir, false)
+	if err != nil {
+		return nil, err
+	}
+	d := dirs[len(dirs)-1]
+	raw, e := f.find(d, leaf)
+	if e == nil {
+		return nil, fs.ErrorObjectNotFound
+	}
+	if check != nil {
+		if err := check(e); err != nil {
+			return nil, err
+		}
+	}
+	delete(d.entries, raw)
+	d.changed = true
+	f.staged.changes++
+	f.logDryRun(ctx, "remove %q", remote)
+	return e, nil
+}
+
+// mkdir creates the directory at remote and any missing parents
+func (f *Fs) mkdir(ctx context.Context, remote string) error {
+	if err := f.checkWritable(); err != nil {
+		return err
+	}
+	if err := f.checkRemote(remote); err != nil {
+		return err
+	}
+	f.stageMu.Lock()
+	defer f.stageMu.Unlock()
+	_, err := f.stagedDirs(ctx, remote, true)
+	return err
+}
+
+// rmdir removes the empty directory at remote
+func (f *Fs) rmdir(ctx context.Context, remote string) error {
+	if err := f.checkWritable(); err != nil {
+		return err
+	}
+	if cleanPath(remote) == "" {
+		// the snapshot root can't be removed, only emptied
+		f.stageMu.Lock()
+		dirs, err := f.stagedDirs(ctx, "", false)
+		f.stageMu.Unlock()
+		if err != nil {
+			return err
+		}
+		if len(dirs[0].entries) != 0 {
+			return fs.ErrorDirectoryNotEmpty
+		}
+		return nil
+	}
+	_, err := f.unstageEntry(ctx, remote, func(e *stagedEntry) error {
+		if e.entry.Type != "d" {
+			return fs.ErrorIsFile
+		}
+		d, err := f.loadDir(ctx, e)
+		if err != nil {
+			return err
+		}
+		if len(d.entries) != 0 {
+			return fs.ErrorDirectoryNotEmpty
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrorObjectNotFound) {
+		return fs.ErrorDirNotFound
+	}
+	return err
+}
+
+// remove the file at remote
+func (f *Fs) remove(ctx context.Context, remote string) error {
+	if err := f.checkWritable(); err != nil {
+		return err
+	}
+	_, err := f.unstageEntry(ctx, remote, func(e *stagedEntry) error {
+		if e.entry.Type == "d" {
+			return fs.ErrorIsDir
+		}
+		return nil
+	})
+	return err
+}
+
+// purge removes the directory at remote and everything in it
+func (f *Fs) purge(ctx context.Context, remote string) error {
+	if err := f.checkWritable(); err != nil {
+		return err
+	}
+	if cleanPath(remote) == "" {
+		// the snapshot root can't be removed, only emptied
+		f.stageMu.Lock()
+		defer f.stageMu.Unlock()
+		dirs, err := f.stagedDirs(ctx, "", false)
+		if err != nil {
+			return err
+		}
+		if len(dirs[0].entries) != 0 {
+			dirs[0].entries = map[string]*stagedEntry{}
+			dirs[0].changed = true
+			f.staged.changes++
+			f.logDryRun(ctx, "remove everything in the root")
+		}
+		return nil
+	}
+	_, err := f.unstageEntry(ctx, remote, func(e *stagedEntry) error {
+		if e.entry.Type != "d" {
+			return fs.ErrorIsFile
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrorObjectNotFound) {
+		return fs.ErrorDirNotFound
+	}
+	return err
+}
+
+// writeContent uploads data as a content with the given prefix,
+// returning its content ID
+//
+// If compressor is set the server is asked to compress the content.
+func (f *Fs) writeContent(ctx context.Context, prefix string, data []byte, compressor string) (id string, err error) {
+	if f.repoHash == nil {
+		return "", errors.New("repository hash not known")
+	}
+	h := f.repoHash()
+	_, _ = h.Write(data)
+	id = prefix + hex.EncodeToString(h.Sum(nil))
+	if fs.GetConfig(ctx).DryRun {
+		fs.Debugf(f, "Not writing content %s as --dry-run is set", id)
+		return id, nil
+	}
+	size := int64(len(data))
+	var resp *http.Response
+	err = f.call(ctx, func() (bool, error) {
+		reqCtx, cancel := f.requestContext(ctx)
+		defer cancel()
+		resp, err = f.srv.Call(reqCtx, &rest.Opts{
+			Method:        "PUT",
+			Path:          fmt.Sprintf("/api/v1/contents/%s", id),
+			Parameters:    compressionParameter(compressor),
+			Body:          bytes.NewReader(data),
+			ContentLength: &size,
+			ContentType:   "application/octet-stream",
+			NoResponse:    true,
+		})
+		return f.shouldRetry(ctx, resp, err)
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to write content %s: %w", id, err)
+	}
+	return id, nil
+}
+
+// writeObject uploads the data from in as an object, splitting it
+// into contents and writing an index if it is too big for one.
+//
+// The data contents are compressed with compressor if set.
+func (f *Fs) writeObject(ctx context.Context, in io.Reader, compressor string) (id string, size int64, err error) {
+	buf := make([]byte, objectChunkSize)
+	index := IndirectObject{Stream: "kopia:indirect"}
+	for {
+		n, readErr := io.ReadFull(in, buf)
+		if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+			return "", 0, readErr
+		}
+		if n > 0 || len(index.Entries) == 0 {
+			contentID, err := f.writeContent(ctx, "", buf[:n], compressor)
+			if err != nil {
+				return "", 0, err
+			}
+			if compressor != "" {
+				contentID = "Z" + contentID
+			}
+			index.Entries = append(index.Entries, IndirectEntry{Start: size, Length: int64(n), Object: contentID})
+			size += int64(n)
+		}
+		if readErr != nil {
+			break
+		}
+	}
+	if len(index.Entries) == 1 {
+		return index.Entries[0].Object, size, nil
+	}
+	data, err := json.Marshal(index)
+	if err != nil {
+		return "", 0, err
+	}
+	indexID, err := f.writeContent(ctx, "x", data, "")
+	if err != nil {
+		return "", 0, err
+	}
+	return "I" + indexID, size, nil
+}
+
+// commitDir writes d and any changed directories below it, returning
+// its object ID and summary and whether anything changed.
+func (f *Fs) commitDir(ctx context.Context, d *stagedDir) (id string, summary Summary, changed bool, err error) {
+	changed = d.changed
+	for _, e := range d.entries {
+		if e.dir == nil {
+			continue
+		}
+		childID, childSummary, childChanged, err := f.commitDir(ctx, e.dir)
+		if err != nil {
+			return "", summary, false, err
+		}
+		if childChanged {
+			e.entry.Obj, e.entry.Summary = childID, childSummary
+			changed = true
+		}
+	}
+	if !changed {
+		return d.id, d.summary, false, nil
+	}
+	names := make([]string, 0, len(d.entries))
+	for name := range d.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	dirObject := DirObject{Stream: "kopia:directory", Entries: []json.RawMessage{}}
+	for _, name := range names {
+		e := d.entries[name]
+		switch e.entry.Type {
+		case "d":
+			summary.Dirs += 1 + e.entry.Summary.Dirs
+			summary.Files += e.entry.Summary.Files
+			summary.Symlinks += e.entry.Summary.Symlinks
+			summary.Size += e.entry.Summary.Size
+			summary.NumFailed += e.entry.Summary.NumFailed
+			if e.entry.Summary.MaxTime.After(summary.MaxTime) {
+				summary.MaxTime = e.entry.Summary.MaxTime
+			}
+		case "s":
+			summary.Symlinks++
+		case "f", "":
+			summary.Files++
+			summary.Size += e.entry.Size
+		}
+		if e.entry.MTime.After(summary.MaxTime) {
+			summary.MaxTime = e.entry.MTime
+		}
+		raw, err := e.marshal()
+		if err != nil {
+			return "", summary, false, err
+		}
+		dirObject.Entries = append(dirObject.Entries, raw)
+	}
+	dirObject.Summary = summary
+	data, err := json.Marshal(dirObject)
+	if err != nil {
+		return "", summary, false, err
+	}
+	id, err = f.writeContent(ctx, "k", data, "")
+	if err != nil {
+		return "", summary, false, err
+	}
+	return id, summary, true, nil
+}
+
+// commit writes the staged changes as a new snapshot, returning what
+// was committed
+//
+// With --dry-run the snapshot is worked out and reported but nothing
+// is written and the changes are discarded.
+func (f *Fs) commit(ctx context.Context) (*commitReport, error) {
+	f.stageMu.Lock()
+	defer f.stageMu.Unlock()
+	report := &commitReport{DryRun: fs.GetConfig(ctx).DryRun}
+	if f.staged == nil || f.staged.changes == 0 {
+		return report, nil
+	}
+	report.Changes = f.staged.changes
+	startTime := time.Now()
+	rootID, summary, _, err := f.commitDir(ctx, f.staged.root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write snapshot directories: %w", err)
+	}
+	report.RootID = rootID
+	rootEntry, err := newStagedEntry(Entry{
+		Name:    path.Base(f.opt.Path),
+		Type:    "d",
+		Mode:    "0755",
+		MTime:   summary.MaxTime,
+		Obj:     rootID,
+		Summary: summary,
+	}).marshal()
+	if err != nil {
+		return nil, err
+	}
+	source := f.source()
+	manifest := SnapshotManifest{
+		Source:      source,
+		Description: f.opt.Description,
+		StartTime:   startTime,
+		EndTime:     time.Now(),
+		RootEntry:   rootEntry,
+	}
+	labels := map[string]string{
+		"type":     "snapshot",
+		"hostname": source.Host,
+		"username": source.UserName,
+		"path":     source.Path,
+	}
+	if len(f.tags) > 0 {
+		manifest.Tags = make(map[string]string, len(f.tags))
+		for k, v := range f.tags {
+			manifest.Tags[tagPrefix+k] = v
+			labels[tagPrefix+k] = v
+		}
+	}
+	if report.DryRun {
+		fs.Logf(f, "Not creating snapshot of %d changes with root %s as --dry-run is set", report.Changes, rootID)
+		f.staged = nil
+		return report, nil
+	}
+	var result ManifestResponse
+	err = f.callJSON(ctx, &rest.Opts{
+		Method: "POST",
+		Path:   "/api/v1/manifests",
+	}, &ManifestRequest{
+		Labels: labels,
+		Data:   &manifest,
+	}, &result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write snapshot manifest: %w", err)
+	}
+	err = f.callJSON(ctx, &rest.Opts{
+		Method:     "POST",
+		Path:       "/api/v1/flush",
+		NoResponse: true,
+	}, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to flush repository: %w", err)
+	}
+	fs.Infof(f, "Created snapshot %s with root %s from %d changes", result.ID, rootID, f.staged.changes)
+	f.recordCommit(rootID, result.ID)
+	f.forgetRootListing()
+	f.staged = nil
+	report.Snapshot = result.ID
+	return report, nil
+}
+
+// upload the data in to remote as a new version of the file in the
+// staged snapshot.
+func (f *Fs) upload(ctx context.Context, remote string, in io.Reader, src fs.ObjectInfo) (*Object, error) {
+	if err := f.checkWritable(); err != nil {
+		return nil, err
+	}
+	if err := f.checkRemote(remote); err != nil {
+		return nil, err
+	}
+	id, size, err := f.writeObject(ctx, in, f.uploadCompressor(ctx, remote, src.Size()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload %s: %w", remote, err)
+	}
+	if src.Size() >= 0 && size != src.Size() {
+		return nil, fmt.Errorf("upload of %s: read %d bytes expecting %d", remote, size, src.Size())
+	}
+	err = f.stageEntry(ctx, remote, Entry{
+		Type:  "f",
+		Size:  size,
+		MTime: src.ModTime(ctx),
+		Obj:   id,
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return f.stagedObject(ctx, remote)
+}
+
+// stagedObject returns the object which is now at remote in the
+// staged snapshot
+func (f *Fs) stagedObject(ctx context.Context, remote string) (*Object, error) {
+	obj, err := f.newObject(ctx, remote)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s back after staging: %w", remote, err)
+	}
+	o, ok := obj.(*Object)
+	if !ok {
+		return nil, fs.ErrorIsDir
+	}
+	return o, nil
+}
+
+// dirty returns true if d or any directory loaded below it has been
+// changed, so its object ID will change when committed
+func (d *stagedDir) dirty() bool {
+	if d.changed {
+		return true
+	}
+	for _, e := range d.entries {
+		if e.dir != nil && e.dir.dirty() {
+			return true
+		}
+	}
+	return false
+}
+
+// stagedListing returns a listing of the directory at remote from the
+// staged snapshot. It returns false if there are no changes staged so
+// the snapshot itself should be listed.
+//
+// Directories which will change when committed have no object ID.
+func (f *Fs) stagedListing(ctx context.Context, remote string) (listing *dirListing, ok bool, err error) {
+	f.stageMu.Lock()
+	defer f.stageMu.Unlock()
+	if f.staged == nil || f.staged.changes == 0 {
+		return nil, false, nil
+	}
+	dirs, err := f.stagedDirs(ctx, remote, false)
+	if err != nil {
+		// only a missing directory is fs.ErrorDirNotFound as sync
+		// deletes what it thinks is missing
+		return nil, true, err
+	}
+	d := dirs[len(dirs)-1]
+	names := make([]string, 0, len(d.entries))
+	for name := range d.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	entries := make([]Entry, len(names))
+	for i, name := range names {
+		e := d.entries[name]
+		entries[i] = e.entry
+		if e.dir != nil && e.dir.dirty() {
+			entries[i].Obj = ""
+		}
+	}
+	id := d.id
+	if d.dirty() {
+		id = ""
+	}
+	return f.newDirListing(remote, id, id, entries), true, nil
+}
+
+// Shutdown the backend, committing any staged changes as a new
+// snapshot, then closing the hash cache and any idle connections.
+func (f *Fs) Shutdown(ctx context.Context) error {
+	_, err := f.commit(ctx)
+	if cacheErr := f.hashCache.close(); cacheErr != nil && err == nil {
+		err = cacheErr
+	}
+	f.client.CloseIdleConnections()
+	return err
+}
+
+// commitReport is the output of the commit and rollback commands
+type commitReport struct {
+	Changes  int    `json:"changes"`
+	Snapshot string `json:"snapshot,omitempty"`
+	RootID   string `json:"rootID,omitempty"`
+	DryRun   bool   `json:"dryRun,omitempty"`
+}
+
+// commitCommand commits the staged changes now, returning what was
+// committed
+func (f *Fs) commitCommand(ctx context.Context) (*commitReport, error) {
+	if err := f.checkWritable(); err != nil {
+		return nil, err
+	}
+	return f.commit(ctx)
+}
+
+// rollback discards the staged changes, returning how many there were
+func (f *Fs) rollback() (*commitReport, error) {
+	if err := f.checkWritable(); err != nil {
+		return nil, err
+	}
+	f.stageMu.Lock()
+	defer f.stageMu.Unlock()
+	report := &commitReport{}
+	if f.staged != nil {
+		report.Changes = f.staged.changes
+	}
+	f.staged = nil
+	fs.Infof(f, "Discarded %d staged changes", report.Changes)
+	return report, nil
+}
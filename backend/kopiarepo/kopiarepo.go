@@ -0,0 +1,568 @@
+// Package kopiarepo provides read only access to the snapshots in a
+// kopia repository without a kopia server
+package kopiarepo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/cmd/serve/webdav"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/configstruct"
+	"github.com/rclone/rclone/fs/config/obscure"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/lib/atexit"
+	"github.com/rclone/rclone/lib/random"
+	"github.com/rclone/rclone/lib/readers"
+)
+
+// Register with Fs
+func init() {
+	fs.Register(&fs.RegInfo{
+		Name:        "kopiarepo",
+		Description: "Kopia repository read without a kopia server",
+		NewFs:       NewFs,
+		CommandHelp: commandHelp,
+		Options: []fs.Option{{
+			Name: "repo",
+			Help: `Kopia repository to read.
+
+This is a local path to a filesystem repository or the URL of a
+repository in an object store, eg s3://bucket/prefix, b2://bucket,
+gs://bucket, azure://container or https://host/path for WebDAV.
+
+Credentials for the object store can be given with --kopiarepo-connect-flags.
+
+Leave blank to use remote instead.`,
+			Sensitive: true,
+		}, {
+			Name: "remote",
+			Help: `Remote holding the repository, eg "myremote:path/to/repo".
+
+The remote is served to kopia over WebDAV from inside rclone, so the
+repository is read with rclone's own transport and credentials. The
+server only listens on 127.0.0.1 and uses a random password.
+
+Leave blank to use repo instead.`,
+		}, {
+			Name:       "password",
+			Help:       "Password of the repository.",
+			IsPassword: true,
+			Required:   true,
+		}, {
+			Name:    "snapshot",
+			Help:    "Snapshot to show, by ID or root object ID, or latest for the most recent one.",
+			Default: "latest",
+			Examples: []fs.OptionExample{{
+				Value: "latest",
+			}},
+			Sensitive: true,
+		}, {
+			Name: "source",
+			Help: `Source of the snapshots to show, as user@host:/path.
+
+If this isn't set the snapshots of every source are used.`,
+			Sensitive: true,
+		}, {
+			Name: "connect_flags",
+			Help: `Extra flags for "kopia repository connect".
+
+These are passed after the storage type, eg
+"--access-key=XXX --secret-access-key=YYY --endpoint=host" for s3.`,
+			Default:   fs.SpaceSepList{},
+			Advanced:  true,
+			Sensitive: true,
+		}, {
+			Name: "kopia_command",
+			Help: `Path to the kopia command.
+
+The kopia command is run to connect to the repository, list the
+snapshots and read the files from them, so it must be installed where
+rclone runs.`,
+			Default:  "kopia",
+			Advanced: true,
+		}},
+	})
+}
+
+// Options defines the configuration for this backend
+type Options struct {
+	Repo         string          `config:"repo"`
+	Remote       string          `config:"remote"`
+	Password     string          `config:"password"`
+	Snapshot     string          `config:"snapshot"`
+	Source       string          `config:"source"`
+	ConnectFlags fs.SpaceSepList `config:"connect_flags"`
+	Command      string          `config:"kopia_command"`
+}
+
+// Fs represents a snapshot in a kopia repository
+type Fs struct {
+	name      string
+	root      string
+	opt       Options
+	features  *fs.Features
+	configDir string          // temporary directory for the kopia config and cache
+	cleanup   atexit.FnHandle // removes configDir on exit
+	server    *webdav.WebDAV  // serving the remote to kopia, if set
+	env       []string        // extra environment for the kopia command
+
+	mu        sync.Mutex            // protects the following
+	snapshots []snapshotManifest    // snapshots in the repository, once read
+	dirs      map[string][]dirEntry // directory object ID to its entries, once read
+}
+
+// Object describes a file in a snapshot
+type Object struct {
+	fs      *Fs
+	remote  string
+	id      string // object ID of the data
+	size    int64
+	modTime time.Time
+}
+
+// errReadOnly is returned by anything which would change the
+// repository
+var errReadOnly = fmt.Errorf("kopiarepo remotes are read only: %w", fs.ErrorPermissionDenied)
+
+// NewFs creates a new Fs object from the name and root
+func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, error) {
+	opt := new(Options)
+	err := configstruct.Set(m, opt)
+	if err != nil {
+		return nil, err
+	}
+	if opt.Password == "" {
+		return nil, errors.New("kopiarepo: password is required")
+	}
+	if (opt.Repo == "") == (opt.Remote == "") {
+		return nil, errors.New("kopiarepo: exactly one of repo and remote must be set")
+	}
+	if strings.HasPrefix(opt.Remote, name+":") {
+		return nil, errors.New("can't point remote at itself - check the value of the remote setting")
+	}
+	opt.Password, err = obscure.Reveal(opt.Password)
+	if err != nil {
+		return nil, fmt.Errorf("kopiarepo: couldn't decrypt password: %w", err)
+	}
+	f := &Fs{
+		name: name,
+		root: cleanPath(root),
+		opt:  *opt,
+		dirs: map[string][]dirEntry{},
+	}
+	f.features = (&fs.Features{
+		CanHaveEmptyDirectories: true,
+		ReadOnly:                true,
+	}).Fill(ctx, f)
+	if err := f.connect(ctx); err != nil {
+		return nil, err
+	}
+	if f.root != "" {
+		entry, err := f.lookup(ctx, f.root)
+		if err != nil && !errors.Is(err, fs.ErrorDirNotFound) {
+			_ = f.Shutdown(ctx)
+			return nil, err
+		}
+		if entry != nil && entry.Type == "f" {
+			f.root = parentPath(f.root)
+			return f, fs.ErrorIsFile
+		}
+	}
+	return f, nil
+}
+
+// cleanPath makes p into the form used for remotes, with no leading
+// or trailing slashes
+func cleanPath(p string) string {
+	if p != "" {
+		p = strings.Trim(path.Clean(p), "/")
+	}
+	if p == "." {
+		p = ""
+	}
+	return p
+}
+
+// parentPath returns the directory p is in, "" for the root
+func parentPath(p string) string {
+	dir := path.Dir(p)
+	if dir == "." {
+		return ""
+	}
+	return dir
+}
+
+// storageArgs returns the arguments of "kopia repository connect"
+// which select the storage of repo
+func storageArgs(repo string) ([]string, error) {
+	u, err := url.Parse(repo)
+	if err != nil || len(u.Scheme) <= 1 {
+		// a local path, possibly with a drive letter
+		return []string{"filesystem", "--path=" + repo}, nil
+	}
+	prefix := strings.TrimPrefix(u.Path, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	var args []string
+	switch u.Scheme {
+	case "file":
+		return []string{"filesystem", "--path=" + u.Path}, nil
+	case "http", "https":
+		return []string{"webdav", "--url=" + repo}, nil
+	case "s3":
+		args = []string{"s3", "--bucket=" + u.Host}
+	case "b2":
+		args = []string{"b2", "--bucket=" + u.Host}
+	case "gs":
+		args = []string{"gcs", "--bucket=" + u.Host}
+	case "azure":
+		args = []string{"azure", "--container=" + u.Host}
+	default:
+		return nil, fmt.Errorf("kopiarepo: unsupported repository URL scheme %q", u.Scheme)
+	}
+	if prefix != "" {
+		args = append(args, "--prefix="+prefix)
+	}
+	return args, nil
+}
+
+// command makes a kopia command with the arguments given, using the
+// config file made by connect
+func (f *Fs) command(ctx context.Context, args ...string) *exec.Cmd {
+	args = append(args[:len(args):len(args)], "--config-file="+filepath.Join(f.configDir, "repository.config"))
+	cmd := exec.CommandContext(ctx, f.opt.Command, args...)
+	cmd.Env = append(os.Environ(), "KOPIA_PASSWORD="+f.opt.Password)
+	cmd.Env = append(cmd.Env, f.env...)
+	return cmd
+}
+
+// commandError makes an error from a failed kopia command including
+// what it wrote to stderr
+func commandError(args []string, err error, stderr *bytes.Buffer) error {
+	name := strings.Join(args[:min(2, len(args))], " ")
+	msg := strings.TrimSpace(stderr.String())
+	if msg == "" {
+		return fmt.Errorf("kopia %s failed: %w", name, err)
+	}
+	return fmt.Errorf("kopia %s failed: %w: %s", name, err, msg)
+}
+
+// run the kopia command with args returning its output
+func (f *Fs) run(ctx context.Context, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := f.command(ctx, args...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, commandError(args, err, &stderr)
+	}
+	return stdout.Bytes(), nil
+}
+
+// serveRemote serves the remote holding the repository over WebDAV
+// on a local port, returning the arguments of "kopia repository
+// connect" which read it
+func (f *Fs) serveRemote(ctx context.Context) ([]string, error) {
+	remoteFs, err := cache.Get(ctx, f.opt.Remote)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make remote %q to read: %w", f.opt.Remote, err)
+	}
+	pass, err := random.Password(128)
+	if err != nil {
+		return nil, err
+	}
+	davOpt := webdav.DefaultOpt
+	davOpt.HTTP.ListenAddr = []string{"127.0.0.1:0"}
+	davOpt.HTTP.BaseURL = ""
+	davOpt.Auth.BasicUser = "kopia"
+	davOpt.Auth.BasicPass = pass
+	davOpt.HashType = hash.None
+	davOpt.DisableGETDir = true
+	f.server, err = webdav.New(ctx, remoteFs, &davOpt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serve remote %q: %w", f.opt.Remote, err)
+	}
+	f.server.Serve()
+	cache.PinUntilFinalized(remoteFs, f)
+	// the password is passed in the environment so it isn't on the
+	// command line
+	f.env = append(f.env, "KOPIA_WEBDAV_PASSWORD="+pass)
+	return []string{"webdav", "--url=" + f.server.URLs()[0], "--webdav-username=kopia"}, nil
+}
+
+// connect makes a kopia config for the repository in a temporary
+// directory so the user's own config isn't changed
+func (f *Fs) connect(ctx context.Context) (err error) {
+	var storage []string
+	if f.opt.Remote != "" {
+		storage, err = f.serveRemote(ctx)
+	} else {
+		storage, err = storageArgs(f.opt.Repo)
+	}
+	if err != nil {
+		_ = f.Shutdown(ctx)
+		return err
+	}
+	f.configDir, err = os.MkdirTemp("", "rclone-kopiarepo-")
+	if err != nil {
+		_ = f.Shutdown(ctx)
+		return fmt.Errorf("kopiarepo: couldn't make config directory: %w", err)
+	}
+	args := append([]string{"repository", "connect"}, storage...)
+	args = append(args, f.opt.ConnectFlags...)
+	args = append(args, "--readonly", "--cache-directory="+filepath.Join(f.configDir, "cache"))
+	configDir := f.configDir
+	f.cleanup = atexit.Register(func() {
+		_ = os.RemoveAll(configDir)
+	})
+	if _, err = f.run(ctx, args...); err != nil {
+		_ = f.Shutdown(ctx)
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops serving the remote and removes the kopia config and
+// cache
+func (f *Fs) Shutdown(ctx context.Context) error {
+	if f.server != nil {
+		_ = f.server.Shutdown()
+		f.server = nil
+	}
+	if f.configDir == "" {
+		return nil
+	}
+	atexit.Unregister(f.cleanup)
+	return os.RemoveAll(f.configDir)
+}
+
+// Name of the remote (as passed into NewFs)
+func (f *Fs) Name() string {
+	return f.name
+}
+
+// Root of the remote (as passed into NewFs)
+func (f *Fs) Root() string {
+	return f.root
+}
+
+// String converts this Fs to a string
+func (f *Fs) String() string {
+	if f.opt.Remote != "" {
+		return fmt.Sprintf("kopia repository %s", f.opt.Remote)
+	}
+	return fmt.Sprintf("kopia repository %s", f.opt.Repo)
+}
+
+// Precision of the ModTimes in this Fs
+func (f *Fs) Precision() time.Duration {
+	return time.Nanosecond
+}
+
+// Hashes returns the supported hash sets
+func (f *Fs) Hashes() hash.Set {
+	return hash.Set(hash.None)
+}
+
+// Features returns the optional features of this Fs
+func (f *Fs) Features() *fs.Features {
+	return f.features
+}
+
+// List the objects and directories in dir into entries.  The
+// entries can be returned in any order but should be for a
+// complete directory.
+//
+// dir should be "" to list the root, and should not have
+// trailing slashes.
+//
+// This should return ErrDirNotFound if the directory isn't
+// found.
+func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+	entry, err := f.lookup(ctx, path.Join(f.root, dir))
+	if err != nil {
+		return nil, err
+	}
+	if entry.Type != "d" {
+		return nil, fs.ErrorDirNotFound
+	}
+	children, err := f.readDir(ctx, entry.Obj)
+	if err != nil {
+		return nil, err
+	}
+	for i := range children {
+		child := &children[i]
+		remote := path.Join(dir, child.Name)
+		switch child.Type {
+		case "d":
+			entries = append(entries, fs.NewDir(remote, child.MTime).SetID(child.Obj))
+		case "f":
+			entries = append(entries, f.newObject(remote, child))
+		default:
+			fs.Debugf(f, "Skipping %q of type %q", remote, child.Type)
+		}
+	}
+	return entries, nil
+}
+
+// newObject makes an Object for the file entry
+func (f *Fs) newObject(remote string, entry *dirEntry) *Object {
+	return &Object{
+		fs:      f,
+		remote:  remote,
+		id:      entry.Obj,
+		size:    entry.Size,
+		modTime: entry.MTime,
+	}
+}
+
+// NewObject finds the Object at remote.  If it can't be found
+// it returns the error fs.ErrorObjectNotFound.
+func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	entry, err := f.lookup(ctx, path.Join(f.root, remote))
+	if errors.Is(err, fs.ErrorDirNotFound) {
+		return nil, fs.ErrorObjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch entry.Type {
+	case "f":
+		return f.newObject(remote, entry), nil
+	case "d":
+		return nil, fs.ErrorIsDir
+	}
+	return nil, fs.ErrorObjectNotFound
+}
+
+// Put is not supported as kopiarepo remotes are read only
+func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	return nil, fserrors.FatalError(errReadOnly)
+}
+
+// Mkdir is not supported as kopiarepo remotes are read only
+func (f *Fs) Mkdir(ctx context.Context, dir string) error {
+	return fserrors.FatalError(errReadOnly)
+}
+
+// Rmdir is not supported as kopiarepo remotes are read only
+func (f *Fs) Rmdir(ctx context.Context, dir string) error {
+	return fserrors.FatalError(errReadOnly)
+}
+
+// ------------------------------------------------------------
+
+// Fs returns the parent Fs
+func (o *Object) Fs() fs.Info {
+	return o.fs
+}
+
+// Return a string version
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.remote
+}
+
+// Remote returns the remote path
+func (o *Object) Remote() string {
+	return o.remote
+}
+
+// Hash returns the selected checksum of the file
+func (o *Object) Hash(ctx context.Context, ty hash.Type) (string, error) {
+	return "", hash.ErrUnsupported
+}
+
+// Size returns the size of an object in bytes
+func (o *Object) Size() int64 {
+	return o.size
+}
+
+// ModTime returns the modification time of the object
+func (o *Object) ModTime(ctx context.Context) time.Time {
+	return o.modTime
+}
+
+// SetModTime is not supported as kopiarepo remotes are read only
+func (o *Object) SetModTime(ctx context.Context, modTime time.Time) error {
+	return fs.ErrorCantSetModTime
+}
+
+// Storable returns whether this object is storable
+func (o *Object) Storable() bool {
+	return true
+}
+
+// ID returns the kopia object ID of the data
+func (o *Object) ID() string {
+	return o.id
+}
+
+// Open an object for read
+func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error) {
+	var offset, limit int64 = 0, -1
+	fs.FixRangeOption(options, o.size)
+	for _, option := range options {
+		switch x := option.(type) {
+		case *fs.RangeOption:
+			offset, limit = x.Decode(o.size)
+		case *fs.SeekOption:
+			offset = x.Offset
+		default:
+			if option.Mandatory() {
+				fs.Logf(o, "Unsupported mandatory option: %v", option)
+			}
+		}
+	}
+	in, err := o.fs.show(ctx, o.id)
+	if err != nil {
+		return nil, err
+	}
+	// kopia show can only stream the object from the start
+	if offset > 0 {
+		if _, err := io.CopyN(io.Discard, in, offset); err != nil {
+			_ = in.Close()
+			return nil, fmt.Errorf("failed to seek to %d: %w", offset, err)
+		}
+	}
+	if limit >= 0 {
+		in = readers.NewLimitedReadCloser(in, limit)
+	}
+	return in, nil
+}
+
+// Update is not supported as kopiarepo remotes are read only
+func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	return fserrors.FatalError(errReadOnly)
+}
+
+// Remove is not supported as kopiarepo remotes are read only
+func (o *Object) Remove(ctx context.Context) error {
+	return fserrors.FatalError(errReadOnly)
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs         = &Fs{}
+	_ fs.Commander  = &Fs{}
+	_ fs.Shutdowner = &Fs{}
+	_ fs.Object     = &Object{}
+	_ fs.IDer       = &Object{}
+)
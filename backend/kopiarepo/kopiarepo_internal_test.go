@@ -0,0 +1,388 @@
+package kopiarepo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/obscure"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeEnv is set to run the test binary as a fake kopia command
+const fakeEnv = "RCLONE_TEST_FAKE_KOPIA"
+
+var testTime = time.Date(2024, 8, 29, 11, 0, 0, 500, time.UTC)
+
+var fakeSnapshots = []snapshotManifest{{
+	ID:        "s1",
+	Source:    snapshotSource{Host: "host", UserName: "alice", Path: "/data"},
+	StartTime: testTime.Add(-24 * time.Hour),
+	RootEntry: dirEntry{Type: "d", Obj: "k1"},
+}, {
+	ID:        "s2",
+	Source:    snapshotSource{Host: "host", UserName: "alice", Path: "/data"},
+	StartTime: testTime,
+	RootEntry: dirEntry{Type: "d", Obj: "k2"},
+}, {
+	ID:        "s3",
+	Source:    snapshotSource{Host: "other", UserName: "bob", Path: "/home"},
+	StartTime: testTime.Add(-time.Hour),
+	RootEntry: dirEntry{Type: "d", Obj: "k3"},
+}}
+
+var fakeDirs = map[string][]dirEntry{
+	"k1": {
+		{Name: "file.txt", Type: "f", Size: 3, MTime: testTime, Obj: "o1"},
+	},
+	"k2": {
+		{Name: "empty", Type: "d", MTime: testTime, Obj: "kempty"},
+		{Name: "file.txt", Type: "f", Size: 11, MTime: testTime, Obj: "o2"},
+		{Name: "link", Type: "s", Size: 8, MTime: testTime, Obj: "o4"},
+		{Name: "sub", Type: "d", MTime: testTime, Obj: "ksub"},
+	},
+	"ksub": {
+		{Name: "deep.txt", Type: "f", Size: 4, MTime: testTime, Obj: "o3"},
+	},
+	"kempty": {},
+	"k3": {
+		{Name: "bob.txt", Type: "f", Size: 3, MTime: testTime, Obj: "o5"},
+	},
+}
+
+var fakeData = map[string]string{
+	"o1": "old",
+	"o2": "hello world",
+	"o3": "deep",
+	"o4": "file.txt",
+	"o5": "bob",
+}
+
+// fakeKopia acts as the kopia command for the arguments given
+func fakeKopia(args []string) int {
+	if os.Getenv("KOPIA_PASSWORD") != "secret" {
+		fmt.Fprintln(os.Stderr, "error connecting to repository: invalid repository password")
+		return 1
+	}
+	if len(args) < 3 || !strings.HasPrefix(args[len(args)-1], "--config-file=") {
+		fmt.Fprintf(os.Stderr, "unexpected arguments %q\n", args)
+		return 1
+	}
+	configFile := strings.TrimPrefix(args[len(args)-1], "--config-file=")
+	args = args[:len(args)-1]
+	if args[0] == "repository" && args[1] == "connect" {
+		storage := strings.Join(args[2:len(args)-2], " ")
+		if args[2] == "webdav" {
+			if err := fakeWebDAVConnect(args[3 : len(args)-2]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+		} else if storage != "filesystem --path=/repo" && storage != "s3 --bucket=bucket --prefix=kopia/ --access-key=key" {
+			fmt.Fprintf(os.Stderr, "unable to connect to storage %q\n", storage)
+			return 1
+		}
+		if args[len(args)-2] != "--readonly" || !strings.HasPrefix(args[len(args)-1], "--cache-directory=") {
+			fmt.Fprintf(os.Stderr, "unexpected arguments %q\n", args)
+			return 1
+		}
+		if err := os.WriteFile(configFile, []byte("{}"), 0600); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+	if _, err := os.Stat(configFile); err != nil {
+		fmt.Fprintln(os.Stderr, "repository is not connected")
+		return 1
+	}
+	switch {
+	case slices.Equal(args, []string{"snapshot", "list", "--json", "--all"}):
+		_ = json.NewEncoder(os.Stdout).Encode(fakeSnapshots)
+	case args[0] == "show" && len(args) == 2:
+		if entries, ok := fakeDirs[args[1]]; ok {
+			_ = json.NewEncoder(os.Stdout).Encode(dirManifest{Stream: dirStream, Entries: entries})
+		} else if data, ok := fakeData[args[1]]; ok {
+			_, _ = io.WriteString(os.Stdout, data)
+		} else {
+			fmt.Fprintf(os.Stderr, "object %s not found\n", args[1])
+			return 1
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unexpected arguments %q\n", args)
+		return 1
+	}
+	return 0
+}
+
+// fakeWebDAVConnect checks the repository can be read over WebDAV
+// with the flags given
+func fakeWebDAVConnect(flags []string) error {
+	if len(flags) != 2 || !strings.HasPrefix(flags[0], "--url=") || flags[1] != "--webdav-username=kopia" {
+		return fmt.Errorf("unexpected webdav flags %q", flags)
+	}
+	req, err := http.NewRequest("GET", strings.TrimPrefix(flags[0], "--url=")+"kopia.repository.f", nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth("kopia", os.Getenv("KOPIA_WEBDAV_PASSWORD"))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK || string(data) != "repository" {
+		return fmt.Errorf("unable to read repository: %s: %q", resp.Status, data)
+	}
+	return nil
+}
+
+func TestMain(m *testing.M) {
+	if os.Getenv(fakeEnv) != "" {
+		os.Exit(fakeKopia(os.Args[1:]))
+	}
+	os.Exit(m.Run())
+}
+
+func newTestFs(t *testing.T, root string, extra configmap.Simple) (*Fs, error) {
+	t.Setenv(fakeEnv, "1")
+	m := configmap.Simple{
+		"type":          "kopiarepo",
+		"repo":          "/repo",
+		"password":      obscure.MustObscure("secret"),
+		"kopia_command": os.Args[0],
+	}
+	for k, v := range extra {
+		m[k] = v
+	}
+	regInfo, err := fs.Find("kopiarepo")
+	require.NoError(t, err)
+	f, err := NewFs(context.Background(), "TestKopiaRepo", root, fs.ConfigMap(regInfo.Prefix, regInfo.Options, "", m))
+	if f == nil {
+		return nil, err
+	}
+	t.Cleanup(func() {
+		_ = f.(*Fs).Shutdown(context.Background())
+	})
+	return f.(*Fs), err
+}
+
+// listNames returns the remotes in dir
+func listNames(t *testing.T, f *Fs, dir string) (names []string) {
+	entries, err := f.List(context.Background(), dir)
+	require.NoError(t, err)
+	for _, entry := range entries {
+		names = append(names, entry.Remote())
+	}
+	return names
+}
+
+func readObject(t *testing.T, f *Fs, remote string, options ...fs.OpenOption) string {
+	ctx := context.Background()
+	o, err := f.NewObject(ctx, remote)
+	require.NoError(t, err)
+	in, err := o.Open(ctx, options...)
+	require.NoError(t, err)
+	data, err := io.ReadAll(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	return string(data)
+}
+
+func TestStorageArgs(t *testing.T) {
+	for _, test := range []struct {
+		repo string
+		want []string
+	}{
+		{repo: "/repo", want: []string{"filesystem", "--path=/repo"}},
+		{repo: `C:\repo`, want: []string{"filesystem", `--path=C:\repo`}},
+		{repo: "file:///repo", want: []string{"filesystem", "--path=/repo"}},
+		{repo: "s3://bucket", want: []string{"s3", "--bucket=bucket"}},
+		{repo: "s3://bucket/kopia", want: []string{"s3", "--bucket=bucket", "--prefix=kopia/"}},
+		{repo: "b2://bucket/a/b/", want: []string{"b2", "--bucket=bucket", "--prefix=a/b/"}},
+		{repo: "gs://bucket", want: []string{"gcs", "--bucket=bucket"}},
+		{repo: "azure://container/kopia", want: []string{"azure", "--container=container", "--prefix=kopia/"}},
+		{repo: "https://host/kopia", want: []string{"webdav", "--url=https://host/kopia"}},
+	} {
+		got, err := storageArgs(test.repo)
+		require.NoError(t, err, test.repo)
+		assert.Equal(t, test.want, got, test.repo)
+	}
+	_, err := storageArgs("ftp://host/kopia")
+	assert.ErrorContains(t, err, "unsupported")
+}
+
+func TestList(t *testing.T) {
+	ctx := context.Background()
+	f, err := newTestFs(t, "", nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"empty", "file.txt", "sub"}, listNames(t, f, ""))
+	assert.Equal(t, []string{"sub/deep.txt"}, listNames(t, f, "sub"))
+	assert.Empty(t, listNames(t, f, "empty"))
+
+	_, err = f.List(ctx, "missing")
+	assert.ErrorIs(t, err, fs.ErrorDirNotFound)
+	_, err = f.List(ctx, "file.txt")
+	assert.ErrorIs(t, err, fs.ErrorDirNotFound)
+	_, err = f.List(ctx, "file.txt/sub")
+	assert.ErrorIs(t, err, fs.ErrorDirNotFound)
+
+	o, err := f.NewObject(ctx, "file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), o.Size())
+	assert.True(t, testTime.Equal(o.ModTime(ctx)))
+	assert.Equal(t, "o2", o.(fs.IDer).ID())
+
+	_, err = f.NewObject(ctx, "missing")
+	assert.ErrorIs(t, err, fs.ErrorObjectNotFound)
+	_, err = f.NewObject(ctx, "sub")
+	assert.ErrorIs(t, err, fs.ErrorIsDir)
+	_, err = f.NewObject(ctx, "link")
+	assert.ErrorIs(t, err, fs.ErrorObjectNotFound)
+}
+
+func TestRead(t *testing.T) {
+	f, err := newTestFs(t, "", nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, "hello world", readObject(t, f, "file.txt"))
+	assert.Equal(t, "world", readObject(t, f, "file.txt", &fs.SeekOption{Offset: 6}))
+	assert.Equal(t, "lo w", readObject(t, f, "file.txt", &fs.RangeOption{Start: 3, End: 6}))
+	assert.Equal(t, "deep", readObject(t, f, "sub/deep.txt"))
+
+	// closing before the end stops kopia without an error
+	o, err := f.NewObject(context.Background(), "file.txt")
+	require.NoError(t, err)
+	in, err := o.Open(context.Background())
+	require.NoError(t, err)
+	assert.NoError(t, in.Close())
+}
+
+func TestSnapshot(t *testing.T) {
+	f, err := newTestFs(t, "", configmap.Simple{"snapshot": "s1"})
+	require.NoError(t, err)
+	assert.Equal(t, "old", readObject(t, f, "file.txt"))
+
+	f, err = newTestFs(t, "", configmap.Simple{"snapshot": "k1"})
+	require.NoError(t, err)
+	assert.Equal(t, "old", readObject(t, f, "file.txt"))
+
+	f, err = newTestFs(t, "", configmap.Simple{"source": "bob@other:/home"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bob.txt"}, listNames(t, f, ""))
+
+	_, err = newTestFs(t, "sub", configmap.Simple{"snapshot": "missing"})
+	assert.ErrorContains(t, err, "not found")
+	_, err = newTestFs(t, "sub", configmap.Simple{"snapshot": "s1", "source": "bob@other:/home"})
+	assert.ErrorContains(t, err, "not found")
+}
+
+func TestRootIsFile(t *testing.T) {
+	f, err := newTestFs(t, "sub/deep.txt", nil)
+	assert.ErrorIs(t, err, fs.ErrorIsFile)
+	require.NotNil(t, f)
+	assert.Equal(t, "sub", f.Root())
+	assert.Equal(t, "deep", readObject(t, f, "deep.txt"))
+
+	f, err = newTestFs(t, "missing", nil)
+	require.NoError(t, err)
+	_, err = f.List(context.Background(), "")
+	assert.ErrorIs(t, err, fs.ErrorDirNotFound)
+}
+
+func TestReadOnly(t *testing.T) {
+	ctx := context.Background()
+	f, err := newTestFs(t, "", nil)
+	require.NoError(t, err)
+	assert.ErrorIs(t, f.Mkdir(ctx, "new"), fs.ErrorPermissionDenied)
+	assert.ErrorIs(t, f.Rmdir(ctx, "empty"), fs.ErrorPermissionDenied)
+	o, err := f.NewObject(ctx, "file.txt")
+	require.NoError(t, err)
+	assert.ErrorIs(t, o.Remove(ctx), fs.ErrorPermissionDenied)
+}
+
+func TestConnect(t *testing.T) {
+	f, err := newTestFs(t, "", configmap.Simple{
+		"repo":          "s3://bucket/kopia",
+		"connect_flags": "--access-key=key",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", readObject(t, f, "file.txt"))
+
+	// the config is removed on shutdown
+	assert.DirExists(t, f.configDir)
+	require.NoError(t, f.Shutdown(context.Background()))
+	assert.NoDirExists(t, f.configDir)
+
+	_, err = newTestFs(t, "", configmap.Simple{"password": obscure.MustObscure("wrong")})
+	assert.ErrorContains(t, err, "invalid repository password")
+	_, err = newTestFs(t, "", configmap.Simple{"repo": "/missing"})
+	assert.ErrorContains(t, err, "kopia repository connect failed")
+	_, err = newTestFs(t, "", configmap.Simple{"password": ""})
+	assert.ErrorContains(t, err, "password is required")
+	_, err = newTestFs(t, "", configmap.Simple{"remote": "/repo"})
+	assert.ErrorContains(t, err, "exactly one of repo and remote")
+}
+
+func TestRemote(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "kopia.repository.f"), []byte("repository"), 0666))
+	f, err := newTestFs(t, "", configmap.Simple{"repo": "", "remote": dir})
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", readObject(t, f, "file.txt"))
+
+	// the server needs the password
+	require.NotNil(t, f.server)
+	resp, err := http.Get(f.server.URLs()[0] + "kopia.repository.f")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// and stops on shutdown
+	url := f.server.URLs()[0]
+	require.NoError(t, f.Shutdown(context.Background()))
+	_, err = http.Get(url)
+	assert.Error(t, err)
+
+	_, err = newTestFs(t, "", configmap.Simple{"repo": "", "remote": t.TempDir()})
+	assert.ErrorContains(t, err, "unable to read repository")
+	_, err = newTestFs(t, "", configmap.Simple{"repo": "", "remote": "TestKopiaRepo:"})
+	assert.ErrorContains(t, err, "can't point remote at itself")
+}
+
+func TestSnapshotsCommand(t *testing.T) {
+	f, err := newTestFs(t, "", configmap.Simple{"snapshot": "s1"})
+	require.NoError(t, err)
+	out, err := f.Command(context.Background(), "snapshots", nil, nil)
+	require.NoError(t, err)
+	snapshots := out.([]snapshotListing)
+	require.Len(t, snapshots, 3)
+	assert.True(t, snapshots[0].Active)
+	assert.False(t, snapshots[2].Active)
+	assert.Equal(t, "alice@host:/data", snapshots[2].Source)
+	assert.Equal(t, "k2", snapshots[2].Root)
+
+	// sorted by time rather than grouped by source
+	assert.Equal(t, "s3", snapshots[1].ID)
+
+	_, err = f.Command(context.Background(), "snapshots", []string{"x"}, nil)
+	assert.Error(t, err)
+}
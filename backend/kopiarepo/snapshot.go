@@ -0,0 +1,299 @@
+package kopiarepo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// snapshotSource is the source of a snapshot
+type snapshotSource struct {
+	Host     string `json:"host"`
+	UserName string `json:"userName"`
+	Path     string `json:"path"`
+}
+
+// String returns the source as user@host:/path
+func (s snapshotSource) String() string {
+	return s.UserName + "@" + s.Host + ":" + s.Path
+}
+
+// snapshotManifest is a snapshot as listed by "kopia snapshot list --json"
+type snapshotManifest struct {
+	ID          string         `json:"id"`
+	Source      snapshotSource `json:"source"`
+	Description string         `json:"description"`
+	StartTime   time.Time      `json:"startTime"`
+	EndTime     time.Time      `json:"endTime"`
+	RootEntry   dirEntry       `json:"rootEntry"`
+}
+
+// dirEntry is an entry of a kopia directory object
+type dirEntry struct {
+	Name  string    `json:"name"`
+	Type  string    `json:"type"` // "d", "f", "s" etc
+	Mode  string    `json:"mode"`
+	Size  int64     `json:"size"`
+	MTime time.Time `json:"mtime"`
+	Obj   string    `json:"obj"`
+}
+
+// dirManifest is the content of a kopia directory object
+type dirManifest struct {
+	Stream  string     `json:"stream"`
+	Entries []dirEntry `json:"entries"`
+}
+
+// dirStream is the stream type of directory objects
+const dirStream = "kopia:directory"
+
+// listSnapshots returns the snapshots in the repository, oldest
+// first, reading them the first time
+func (f *Fs) listSnapshots(ctx context.Context) ([]snapshotManifest, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.snapshots != nil {
+		return f.snapshots, nil
+	}
+	out, err := f.run(ctx, "snapshot", "list", "--json", "--all")
+	if err != nil {
+		return nil, err
+	}
+	var snapshots []snapshotManifest
+	if err := json.Unmarshal(out, &snapshots); err != nil {
+		return nil, fmt.Errorf("kopia snapshot list: bad output: %w", err)
+	}
+	f.snapshots = snapshots[:0:0]
+	for _, s := range snapshots {
+		if f.opt.Source == "" || f.opt.Source == s.Source.String() {
+			f.snapshots = append(f.snapshots, s)
+		}
+	}
+	// kopia lists the snapshots grouped by source
+	sort.SliceStable(f.snapshots, func(i, j int) bool {
+		return f.snapshots[i].StartTime.Before(f.snapshots[j].StartTime)
+	})
+	return f.snapshots, nil
+}
+
+// findSnapshot returns the snapshot selected by the snapshot option
+func (f *Fs) findSnapshot(ctx context.Context) (*snapshotManifest, error) {
+	snapshots, err := f.listSnapshots(ctx)
+	if err != nil {
+		return nil, err
+	}
+	spec := f.opt.Snapshot
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		s := &snapshots[i]
+		if spec == "latest" || spec == "" || spec == s.ID || spec == s.RootEntry.Obj {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("kopia snapshot %q not found", spec)
+}
+
+// lookup returns the entry at p in the snapshot, "" being its root
+//
+// It returns fs.ErrorDirNotFound if there isn't one.
+func (f *Fs) lookup(ctx context.Context, p string) (*dirEntry, error) {
+	s, err := f.findSnapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	entry := &s.RootEntry
+	if p == "" {
+		return entry, nil
+	}
+	for _, name := range strings.Split(p, "/") {
+		if entry.Type != "d" {
+			return nil, fs.ErrorDirNotFound
+		}
+		children, err := f.readDir(ctx, entry.Obj)
+		if err != nil {
+			return nil, err
+		}
+		entry = nil
+		for i := range children {
+			if children[i].Name == name {
+				entry = &children[i]
+				break
+			}
+		}
+		if entry == nil {
+			return nil, fs.ErrorDirNotFound
+		}
+	}
+	return entry, nil
+}
+
+// readDir returns the entries of the directory object id, reading
+// them the first time
+func (f *Fs) readDir(ctx context.Context, id string) ([]dirEntry, error) {
+	f.mu.Lock()
+	entries, ok := f.dirs[id]
+	f.mu.Unlock()
+	if ok {
+		return entries, nil
+	}
+	out, err := f.run(ctx, "show", id)
+	if err != nil {
+		return nil, err
+	}
+	var dir dirManifest
+	if err := json.Unmarshal(out, &dir); err != nil {
+		return nil, fmt.Errorf("kopia show %s: bad directory: %w", id, err)
+	}
+	if dir.Stream != dirStream {
+		return nil, fmt.Errorf("kopia show %s: not a directory: stream %q", id, dir.Stream)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.dirs[id] = dir.Entries
+	return dir.Entries, nil
+}
+
+// showReader reads an object streamed by "kopia show"
+type showReader struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	args   []string
+	stderr *bytes.Buffer
+	cancel context.CancelFunc
+	eof    bool // set if the object was read to the end
+	closed bool
+}
+
+// Read the object, noting when it has been read to the end
+func (r *showReader) Read(p []byte) (n int, err error) {
+	n, err = r.ReadCloser.Read(p)
+	if err == io.EOF {
+		r.eof = true
+	}
+	return n, err
+}
+
+// Close the output and wait for kopia to finish
+//
+// kopia is stopped if the object wasn't read to the end, otherwise
+// any error it exited with is returned.
+func (r *showReader) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	if !r.eof {
+		r.cancel()
+	}
+	_ = r.ReadCloser.Close()
+	err := r.cmd.Wait()
+	r.cancel()
+	if err != nil && r.eof {
+		return commandError(r.args, err, r.stderr)
+	}
+	return nil
+}
+
+// show streams the object id
+func (f *Fs) show(ctx context.Context, id string) (io.ReadCloser, error) {
+	args := []string{"show", id}
+	stderr := new(bytes.Buffer)
+	ctx, cancel := context.WithCancel(ctx)
+	cmd := f.command(ctx, args...)
+	cmd.Stderr = stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return nil, commandError(args, err, stderr)
+	}
+	return &showReader{
+		ReadCloser: stdout,
+		cmd:        cmd,
+		args:       args,
+		stderr:     stderr,
+		cancel:     cancel,
+	}, nil
+}
+
+var commandHelp = []fs.CommandHelp{{
+	Name:  "snapshots",
+	Short: "List the snapshots in the repository.",
+	Long: `This command lists the snapshots in the repository, oldest first,
+with their IDs, sources, times and root object IDs. The snapshot the
+remote is showing is marked as active.
+
+Usage Example:
+
+    rclone backend snapshots kopiarepo:
+
+The IDs can be used with --kopiarepo-snapshot and the sources with
+--kopiarepo-source.
+`,
+}}
+
+// snapshotListing is a snapshot as returned by the snapshots command
+type snapshotListing struct {
+	ID          string    `json:"id"`
+	Source      string    `json:"source"`
+	Description string    `json:"description,omitempty"`
+	Time        time.Time `json:"time"`
+	Root        string    `json:"root"`
+	Active      bool      `json:"active,omitempty"`
+}
+
+// snapshotList returns the snapshots for the snapshots command
+func (f *Fs) snapshotList(ctx context.Context) ([]snapshotListing, error) {
+	snapshots, err := f.listSnapshots(ctx)
+	if err != nil {
+		return nil, err
+	}
+	active := ""
+	if s, err := f.findSnapshot(ctx); err == nil {
+		active = s.ID
+	}
+	out := make([]snapshotListing, 0, len(snapshots))
+	for _, s := range snapshots {
+		out = append(out, snapshotListing{
+			ID:          s.ID,
+			Source:      s.Source.String(),
+			Description: s.Description,
+			Time:        s.StartTime,
+			Root:        s.RootEntry.Obj,
+			Active:      s.ID == active,
+		})
+	}
+	return out, nil
+}
+
+// Command the backend to run a named command
+//
+// The command run is name
+// args may be used to read arguments from
+// opts may be used to read optional arguments from
+//
+// The result should be capable of being JSON encoded
+// If it is a string or a []string it will be shown to the user
+// otherwise it will be JSON encoded and shown to the user like that
+func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[string]string) (out interface{}, err error) {
+	switch name {
+	case "snapshots":
+		if len(arg) > 0 {
+			return nil, errors.New("snapshots takes no arguments")
+		}
+		return f.snapshotList(ctx)
+	default:
+		return nil, fs.ErrorCommandNotFound
+	}
+}
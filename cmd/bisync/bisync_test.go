@@ -36,6 +36,7 @@ import (
 	"github.com/rclone/rclone/fs/sync"
 	"github.com/rclone/rclone/fs/walk"
 	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/fstest/mockfs"
 	"github.com/rclone/rclone/lib/atexit"
 	"github.com/rclone/rclone/lib/encoder"
 	"github.com/rclone/rclone/lib/random"
@@ -260,6 +261,29 @@ func TestBisyncRemoteRemote(t *testing.T) {
 	testBisync(t, remote, remote)
 }
 
+// Bisync refuses to run if either path is read-only
+func TestBisyncReadOnly(t *testing.T) {
+	ctx := context.Background()
+	local, err := fs.NewFs(ctx, t.TempDir())
+	require.NoError(t, err)
+	readOnly, err := mockfs.NewFs(ctx, "readonly", "", nil)
+	require.NoError(t, err)
+	readOnly.Features().ReadOnly = true
+
+	opt := &bisync.Options{Workdir: t.TempDir()}
+	err = bisync.Bisync(ctx, local, readOnly, opt)
+	assert.ErrorIs(t, err, fs.ErrorReadOnly)
+	err = bisync.Bisync(ctx, readOnly, local, opt)
+	assert.ErrorIs(t, err, fs.ErrorReadOnly)
+
+	// A dry run doesn't write anything so gets past the check
+	ctx, ci := fs.AddConfig(ctx)
+	ci.DryRun = true
+	opt.DryRun = true
+	err = bisync.Bisync(ctx, local, readOnly, opt)
+	assert.NotErrorIs(t, err, fs.ErrorReadOnly)
+}
+
 // TestBisync is a test engine for bisync test cases.
 func testBisync(t *testing.T, path1, path2 string) {
 	ctx := context.Background()
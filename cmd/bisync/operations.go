@@ -606,6 +606,13 @@ func (b *bisyncRun) checkSyntax() error {
 		return fmt.Errorf(Color(terminal.RedFg, `detected an odd number of quotes in your path(s). This is usually a mistake indicating incorrect escaping.
 			 Please check your command and try again. Note that on Windows, quoted paths must not have a trailing slash, or it will be interpreted as escaping the quote. path1: %v path2: %v`), path1, path2)
 	}
+	// check both paths can be written to before listing them,
+	// unless this is a dry run which won't write anything
+	for _, f := range []fs.Fs{b.fs1, b.fs2} {
+		if f.Features().ReadOnly && !b.opt.DryRun {
+			return fmt.Errorf("%s: %w", bilib.FsPath(f), fs.ErrorReadOnly)
+		}
+	}
 	// check for other syntax issues
 	_, err = os.Stat(b.basePath)
 	if err != nil {
@@ -0,0 +1,281 @@
+// Package kopia serves a remote suitable for use as kopia repository storage
+package kopia
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/rclone/rclone/cmd"
+	"github.com/rclone/rclone/cmd/serve/webdav"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/flags"
+	"github.com/rclone/rclone/fs/hash"
+	libhttp "github.com/rclone/rclone/lib/http"
+	"github.com/rclone/rclone/lib/systemd"
+	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfsflags"
+	"github.com/spf13/cobra"
+)
+
+// Options required for http server
+type Options struct {
+	Auth       libhttp.AuthConfig
+	HTTP       libhttp.Config
+	AppendOnly bool
+}
+
+// DefaultOpt is the default values used for Options
+var DefaultOpt = Options{
+	Auth: libhttp.DefaultAuthCfg(),
+	HTTP: libhttp.DefaultCfg(),
+}
+
+// Opt is options set by command line flags
+var Opt = DefaultOpt
+
+// flagPrefix is the prefix used to uniquely identify command line flags.
+// It is intentionally empty for this package.
+const flagPrefix = ""
+
+func init() {
+	flagSet := Command.Flags()
+	libhttp.AddAuthFlagsPrefix(flagSet, flagPrefix, &Opt.Auth)
+	libhttp.AddHTTPFlagsPrefix(flagSet, flagPrefix, &Opt.HTTP)
+	vfsflags.AddFlags(flagSet)
+	flags.BoolVarP(flagSet, &Opt.AppendOnly, "append-only", "", false, "Disallow deletion and overwriting of pack blobs", "")
+}
+
+// Command definition for cobra
+var Command = &cobra.Command{
+	Use:   "kopia remote:path",
+	Short: `Serve the remote as storage for kopia repositories.`,
+	Long: `Run a basic web server to serve a remote as the storage of a kopia
+repository. This allows kopia to back up into any cloud provider
+rclone supports without running a separate gateway.
+
+[Kopia](https://kopia.io/) is a command-line program for doing
+backups.
+
+The remote is served over WebDAV, which kopia's webdav storage speaks,
+using the same server as ` + "`rclone serve webdav`" + `. Blob
+timestamps are the modification times of the files, which kopia uses
+to decide when unreferenced blobs can safely be deleted.
+
+The server will log errors.  Use -v to see access logs.
+
+` + "`--bwlimit`" + ` will be respected for file transfers.
+Use ` + "`--stats`" + ` to control the stats printing.
+
+### Setting up kopia to use rclone ###
+
+Start the server, preferably with authentication
+
+    rclone serve kopia -v remote:backup --user kopia --pass secret
+
+Then create or connect to the repository with kopia's webdav storage
+
+    kopia repository create webdav --url http://localhost:8080/ \
+        --webdav-username kopia --webdav-password secret
+
+By default this will serve on "localhost:8080" you can change this
+with use of the ` + "`--addr`" + ` flag.
+
+#### Append only ####
+
+With ` + "`--append-only`" + ` pack blobs, which hold the backed up data,
+can't be deleted, overwritten or renamed, so a compromised client
+can't destroy existing backups. Pack blobs are the files named after a
+blob ID starting with "p" or "q" followed by hex digits, such as
+"p0a1/b2c/3d4e5f.f" when kopia shards them into directories.
+Directories which aren't empty can't be deleted or renamed either.
+
+Kopia must still be able to replace its index, session and log blobs
+so these are unaffected. Run maintenance with a server started without
+` + "`--append-only`" + `.
+
+` + libhttp.Help(flagPrefix) + libhttp.AuthHelp(flagPrefix) + vfs.Help(),
+	Annotations: map[string]string{
+		"versionIntroduced": "v1.68",
+	},
+	Run: func(command *cobra.Command, args []string) {
+		ctx := context.Background()
+		cmd.CheckArgs(1, 1, command, args)
+		f := cmd.NewFsSrc(args)
+		cmd.Run(false, true, command, func() error {
+			s, err := newServer(ctx, f, &Opt)
+			if err != nil {
+				return err
+			}
+			s.Serve()
+			fs.Logf(f, "Serving kopia repository storage on %s", s.URLs())
+
+			defer systemd.Notify()()
+			s.Wait()
+
+			return nil
+		})
+	},
+}
+
+// server is the WebDAV server with the kopia specific checks
+type server struct {
+	*webdav.WebDAV
+	opt  Options
+	base string // BaseURL the server is at, "" for the root
+}
+
+// newServer makes the WebDAV server to serve f with opt
+func newServer(ctx context.Context, f fs.Fs, opt *Options) (s *server, err error) {
+	s = &server{
+		opt:  *opt,
+		base: strings.Trim(opt.HTTP.BaseURL, "/"),
+	}
+	davOpt := webdav.DefaultOpt
+	davOpt.Auth = opt.Auth
+	davOpt.HTTP = opt.HTTP
+	davOpt.HashType = hash.None
+	davOpt.DisableGETDir = true
+	var middlewares []func(http.Handler) http.Handler
+	if opt.AppendOnly {
+		middlewares = append(middlewares, s.appendOnly)
+	}
+	s.WebDAV, err = webdav.New(ctx, f, &davOpt, middlewares...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init server: %w", err)
+	}
+	return s, nil
+}
+
+// packBlobID matches the IDs of the blobs holding the backed up data.
+//
+// Blobs written in a session have the session ID and a counter after
+// the hex digits.
+var packBlobID = regexp.MustCompile(`^[pq][0-9a-f]+(-s[0-9a-f]+-c[0-9]+)?$`)
+
+// isPackBlob returns true if remote is a blob holding backed up data
+// which must not be changed with --append-only.
+//
+// Kopia stores blobs with a ".f" suffix, sharding them into
+// directories named after the start of the blob ID, so the ID is the
+// leaf name joined to the directories it is in. As "p" and "q" aren't
+// hex digits only the whole ID can start with them.
+func isPackBlob(remote string) bool {
+	name, ok := strings.CutSuffix(remote, ".f")
+	if !ok {
+		return false
+	}
+	parts := strings.Split(name, "/")
+	id := ""
+	for i := len(parts) - 1; i >= 0; i-- {
+		id = parts[i] + id
+		if packBlobID.MatchString(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// errAppendOnly is returned for requests refused with --append-only
+var errAppendOnly = errors.New("not allowed in append-only mode")
+
+// appendOnly refuses requests which would change or remove pack blobs
+func (s *server) appendOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remote := strings.Trim(path.Clean("/"+r.URL.Path), "/")
+		if err := s.checkAppendOnly(r, remote); err != nil {
+			fs.Errorf(remote, "%s request refused: %v", r.Method, err)
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkAppendOnly returns an error if the request for remote would
+// change or remove a pack blob, or a directory which may hold them
+func (s *server) checkAppendOnly(r *http.Request, remote string) error {
+	ctx := r.Context()
+	switch r.Method {
+	case "PUT", "PROPPATCH":
+		if isPackBlob(remote) && s.exists(ctx, remote) {
+			return fmt.Errorf("pack blob already exists: %w", errAppendOnly)
+		}
+	case "DELETE":
+		return s.checkRemove(ctx, remote)
+	case "MOVE", "COPY":
+		if r.Method == "MOVE" {
+			if err := s.checkRemove(ctx, remote); err != nil {
+				return err
+			}
+		}
+		dst, ok := s.destination(r)
+		if !ok {
+			// the WebDAV handler rejects the request
+			return nil
+		}
+		if s.exists(ctx, dst) {
+			// the destination would be replaced
+			return s.checkRemove(ctx, dst)
+		}
+	}
+	return nil
+}
+
+// checkRemove returns an error if remote is a pack blob or a
+// directory which isn't empty, so can't be removed or renamed away
+func (s *server) checkRemove(ctx context.Context, remote string) (err error) {
+	if isPackBlob(remote) {
+		return fmt.Errorf("pack blobs can't be removed: %w", errAppendOnly)
+	}
+	if !s.isDir(ctx, remote) {
+		return nil
+	}
+	dir, err := s.OpenFile(ctx, remote, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer fs.CheckClose(dir, &err)
+	_, err = dir.Readdir(1)
+	if err == io.EOF {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return fmt.Errorf("directory isn't empty: %w", errAppendOnly)
+}
+
+// exists returns true if there is a file or directory at remote
+func (s *server) exists(ctx context.Context, remote string) bool {
+	_, err := s.Stat(ctx, remote)
+	return err == nil
+}
+
+// isDir returns true if remote is a directory
+func (s *server) isDir(ctx context.Context, remote string) bool {
+	fi, err := s.Stat(ctx, remote)
+	return err == nil && fi.IsDir()
+}
+
+// destination returns the remote in the Destination header of r
+func (s *server) destination(r *http.Request) (remote string, ok bool) {
+	u, err := url.Parse(r.Header.Get("Destination"))
+	if err != nil || u.Path == "" {
+		return "", false
+	}
+	p := path.Clean("/" + u.Path)
+	if s.base != "" {
+		p, ok = strings.CutPrefix(p, "/"+s.base)
+		if !ok {
+			return "", false
+		}
+	}
+	return strings.Trim(p, "/"), true
+}
@@ -0,0 +1,145 @@
+package kopia
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	_ "github.com/rclone/rclone/backend/memory"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/random"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testRequest is an HTTP request and the status and body expected
+type testRequest struct {
+	method string
+	path   string
+	body   string
+	header map[string]string
+	code   int
+	want   string // checked if set
+}
+
+// runRequests sends the requests in turn to a server for a new
+// memory remote, named at random as the VFS for a remote is reused
+func runRequests(t *testing.T, opt Options, reqs []testRequest) {
+	ctx := context.Background()
+	f, err := fs.NewFs(ctx, ":memory:"+random.String(16))
+	require.NoError(t, err)
+	opt.Auth = DefaultOpt.Auth
+	opt.HTTP = DefaultOpt.HTTP
+	opt.HTTP.ListenAddr = []string{"localhost:0"}
+	opt.HTTP.BaseURL = "/repo"
+	s, err := newServer(ctx, f, &opt)
+	require.NoError(t, err)
+	s.Serve()
+	defer func() {
+		assert.NoError(t, s.Shutdown())
+		s.Wait()
+	}()
+	testURL := strings.TrimSuffix(s.URLs()[0], "/")
+
+	for _, test := range reqs {
+		req, err := http.NewRequest(test.method, testURL+test.path, strings.NewReader(test.body))
+		require.NoError(t, err)
+		for k, v := range test.header {
+			if k == "Destination" && strings.HasPrefix(v, "/") {
+				v = testURL + v
+			}
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		what := test.method + " " + test.path
+		assert.Equal(t, test.code, resp.StatusCode, what)
+		if test.want != "" {
+			assert.Contains(t, string(body), test.want, what)
+		}
+	}
+}
+
+func TestKopiaHandler(t *testing.T) {
+	runRequests(t, Options{}, []testRequest{
+		{method: "GET", path: "/kopia.repository.f", code: http.StatusNotFound},
+		{method: "PUT", path: "/kopia.repository.f", body: "format", code: http.StatusCreated},
+		{method: "GET", path: "/kopia.repository.f", code: http.StatusOK, want: "format"},
+		{method: "GET", path: "/kopia.repository.f", header: map[string]string{"Range": "bytes=1-3"}, code: http.StatusPartialContent, want: "orm"},
+		{method: "MKCOL", path: "/p12", code: http.StatusCreated},
+		{method: "MKCOL", path: "/p12/3ab", code: http.StatusCreated},
+		{method: "PUT", path: "/p12/3ab/cdef.f-1234", body: "pack", code: http.StatusCreated},
+		{method: "MOVE", path: "/p12/3ab/cdef.f-1234", header: map[string]string{"Destination": "/p12/3ab/cdef.f"}, code: http.StatusCreated},
+		{method: "GET", path: "/p12/3ab/cdef.f-1234", code: http.StatusNotFound},
+		{method: "PROPFIND", path: "/", header: map[string]string{"Depth": "1"}, code: http.StatusMultiStatus, want: "<D:href>/repo/p12/</D:href>"},
+		{method: "PROPFIND", path: "/p12/3ab/", header: map[string]string{"Depth": "1"}, code: http.StatusMultiStatus, want: "<D:getcontentlength>4</D:getcontentlength>"},
+		{method: "PROPFIND", path: "/missing", header: map[string]string{"Depth": "1"}, code: http.StatusNotFound},
+		{method: "MKCOL", path: "/n12", code: http.StatusCreated},
+		{method: "PUT", path: "/n12/n12cd.f", body: "index", code: http.StatusCreated},
+		{method: "MOVE", path: "/kopia.repository.f", header: map[string]string{"Destination": "/n12/n12cd.f", "Overwrite": "F"}, code: http.StatusPreconditionFailed},
+		{method: "DELETE", path: "/p12/3ab/cdef.f", code: http.StatusNoContent},
+		{method: "GET", path: "/p12/3ab/cdef.f", code: http.StatusNotFound},
+	})
+}
+
+func TestKopiaHandlerAppendOnly(t *testing.T) {
+	runRequests(t, Options{AppendOnly: true}, []testRequest{
+		// new packs are written to a temporary name then renamed
+		{method: "MKCOL", path: "/p12", code: http.StatusCreated},
+		{method: "MKCOL", path: "/p12/3ab", code: http.StatusCreated},
+		{method: "PUT", path: "/p12/3ab/cdef.f-1234", body: "pack", code: http.StatusCreated},
+		{method: "MOVE", path: "/p12/3ab/cdef.f-1234", header: map[string]string{"Destination": "/p12/3ab/cdef.f"}, code: http.StatusCreated},
+
+		// but can't be replaced
+		{method: "PUT", path: "/p12/3ab/cdef.f", body: "other", code: http.StatusForbidden},
+		{method: "PUT", path: "/p12/3ab/cdef.f-5678", body: "other", code: http.StatusCreated},
+		{method: "MOVE", path: "/p12/3ab/cdef.f-5678", header: map[string]string{"Destination": "/p12/3ab/cdef.f"}, code: http.StatusForbidden},
+		{method: "COPY", path: "/p12/3ab/cdef.f-5678", header: map[string]string{"Destination": "/p12/3ab/cdef.f"}, code: http.StatusForbidden},
+
+		// or removed, either directly or by renaming them away
+		{method: "DELETE", path: "/p12/3ab/cdef.f", code: http.StatusForbidden},
+		{method: "MOVE", path: "/p12/3ab/cdef.f", header: map[string]string{"Destination": "/p12/3ab/cdef.f-9"}, code: http.StatusForbidden},
+		{method: "DELETE", path: "/p12/", code: http.StatusForbidden},
+		{method: "MOVE", path: "/p12/", header: map[string]string{"Destination": "/x12/"}, code: http.StatusForbidden},
+		{method: "GET", path: "/p12/3ab/cdef.f", code: http.StatusOK, want: "pack"},
+
+		// temporary files starting with p are left alone
+		{method: "DELETE", path: "/p12/3ab/cdef.f-5678", code: http.StatusNoContent},
+		{method: "PUT", path: "/prefs.txt", body: "a", code: http.StatusCreated},
+		{method: "PUT", path: "/prefs.txt", body: "b", code: http.StatusCreated},
+		{method: "DELETE", path: "/prefs.txt", code: http.StatusNoContent},
+
+		// index and session blobs can still be replaced and removed
+		{method: "MKCOL", path: "/n12", code: http.StatusCreated},
+		{method: "PUT", path: "/n12/n12cd.f", body: "index", code: http.StatusCreated},
+		{method: "PUT", path: "/n12/n12cd.f", body: "index2", code: http.StatusCreated},
+		{method: "DELETE", path: "/n12/n12cd.f", code: http.StatusNoContent},
+	})
+}
+
+func TestIsPackBlob(t *testing.T) {
+	for _, test := range []struct {
+		remote string
+		want   bool
+	}{
+		{"p0a1b2c3d4e5f.f", true},
+		{"q0a1b2c3d4e5f.f", true},
+		{"p0a/1b2/c3d4e5f.f", true},
+		{"repo/p0a/1b2/c3d4e5f.f", true},
+		{"p0a1b2c3d4e5f-s0123abcd-c1.f", true},
+		{"p0a/1b2/c3d4e5f-s0123abcd-c1.f", true},
+		{"p0a/1b2/c3d4e5f.f-1234", false},
+		{"p0a1b2c3d4e5f", false},
+		{"prefs.f", false},
+		{"n0a1b2c3d4e5f.f", false},
+		{"xn0_0a1b2c3d4e5f.f", false},
+		{"kopia.repository.f", false},
+	} {
+		assert.Equal(t, test.want, isPackBlob(test.remote), test.remote)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/rclone/rclone/cmd/serve/docker"
 	"github.com/rclone/rclone/cmd/serve/ftp"
 	"github.com/rclone/rclone/cmd/serve/http"
+	"github.com/rclone/rclone/cmd/serve/kopia"
 	"github.com/rclone/rclone/cmd/serve/nfs"
 	"github.com/rclone/rclone/cmd/serve/restic"
 	"github.com/rclone/rclone/cmd/serve/s3"
@@ -25,6 +26,9 @@ func init() {
 	if restic.Command != nil {
 		Command.AddCommand(restic.Command)
 	}
+	if kopia.Command != nil {
+		Command.AddCommand(kopia.Command)
+	}
 	if dlna.Command != nil {
 		Command.AddCommand(dlna.Command)
 	}
@@ -154,7 +154,7 @@ done by the permissions on the socket.
 			fs.Debugf(f, "Using hash %v for ETag", Opt.HashType)
 		}
 		cmd.Run(false, false, command, func() error {
-			s, err := newWebDAV(context.Background(), f, &Opt)
+			s, err := New(context.Background(), f, &Opt)
 			if err != nil {
 				return err
 			}
@@ -195,8 +195,12 @@ type WebDAV struct {
 // check interface
 var _ webdav.FileSystem = (*WebDAV)(nil)
 
-// Make a new WebDAV to serve the remote
-func newWebDAV(ctx context.Context, f fs.Fs, opt *Options) (w *WebDAV, err error) {
+// New makes a WebDAV server for the remote.
+//
+// The middlewares are run before each request is handled, which lets
+// other serve commands speaking WebDAV build on this one. Call Serve
+// to start the server.
+func New(ctx context.Context, f fs.Fs, opt *Options, middlewares ...func(http.Handler) http.Handler) (w *WebDAV, err error) {
 	w = &WebDAV{
 		f:   f,
 		ctx: ctx,
@@ -235,6 +239,7 @@ func newWebDAV(ctx context.Context, f fs.Fs, opt *Options) (w *WebDAV, err error
 		middleware.SetHeader("Accept-Ranges", "bytes"),
 		middleware.SetHeader("Server", "rclone/"+fs.Version),
 	)
+	router.Use(middlewares...)
 
 	router.Handle("/*", w)
 
@@ -57,7 +57,7 @@ func TestWebDav(t *testing.T) {
 		opt.HashType = hash.MD5
 
 		// Start the server
-		w, err := newWebDAV(context.Background(), f, &opt)
+		w, err := New(context.Background(), f, &opt)
 		require.NoError(t, err)
 		require.NoError(t, w.serve())
 
@@ -103,7 +103,7 @@ func TestHTTPFunction(t *testing.T) {
 	opt.Template.Path = testTemplate
 
 	// Start the server
-	w, err := newWebDAV(context.Background(), f, &opt)
+	w, err := New(context.Background(), f, &opt)
 	assert.NoError(t, err)
 	require.NoError(t, w.serve())
 	defer func() {
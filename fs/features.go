@@ -39,6 +39,7 @@ type Features struct {
 	NoMultiThreading         bool // set if can't have multiplethreads on one download open
 	Overlay                  bool // this wraps one or more backends to add functionality
 	ChunkWriterDoesntSeek    bool // set if the chunk writer doesn't need to read the data more than once
+	ReadOnly                 bool // can't be written to at all so syncing to it fails
 
 	// Purge all files in the directory specified
 	//
@@ -161,6 +162,13 @@ type Features struct {
 	// About gets quota information from the Fs
 	About func(ctx context.Context) (*Usage, error)
 
+	// DirSize returns the number of objects in dir and all its
+	// subdirectories and their total size without listing them.
+	//
+	// It should return ErrorNotImplemented if the totals aren't
+	// known for dir, in which case it will be listed instead.
+	DirSize func(ctx context.Context, dir string) (objects int64, size int64, err error)
+
 	// OpenWriterAt opens with a handle for random access writes
 	//
 	// Pass in the remote desired and the size if known.
@@ -329,6 +337,9 @@ func (ft *Features) Fill(ctx context.Context, f Fs) *Features {
 	if do, ok := f.(Abouter); ok {
 		ft.About = do.About
 	}
+	if do, ok := f.(DirSizer); ok {
+		ft.DirSize = do.DirSize
+	}
 	if do, ok := f.(OpenWriterAter); ok {
 		ft.OpenWriterAt = do.OpenWriterAt
 	}
@@ -383,6 +394,7 @@ func (ft *Features) Mask(ctx context.Context, f Fs) *Features {
 	ft.PartialUploads = ft.PartialUploads && mask.PartialUploads
 	ft.NoMultiThreading = ft.NoMultiThreading && mask.NoMultiThreading
 	// ft.Overlay = ft.Overlay && mask.Overlay don't propagate Overlay
+	// ft.ReadOnly = ft.ReadOnly || mask.ReadOnly don't propagate ReadOnly as union and combine may have writable upstreams
 
 	if mask.Purge == nil {
 		ft.Purge = nil
@@ -435,6 +447,9 @@ func (ft *Features) Mask(ctx context.Context, f Fs) *Features {
 	if mask.About == nil {
 		ft.About = nil
 	}
+	if mask.DirSize == nil {
+		ft.DirSize = nil
+	}
 	if mask.OpenWriterAt == nil {
 		ft.OpenWriterAt = nil
 	}
@@ -679,6 +694,16 @@ type Abouter interface {
 	About(ctx context.Context) (*Usage, error)
 }
 
+// DirSizer is an optional interface for Fs
+type DirSizer interface {
+	// DirSize returns the number of objects in dir and all its
+	// subdirectories and their total size without listing them.
+	//
+	// It should return ErrorNotImplemented if the totals aren't
+	// known for dir, in which case it will be listed instead.
+	DirSize(ctx context.Context, dir string) (objects int64, size int64, err error)
+}
+
 // OpenWriterAter is an optional interface for Fs
 type OpenWriterAter interface {
 	// OpenWriterAt opens with a handle for random access writes
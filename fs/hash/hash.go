@@ -87,6 +87,9 @@ var (
 
 	// SHA256 indicates SHA-256 support
 	SHA256 Type
+
+	// CRC32C indicates CRC-32C (Castagnoli) support
+	CRC32C Type
 )
 
 func init() {
@@ -95,6 +98,7 @@ func init() {
 	Whirlpool = RegisterHash("whirlpool", "Whirlpool", 128, whirlpool.New)
 	CRC32 = RegisterHash("crc32", "CRC-32", 8, func() hash.Hash { return crc32.NewIEEE() })
 	SHA256 = RegisterHash("sha256", "SHA-256", 64, sha256.New)
+	CRC32C = RegisterHash("crc32c", "CRC-32C", 8, func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) })
 }
 
 // Supported returns a set of all the supported hashes by
@@ -1008,8 +1008,17 @@ func HashSumStream(ht hash.Type, outputBase64 bool, in io.ReadCloser, w io.Write
 
 // Count counts the objects and their sizes in the Fs
 //
-// Obeys includes and excludes
+// Obeys includes and excludes. If there aren't any and --max-depth
+// isn't set then DirSize is used if the Fs supports it rather than
+// listing everything.
 func Count(ctx context.Context, f fs.Fs) (objects int64, size int64, sizelessObjects int64, err error) {
+	if doDirSize := f.Features().DirSize; doDirSize != nil && filter.GetConfig(ctx).InActive() && fs.GetConfig(ctx).MaxDepth < 0 {
+		objects, size, err = doDirSize(ctx, "")
+		if !errors.Is(err, fs.ErrorNotImplemented) {
+			return objects, size, 0, err
+		}
+		fs.Debugf(f, "Totals not available, counting by listing")
+	}
 	err = ListFn(ctx, f, func(o fs.Object) {
 		atomic.AddInt64(&objects, 1)
 		objectSize := o.Size()
@@ -490,6 +490,7 @@ This returns info about the remote passed in;
                 "whirlpool",
                 "crc32",
                 "sha256",
+                "crc32c",
                 "dropbox",
                 "mailru",
                 "quickxor"
@@ -130,6 +130,9 @@ func newSyncCopyMove(ctx context.Context, fdst, fsrc fs.Fs, deleteMode fs.Delete
 		return nil, fserrors.FatalError(fs.ErrorOverlapping)
 	}
 	ci := fs.GetConfig(ctx)
+	if fdst.Features().ReadOnly && !ci.DryRun {
+		return nil, fserrors.FatalError(fs.ErrorReadOnly)
+	}
 	fi := filter.GetConfig(ctx)
 	s := &syncCopyMove{
 		ci:                     ci,
@@ -27,6 +27,7 @@ import (
 	"github.com/rclone/rclone/fs/hash"
 	"github.com/rclone/rclone/fs/operations"
 	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/fstest/mockfs"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/text/unicode/norm"
@@ -1833,6 +1834,27 @@ func TestServerSideMoveOverlap(t *testing.T) {
 	// testLoggerVsLsf(ctx, r.Fremote, operations.GetLoggerOpt(ctx).JSON, t)
 }
 
+// Test a sync to a read-only remote fails straight away
+func TestSyncReadOnlyDestination(t *testing.T) {
+	ctx := context.Background()
+	r := fstest.NewRun(t)
+	r.WriteFile("potato", "hello", t1)
+
+	fdst, err := mockfs.NewFs(ctx, "readonly", "", nil)
+	require.NoError(t, err)
+	fdst.Features().ReadOnly = true
+
+	err = Sync(ctx, fdst, r.Flocal, false)
+	assert.ErrorIs(t, err, fs.ErrorReadOnly)
+	assert.True(t, fserrors.IsFatalError(err))
+
+	// A dry run doesn't write anything so is allowed
+	ctx, ci := fs.AddConfig(ctx)
+	ci.DryRun = true
+	err = Sync(ctx, fdst, r.Flocal, false)
+	assert.NoError(t, err)
+}
+
 // Test a sync with overlap
 func TestSyncOverlap(t *testing.T) {
 	ctx := context.Background()
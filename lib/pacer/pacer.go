@@ -2,6 +2,7 @@
 package pacer
 
 import (
+	"context"
 	"sync"
 	"time"
 
@@ -152,13 +153,34 @@ func (p *Pacer) ModifyCalculator(f func(Calculator)) {
 //
 // This waits for the pacer token
 func (p *Pacer) beginCall() {
+	_ = p.beginCallContext(context.Background())
+}
+
+// beginCallContext is beginCall but stops waiting for the tokens if
+// ctx is cancelled, returning the context's error.
+//
+// If this returns an error endCall must not be called.
+func (p *Pacer) beginCallContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	// pacer starts with a token in and whenever we take one out
 	// XXX ms later we put another in.  We could do this with a
 	// Ticker more accurately, but then we'd have to work out how
 	// not to run it when it wasn't needed
-	<-p.pacer
+	select {
+	case <-p.pacer:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 	if p.maxConnections > 0 {
-		<-p.connTokens
+		select {
+		case <-p.connTokens:
+		case <-ctx.Done():
+			// give back the pacer token as the timer isn't running
+			p.pacer <- struct{}{}
+			return ctx.Err()
+		}
 	}
 
 	p.mu.Lock()
@@ -168,6 +190,7 @@ func (p *Pacer) beginCall() {
 		p.pacer <- struct{}{}
 	}(p.state.SleepTime)
 	p.mu.Unlock()
+	return nil
 }
 
 // endCall implements the pacing algorithm
@@ -191,9 +214,16 @@ func (p *Pacer) endCall(retry bool, err error) {
 
 // call implements Call but with settable retries
 func (p *Pacer) call(fn Paced, retries int) (err error) {
+	return p.callContext(context.Background(), fn, retries)
+}
+
+// callContext implements CallContext but with settable retries
+func (p *Pacer) callContext(ctx context.Context, fn Paced, retries int) (err error) {
 	var retry bool
 	for i := 1; i <= retries; i++ {
-		p.beginCall()
+		if err := p.beginCallContext(ctx); err != nil {
+			return err
+		}
 		retry, err = p.invoker(i, retries, fn)
 		p.endCall(retry, err)
 		if !retry {
@@ -216,6 +246,16 @@ func (p *Pacer) Call(fn Paced) (err error) {
 	return p.call(fn, retries)
 }
 
+// CallContext is Call but stops as soon as ctx is cancelled, rather
+// than after sleeping for the next retry, returning the context's
+// error.
+func (p *Pacer) CallContext(ctx context.Context, fn Paced) (err error) {
+	p.mu.Lock()
+	retries := p.retries
+	p.mu.Unlock()
+	return p.callContext(ctx, fn, retries)
+}
+
 // CallNoRetry paces the remote operations to not exceed the limits
 // and return a retry error on rate limit exceeded
 //
@@ -1,6 +1,7 @@
 package pacer
 
 import (
+	"context"
 	"errors"
 	"sync"
 	"testing"
@@ -350,3 +351,39 @@ func TestCallParallel(t *testing.T) {
 	assert.Equal(t, 5, called)
 	wait.Broadcast()
 }
+
+func TestCallContext(t *testing.T) {
+	p := New(RetriesOption(10), CalculatorOption(NewDefault(MinSleep(10*time.Second), MaxSleep(10*time.Second))))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	called := 0
+	start := time.Now()
+	err := p.CallContext(ctx, func() (bool, error) {
+		called++
+		cancel()
+		return true, errFoo
+	})
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 1, called)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	// doesn't call at all if already cancelled
+	err = p.CallContext(ctx, func() (bool, error) {
+		called++
+		return false, nil
+	})
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 1, called)
+}
+
+func TestBeginCallContextConnections(t *testing.T) {
+	p := New(MaxConnectionsOption(1), CalculatorOption(NewDefault(MinSleep(1*time.Millisecond))))
+	emptyTokens(p)
+	p.pacer <- struct{}{}
+
+	// the pacer token is given back if cancelled waiting for a connection
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, p.beginCallContext(ctx))
+	assert.False(t, waitForPace(p, 10*time.Millisecond).IsZero())
+}